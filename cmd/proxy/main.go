@@ -2,12 +2,31 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
 )
 
 func main() {
+	var configPath string
+	var chainID uint64
+	var chainIDCheckInterval time.Duration
+	var retryAttempts int
+	var retryBackoff time.Duration
+	var retryBudget time.Duration
+	var retryErrors string
+	var retryUnsafeMethods string
+	var circuitBreaker bool
+	var circuitBreakerErrorRate float64
+	var circuitBreakerWindow int
+	var circuitBreakerFailures int
+	var circuitBreakerCooldown time.Duration
 	var port string
 	var proxyURL string
 	var proxyMethod string
@@ -16,8 +35,68 @@ func main() {
 	var leakyBucketLimitPerSecond int
 	var softCapIPRequestsPerMinute int
 	var hardCapIPRequestsPerMinute int
+	var ipRequestsPerSecond int
+	var ipRequestsPerDay int
+	var ipBurst int
+	var ipMinuteBurst int
+	var ipDayBurst int
+	var rateLimitAlgorithm string
+	var loadBalancing string
+	var archiveBlocks int
+	var headPollInterval time.Duration
+	var trackHeads bool
+	var pinLatest bool
+	var serveHead bool
+	var headMaxAge time.Duration
+	var ipBanDuration time.Duration
+	var redisURL string
+	var storeCapacity int
+	var storeCleanupInterval time.Duration
+	var cacheCapacity int
+	var snapshotPath string
+	var snapshotInterval time.Duration
 	var slackWebhookURL string
 	var slackChannel string
+	var methodCosts string
+	var defaultMethodCost int
+	var maxBatchSize int
+	var batchChunkSize int
+	var blockedMethods string
+	var logsMaxBlockRange int
+	var logsMaxAddresses int
+	var logsMaxTopics int
+	var logsChunking bool
+	var logsChunkConcurrency int
+	var logsMaxChunks int
+	var broadcast bool
+	var broadcastURLs string
+	var txAllowedSenders string
+	var txDeniedSenders string
+	var txAllowedRecipients string
+	var txDeniedRecipients string
+	var txAllowedChainIDs string
+	var txMaxValue string
+	var txMaxGasPrice string
+	var journalPath string
+	var journalCheckInterval time.Duration
+	var journalDropTimeout time.Duration
+	var journalRetention time.Duration
+	var adminSecret string
+	var recordPath string
+	var recordRedact string
+	var replayPath string
+	var emulateFilters bool
+	var filterTimeout time.Duration
+	var maxFiltersPerIP int
+	var hedgedMethods string
+	var hedgePercentile int
+	var hedgeDelay time.Duration
+	var cachedMethods string
+	var shadowURL string
+	var shadowMethods string
+	var shadowTimeout time.Duration
+	var quorumMethods string
+	var quorumTimeout time.Duration
 
 	portEnv := os.Getenv("PORT")
 	if portEnv != "" {
@@ -26,20 +105,127 @@ func main() {
 
 	authSecretEnv := os.Getenv("AUTH_SECRET")
 
+	flag.StringVar(&configPath, "config", configPath, "JSON config file, used instead of the other flags")
 	flag.StringVar(&port, "port", "8000", "Server port")
 	flag.StringVar(&proxyURL, "proxy-url", "", "Proxy URL")
+	flag.Uint64Var(&chainID, "chain-id", chainID, "Expected chain ID of the upstream, checked on start")
+	flag.IntVar(&retryAttempts, "retry-attempts", retryAttempts, "Total attempts for a failed upstream request (default 3)")
+	flag.DurationVar(&retryBackoff, "retry-backoff", retryBackoff, "Base of the exponential backoff between attempts (default 50ms)")
+	flag.DurationVar(&retryBudget, "retry-budget", retryBudget, "Time after which no new attempt is started (default 10s)")
+	flag.StringVar(&retryErrors, "retry-errors", retryErrors, "Comma separated JSON-RPC error messages that are retried (default header not found,unknown block,missing trie node)")
+	flag.StringVar(&retryUnsafeMethods, "retry-unsafe-methods", retryUnsafeMethods, "Comma separated methods other than reads that are retried anyway")
+	flag.BoolVar(&circuitBreaker, "circuit-breaker", circuitBreaker, "Stop sending requests to upstreams that keep failing")
+	flag.Float64Var(&circuitBreakerErrorRate, "circuit-breaker-error-rate", circuitBreakerErrorRate, "Share of failed recent requests that opens the circuit (default 0.5)")
+	flag.IntVar(&circuitBreakerWindow, "circuit-breaker-window", circuitBreakerWindow, "Number of recent requests the error rate is computed over (default 20)")
+	flag.IntVar(&circuitBreakerFailures, "circuit-breaker-failures", circuitBreakerFailures, "Consecutive failures that open the circuit (default 5)")
+	flag.DurationVar(&circuitBreakerCooldown, "circuit-breaker-cooldown", circuitBreakerCooldown, "How long the circuit stays open before a probe request (default 30s)")
+	flag.DurationVar(&chainIDCheckInterval, "chain-id-check-interval", chainIDCheckInterval, "Also check the chain ID periodically and eject mismatched upstreams, e.g. 5m")
 	flag.StringVar(&proxyMethod, "proxy-method", "", "Proxy method")
 	flag.StringVar(&logLevel, "log-level", "", "Log level")
 	flag.StringVar(&authorizationSecret, "auth-secret", authSecretEnv, "Authorization secret")
 	flag.IntVar(&leakyBucketLimitPerSecond, "limit-per-second", leakyBucketLimitPerSecond, "Leaky bucket limit per second")
 	flag.IntVar(&softCapIPRequestsPerMinute, "soft-cap-ip-requests-per-minute", softCapIPRequestsPerMinute, "Soft cap requests per minute for IP")
 	flag.IntVar(&hardCapIPRequestsPerMinute, "hard-cap-ip-requests-per-minute", hardCapIPRequestsPerMinute, "Hard cap requests per minute for IP")
+	flag.IntVar(&ipRequestsPerSecond, "ip-requests-per-second", ipRequestsPerSecond, "Limit requests per second for IP")
+	flag.IntVar(&ipRequestsPerDay, "ip-requests-per-day", ipRequestsPerDay, "Limit requests per day for IP")
+	flag.IntVar(&ipBurst, "ip-burst", ipBurst, "Token bucket burst size for IP of the shortest limit, per second if set or else per minute, defaults to the limit")
+	flag.IntVar(&ipMinuteBurst, "ip-minute-burst", ipMinuteBurst, "Token bucket burst size for IP of the per minute limit, defaults to the limit")
+	flag.IntVar(&ipDayBurst, "ip-day-burst", ipDayBurst, "Token bucket burst size for IP of the per day limit, defaults to the limit")
+	flag.StringVar(&loadBalancing, "load-balancing", loadBalancing, "Upstream load balancing: failover, round-robin, weighted or least-latency")
+	flag.IntVar(&archiveBlocks, "archive-blocks", archiveBlocks, "Requests for blocks further behind the head than this go to archive tagged upstreams (default 128)")
+	flag.DurationVar(&headPollInterval, "head-poll-interval", headPollInterval, "How often upstream head blocks are fetched when needed (default 12s)")
+	flag.BoolVar(&trackHeads, "track-heads", trackHeads, "Fetch the head block of every upstream and only send requests for a block to upstreams that have it")
+	flag.BoolVar(&pinLatest, "pin-latest", pinLatest, "Rewrite latest block parameters to the highest block every upstream has, implies -track-heads")
+	flag.BoolVar(&serveHead, "serve-head", serveHead, "Track the latest block and answer eth_blockNumber and eth_getBlockByNumber(\"latest\", false) from memory")
+	flag.DurationVar(&headMaxAge, "head-max-age", headMaxAge, "How recently the latest block must have been confirmed to be served from memory (default 15s)")
+	flag.StringVar(&rateLimitAlgorithm, "rate-limit-algorithm", rateLimitAlgorithm, "IP rate limit algorithm: sliding-window or token-bucket")
+	flag.DurationVar(&ipBanDuration, "ip-ban-duration", ipBanDuration, "Ban IPs that reach a hard cap for this long, e.g. 1h")
+	flag.StringVar(&redisURL, "redis-url", os.Getenv("REDIS_URL"), "Redis URL for sharing rate limits between replicas, e.g. redis://localhost:6379/0")
+	flag.IntVar(&storeCapacity, "store-capacity", storeCapacity, "Maximum number of rate limit keys kept in memory, least recently used keys are evicted")
+	flag.DurationVar(&storeCleanupInterval, "store-cleanup-interval", storeCleanupInterval, "Interval for removing expired rate limit keys from memory")
+	flag.IntVar(&cacheCapacity, "cache-capacity", cacheCapacity, "Maximum number of cached responses and filters, least recently used are evicted (default 10000)")
+	flag.StringVar(&snapshotPath, "snapshot-path", snapshotPath, "File to save rate limit counters and bans to on shutdown and restore them from on start")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", snapshotInterval, "Also save the snapshot periodically, e.g. 1m")
 	flag.StringVar(&slackWebhookURL, "slack-webhook-url", slackWebhookURL, "Slack Webhook URL")
 	flag.StringVar(&slackChannel, "slack-channel", slackChannel, "Slack channel for notifications")
+	flag.StringVar(&methodCosts, "method-costs", methodCosts, "Comma separated JSON-RPC method costs counted against IP caps, e.g. eth_getLogs=75,eth_call=26")
+	flag.IntVar(&defaultMethodCost, "default-method-cost", defaultMethodCost, "Cost of JSON-RPC methods without a configured cost")
+	flag.IntVar(&maxBatchSize, "max-batch-size", maxBatchSize, "Maximum number of requests in a JSON-RPC batch")
+	flag.IntVar(&batchChunkSize, "batch-chunk-size", batchChunkSize, "Split JSON-RPC batches into chunks of this size before forwarding")
+	flag.StringVar(&blockedMethods, "blocked-methods", blockedMethods, "Comma separated JSON-RPC methods that are rejected")
+	flag.IntVar(&logsMaxBlockRange, "logs-max-block-range", logsMaxBlockRange, "Maximum block range of eth_getLogs queries")
+	flag.IntVar(&logsMaxAddresses, "logs-max-addresses", logsMaxAddresses, "Maximum number of addresses in eth_getLogs queries")
+	flag.IntVar(&logsMaxTopics, "logs-max-topics", logsMaxTopics, "Maximum number of topics in eth_getLogs queries")
+	flag.BoolVar(&logsChunking, "logs-chunking", logsChunking, "Split eth_getLogs queries over the maximum block range into chunks instead of rejecting them")
+	flag.IntVar(&logsChunkConcurrency, "logs-chunk-concurrency", logsChunkConcurrency, "Number of eth_getLogs chunks sent at once (default 4)")
+	flag.IntVar(&logsMaxChunks, "logs-max-chunks", logsMaxChunks, "Maximum number of chunks an eth_getLogs query is split into, larger queries are rejected (default 10)")
+	flag.BoolVar(&broadcast, "broadcast", broadcast, "Send eth_sendRawTransaction to every available upstream at once")
+	flag.StringVar(&broadcastURLs, "broadcast-urls", broadcastURLs, "Comma separated URLs, e.g. private relays, to broadcast transactions to instead of the upstreams")
+	flag.StringVar(&txAllowedSenders, "tx-allowed-senders", txAllowedSenders, "Comma separated addresses that are the only ones allowed to send raw transactions")
+	flag.StringVar(&txDeniedSenders, "tx-denied-senders", txDeniedSenders, "Comma separated addresses whose raw transactions are rejected")
+	flag.StringVar(&txAllowedRecipients, "tx-allowed-recipients", txAllowedRecipients, "Comma separated addresses that are the only ones raw transactions may be sent to")
+	flag.StringVar(&txDeniedRecipients, "tx-denied-recipients", txDeniedRecipients, "Comma separated addresses that raw transactions may not be sent to")
+	flag.StringVar(&txAllowedChainIDs, "tx-allowed-chain-ids", txAllowedChainIDs, "Comma separated chain IDs that raw transactions must be signed for")
+	flag.StringVar(&txMaxValue, "tx-max-value", txMaxValue, "Maximum value in wei of raw transactions")
+	flag.StringVar(&txMaxGasPrice, "tx-max-gas-price", txMaxGasPrice, "Maximum gas price, or max fee per gas, in wei of raw transactions")
+	flag.StringVar(&journalPath, "journal-path", journalPath, "File to journal eth_sendRawTransaction transactions to, enabling status tracking and the /transactions endpoint")
+	flag.DurationVar(&journalCheckInterval, "journal-check-interval", journalCheckInterval, "How often pending journaled transactions are checked for receipts (default 15s)")
+	flag.DurationVar(&journalDropTimeout, "journal-drop-timeout", journalDropTimeout, "How long a transaction can be pending before it's marked dropped if upstreams no longer know it (default 1h)")
+	flag.DurationVar(&journalRetention, "journal-retention", journalRetention, "How long journaled transactions are kept (default 720h)")
+	flag.StringVar(&adminSecret, "admin-secret", adminSecret, "Secret required as a base64 encoded bearer token by the /transactions endpoint, which is only served when set")
+	flag.StringVar(&recordPath, "record-path", recordPath, "File to record upstream JSON-RPC requests and responses to, as JSON lines")
+	flag.StringVar(&recordRedact, "record-redact", recordRedact, "Comma separated values to redact from recordings, in addition to auth secrets and upstream URL keys")
+	flag.StringVar(&replayPath, "replay-path", replayPath, "File of recorded responses to serve instead of contacting upstreams")
+	flag.BoolVar(&emulateFilters, "emulate-filters", emulateFilters, "Answer filter methods in the proxy with eth_getLogs and eth_getBlockByNumber so filters work across upstreams")
+	flag.DurationVar(&filterTimeout, "filter-timeout", filterTimeout, "How long a filter lives without being polled (default 5m)")
+	flag.IntVar(&maxFiltersPerIP, "max-filters-per-ip", maxFiltersPerIP, "Maximum number of live filters per IP (default 100)")
+	flag.StringVar(&hedgedMethods, "hedged-methods", hedgedMethods, "Comma separated read-only JSON-RPC methods also sent to a second upstream when the first is slow, e.g. eth_call,eth_getBalance")
+	flag.IntVar(&hedgePercentile, "hedge-percentile", hedgePercentile, "Upstream latency percentile to wait for before hedging (default 95)")
+	flag.DurationVar(&hedgeDelay, "hedge-delay", hedgeDelay, "Delay before hedging until enough latencies are recorded (default 250ms)")
+	flag.StringVar(&cachedMethods, "cached-methods", cachedMethods, "Comma separated JSON-RPC methods to cache responses for, e.g. eth_chainId=1h,net_version=1h")
+	flag.StringVar(&shadowURL, "shadow-url", shadowURL, "Candidate upstream URL to mirror read traffic to in the background and compare responses with")
+	flag.StringVar(&shadowMethods, "shadow-methods", shadowMethods, "Comma separated JSON-RPC methods to mirror to the shadow upstream (default every read method)")
+	flag.DurationVar(&shadowTimeout, "shadow-timeout", shadowTimeout, "How long to wait for the shadow upstream (default 10s)")
+	flag.StringVar(&quorumMethods, "quorum-methods", quorumMethods, "Comma separated JSON-RPC methods sent to every upstream and the number that must agree, e.g. eth_getTransactionReceipt=2,eth_call=3")
+	flag.DurationVar(&quorumTimeout, "quorum-timeout", quorumTimeout, "How long to wait for upstreams to answer requests that require a quorum (default 10s)")
 	flag.Parse()
 
-	if proxyURL == "" {
-		panic("Flag -proxy-url is required")
+	if proxyURL == "" && configPath == "" {
+		panic("Flag -proxy-url or -config is required")
+	}
+
+	parsedMethodCosts, err := proxy.ParseMethodCosts(methodCosts)
+	if err != nil {
+		panic(err)
+	}
+
+	parsedCachedMethods, err := proxy.ParseMethodTTLs(cachedMethods)
+	if err != nil {
+		panic(err)
+	}
+
+	parsedQuorumMethods, err := proxy.ParseMethodQuorums(quorumMethods)
+	if err != nil {
+		panic(err)
+	}
+
+	var shadowUpstream *proxy.UpstreamConfig
+	if shadowURL != "" {
+		shadowUpstream = &proxy.UpstreamConfig{Name: "shadow", URL: shadowURL}
+	}
+
+	var parsedBroadcastUpstreams []*proxy.UpstreamConfig
+	for _, broadcastURL := range splitList(broadcastURLs) {
+		parsedBroadcastUpstreams = append(parsedBroadcastUpstreams, &proxy.UpstreamConfig{URL: broadcastURL})
+	}
+
+	var parsedTxAllowedChainIDs []uint64
+	for _, chainID := range splitList(txAllowedChainIDs) {
+		id, err := strconv.ParseUint(chainID, 10, 64)
+		if err != nil {
+			panic(fmt.Errorf("invalid chain ID %q", chainID))
+		}
+		parsedTxAllowedChainIDs = append(parsedTxAllowedChainIDs, id)
 	}
 
 	// add always allowed IPs here
@@ -58,8 +244,20 @@ func main() {
 		"70.185.111.46", // this ip keeps hitting hard cap on kovan proxy
 	}
 
-	rpcProxy := proxy.NewProxy(&proxy.Config{
+	config := &proxy.Config{
 		ProxyURL:                   proxyURL,
+		ChainID:                    chainID,
+		ChainIDCheckInterval:       proxy.Duration(chainIDCheckInterval),
+		RetryAttempts:              retryAttempts,
+		RetryBackoff:               proxy.Duration(retryBackoff),
+		RetryBudget:                proxy.Duration(retryBudget),
+		RetryErrors:                splitList(retryErrors),
+		RetryUnsafeMethods:         splitList(retryUnsafeMethods),
+		CircuitBreaker:             circuitBreaker,
+		CircuitBreakerErrorRate:    circuitBreakerErrorRate,
+		CircuitBreakerWindow:       circuitBreakerWindow,
+		CircuitBreakerFailures:     circuitBreakerFailures,
+		CircuitBreakerCooldown:     proxy.Duration(circuitBreakerCooldown),
 		ProxyMethod:                proxyMethod,
 		Port:                       port,
 		LogLevel:                   logLevel,
@@ -69,9 +267,110 @@ func main() {
 		LeakyBucketLimitPerSecond:  leakyBucketLimitPerSecond,
 		SoftCapIPRequestsPerMinute: softCapIPRequestsPerMinute,
 		HardCapIPRequestsPerMinute: hardCapIPRequestsPerMinute,
+		IPRequestsPerSecond:        ipRequestsPerSecond,
+		IPRequestsPerDay:           ipRequestsPerDay,
+		IPBurst:                    ipBurst,
+		IPMinuteBurst:              ipMinuteBurst,
+		IPDayBurst:                 ipDayBurst,
+		RateLimitAlgorithm:         rateLimitAlgorithm,
+		LoadBalancing:              loadBalancing,
+		ArchiveBlocks:              archiveBlocks,
+		HeadPollInterval:           proxy.Duration(headPollInterval),
+		TrackHeads:                 trackHeads,
+		PinLatest:                  pinLatest,
+		ServeHead:                  serveHead,
+		HeadMaxAge:                 proxy.Duration(headMaxAge),
+		IPBanDuration:              proxy.Duration(ipBanDuration),
+		RedisURL:                   redisURL,
+		StoreCapacity:              storeCapacity,
+		StoreCleanupInterval:       proxy.Duration(storeCleanupInterval),
+		CacheCapacity:              cacheCapacity,
+		SnapshotPath:               snapshotPath,
+		SnapshotInterval:           proxy.Duration(snapshotInterval),
 		SlackWebhookURL:            slackWebhookURL,
 		SlackChannel:               slackChannel,
-	})
+		MethodCosts:                parsedMethodCosts,
+		DefaultMethodCost:          defaultMethodCost,
+		MaxBatchSize:               maxBatchSize,
+		BatchChunkSize:             batchChunkSize,
+		BlockedMethods:             splitList(blockedMethods),
+		LogsMaxBlockRange:          logsMaxBlockRange,
+		LogsMaxAddresses:           logsMaxAddresses,
+		LogsMaxTopics:              logsMaxTopics,
+		LogsChunking:               logsChunking,
+		LogsChunkConcurrency:       logsChunkConcurrency,
+		LogsMaxChunks:              logsMaxChunks,
+		Broadcast:                  broadcast,
+		BroadcastUpstreams:         parsedBroadcastUpstreams,
+		TxAllowedSenders:           splitList(txAllowedSenders),
+		TxDeniedSenders:            splitList(txDeniedSenders),
+		TxAllowedRecipients:        splitList(txAllowedRecipients),
+		TxDeniedRecipients:         splitList(txDeniedRecipients),
+		TxAllowedChainIDs:          parsedTxAllowedChainIDs,
+		TxMaxValue:                 txMaxValue,
+		TxMaxGasPrice:              txMaxGasPrice,
+		JournalPath:                journalPath,
+		JournalCheckInterval:       proxy.Duration(journalCheckInterval),
+		JournalDropTimeout:         proxy.Duration(journalDropTimeout),
+		JournalRetention:           proxy.Duration(journalRetention),
+		AdminSecret:                adminSecret,
+		RecordPath:                 recordPath,
+		RecordRedact:               splitList(recordRedact),
+		ReplayPath:                 replayPath,
+		EmulateFilters:             emulateFilters,
+		FilterTimeout:              proxy.Duration(filterTimeout),
+		MaxFiltersPerIP:            maxFiltersPerIP,
+		HedgedMethods:              splitList(hedgedMethods),
+		HedgePercentile:            hedgePercentile,
+		HedgeDelay:                 proxy.Duration(hedgeDelay),
+		CachedMethods:              parsedCachedMethods,
+		ShadowUpstream:             shadowUpstream,
+		ShadowMethods:              splitList(shadowMethods),
+		ShadowTimeout:              proxy.Duration(shadowTimeout),
+		QuorumMethods:              parsedQuorumMethods,
+		QuorumTimeout:              proxy.Duration(quorumTimeout),
+	}
+
+	if configPath != "" {
+		config, err = proxy.LoadConfig(configPath)
+		if err != nil {
+			panic(err)
+		}
+
+		if config.Port == "" {
+			config.Port = port
+		}
+		config.BlockedIps = append(config.BlockedIps, blockedIps...)
+		config.AlwaysAllowedIps = append(config.AlwaysAllowedIps, alwaysAllowedIps...)
+	}
+
+	rpcProxy := proxy.NewProxy(config)
+
+	// save state that should survive restarts before exiting
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		if err := rpcProxy.Stop(); err != nil {
+			fmt.Printf("ERROR stopping proxy: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
 
 	panic(rpcProxy.Start())
 }
+
+// splitList splits a comma separated flag, trimming spaces and skipping
+// empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -4,10 +4,12 @@ go 1.13
 
 require (
 	github.com/davecgh/go-spew v1.1.1
-	github.com/google/uuid v1.1.1 // indirect
+	github.com/decred/dcrd/dcrec/secp256k1/v3 v3.0.0
+	github.com/ethereum/go-ethereum v1.10.3
+	github.com/go-redis/redis/v7 v7.4.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/slack-go/slack v0.6.5
-	github.com/stretchr/testify v1.6.1 // indirect
 	go.uber.org/atomic v1.6.0 // indirect
 	go.uber.org/ratelimit v0.1.0
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 )
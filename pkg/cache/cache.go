@@ -2,30 +2,55 @@ package cache
 
 import (
 	"time"
-
-	"github.com/patrickmn/go-cache"
-	gocache "github.com/patrickmn/go-cache"
 )
 
-// Cache ...
+// Cache holds responses and filters. Its keys come from client requests, so
+// it holds at most capacity keys, evicting the least recently used key when
+// full. Expirations of zero or less mean the key never expires.
 type Cache struct {
-	cache *gocache.Cache
+	lru *LRUStore
 }
 
-// NewCache ...
-func NewCache() *Cache {
-	c := cache.New(5*time.Minute, 10*time.Minute)
+// NewCache returns a cache that holds at most capacity keys and removes
+// expired keys every cleanup interval
+func NewCache(capacity int, cleanupInterval time.Duration) *Cache {
 	return &Cache{
-		cache: c,
+		lru: NewLRUStore(capacity, cleanupInterval),
 	}
 }
 
 // Set ...
 func (c *Cache) Set(key string, value interface{}, expires time.Duration) {
-	c.cache.Set(key, value, expires)
+	c.lru.mu.Lock()
+	defer c.lru.mu.Unlock()
+
+	c.lru.set(key, value, expirationFor(time.Now(), expires))
 }
 
 // Get ...
 func (c *Cache) Get(key string) (interface{}, time.Time, bool) {
-	return c.cache.GetWithExpiration(key)
+	c.lru.mu.Lock()
+	defer c.lru.mu.Unlock()
+
+	entry, ok := c.lru.get(key, time.Now())
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	return entry.value, entry.expiration, true
+}
+
+// Delete ...
+func (c *Cache) Delete(key string) {
+	c.lru.Delete(key)
+}
+
+// Len returns the number of keys, including expired keys not yet cleaned up
+func (c *Cache) Len() int {
+	return c.lru.Len()
+}
+
+// Evictions returns the number of keys evicted to make room for new keys
+func (c *Cache) Evictions() uint64 {
+	return c.lru.Evictions()
 }
@@ -6,7 +6,7 @@ import (
 )
 
 func TestCache(t *testing.T) {
-	c := NewCache()
+	c := NewCache(2, 0)
 	c.Set("foo", "bar", 1*time.Minute)
 	value, _, found := c.Get("foo")
 	if !found {
@@ -15,4 +15,55 @@ func TestCache(t *testing.T) {
 	if value != "bar" {
 		t.FailNow()
 	}
+
+	// the least recently used key is evicted when full
+	c.Set("baz", "qux", 0)
+	c.Get("foo")
+	c.Set("quux", "corge", 1*time.Minute)
+	if _, _, found := c.Get("baz"); found {
+		t.FailNow()
+	}
+	if _, _, found := c.Get("foo"); !found {
+		t.FailNow()
+	}
+	if c.Evictions() != 1 {
+		t.FailNow()
+	}
+
+	c.Delete("foo")
+	if _, _, found := c.Get("foo"); found {
+		t.FailNow()
+	}
+}
+
+func TestStoreNoExpiration(t *testing.T) {
+	// every store treats a zero expiration as never expiring
+	f := newFakeRedis(t)
+	defer f.listener.Close()
+
+	redisStore, err := NewRedisStore(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stores := map[string]Store{
+		"memory": NewMemoryStore(time.Minute),
+		"lru":    NewLRUStore(10, time.Minute),
+		"redis":  redisStore,
+	}
+
+	for name, s := range stores {
+		s.Set("a", 1, 0)
+		if value, expiration, found := s.Get("a"); !found || value != 1 || !expiration.IsZero() {
+			t.Fatalf("%s: got %v %v %v", name, value, expiration, found)
+		}
+
+		if n, expiration := s.Incr("a", 2, time.Minute); n != 3 || !expiration.IsZero() {
+			t.Fatalf("%s: got %v %v", name, n, expiration)
+		}
+
+		if n, expiration := s.Incr("b", 2, 0); n != 2 || !expiration.IsZero() {
+			t.Fatalf("%s: got %v %v", name, n, expiration)
+		}
+	}
 }
@@ -0,0 +1,231 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LRUStore is a Store local to the process holding at most capacity keys.
+// When full, the least recently used key is evicted, so a flood of new keys
+// can't grow memory without bound.
+type LRUStore struct {
+	mu          sync.Mutex
+	capacity    int
+	items       map[string]*list.Element
+	order       *list.List
+	evictions   uint64
+	expirations uint64
+	stop        chan struct{}
+}
+
+type lruEntry struct {
+	key        string
+	value      interface{}
+	expiration time.Time
+}
+
+// NewLRUStore returns a store that holds at most capacity keys and removes
+// expired keys every cleanup interval.
+func NewLRUStore(capacity int, cleanupInterval time.Duration) *LRUStore {
+	if capacity <= 0 {
+		panic("LRU store capacity must be positive")
+	}
+
+	s := &LRUStore{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+		stop:     make(chan struct{}),
+	}
+
+	if cleanupInterval > 0 {
+		go s.janitor(cleanupInterval)
+	}
+
+	return s
+}
+
+// Get ...
+func (s *LRUStore) Get(key string) (int64, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.get(key, time.Now())
+	if !ok {
+		return 0, time.Time{}, false
+	}
+
+	value, ok := entry.value.(int64)
+	return value, entry.expiration, ok
+}
+
+// Set ...
+func (s *LRUStore) Set(key string, value int64, expires time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.set(key, value, expirationFor(time.Now(), expires))
+}
+
+// Incr ...
+func (s *LRUStore) Incr(key string, delta int64, expires time.Duration) (int64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := s.get(key, now); ok {
+		value, _ := entry.value.(int64)
+		value += delta
+		entry.value = value
+		return value, entry.expiration
+	}
+
+	expiration := expirationFor(now, expires)
+	s.set(key, delta, expiration)
+	return delta, expiration
+}
+
+// Update ...
+func (s *LRUStore) Update(keys []string, fn UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		if entry, ok := s.get(key, now); ok {
+			values[i], _ = entry.value.(int64)
+		}
+	}
+
+	values, expires, ok := fn(values)
+	if !ok {
+		return nil
+	}
+
+	for i, key := range keys {
+		s.set(key, values[i], expirationFor(now, expires[i]))
+	}
+
+	return nil
+}
+
+// Delete ...
+func (s *LRUStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.items[key]; ok {
+		s.remove(element)
+	}
+}
+
+// Len returns the number of keys, including expired keys not yet cleaned up
+func (s *LRUStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.order.Len()
+}
+
+// Evictions returns the number of keys evicted to make room for new keys
+func (s *LRUStore) Evictions() uint64 {
+	return atomic.LoadUint64(&s.evictions)
+}
+
+// Expirations returns the number of expired keys removed
+func (s *LRUStore) Expirations() uint64 {
+	return atomic.LoadUint64(&s.expirations)
+}
+
+// Close stops the cleanup of expired keys
+func (s *LRUStore) Close() {
+	close(s.stop)
+}
+
+func (s *LRUStore) get(key string, now time.Time) (*lruEntry, bool) {
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*lruEntry)
+	if expired(entry, now) {
+		s.remove(element)
+		atomic.AddUint64(&s.expirations, 1)
+		return nil, false
+	}
+
+	s.order.MoveToFront(element)
+	return entry, true
+}
+
+func (s *LRUStore) set(key string, value interface{}, expiration time.Time) {
+	if element, ok := s.items[key]; ok {
+		entry := element.Value.(*lruEntry)
+		entry.value = value
+		entry.expiration = expiration
+		s.order.MoveToFront(element)
+		return
+	}
+
+	s.items[key] = s.order.PushFront(&lruEntry{
+		key:        key,
+		value:      value,
+		expiration: expiration,
+	})
+
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+		atomic.AddUint64(&s.evictions, 1)
+	}
+}
+
+func (s *LRUStore) remove(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.items, element.Value.(*lruEntry).key)
+}
+
+func (s *LRUStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.deleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *LRUStore) deleteExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for element := s.order.Back(); element != nil; {
+		prev := element.Prev()
+		if expired(element.Value.(*lruEntry), now) {
+			s.remove(element)
+			atomic.AddUint64(&s.expirations, 1)
+		}
+		element = prev
+	}
+}
+
+func expired(entry *lruEntry, now time.Time) bool {
+	return !entry.expiration.IsZero() && now.After(entry.expiration)
+}
+
+// expirationFor returns the zero time, meaning never, for non positive durations
+func expirationFor(now time.Time, expires time.Duration) time.Time {
+	if expires <= 0 {
+		return time.Time{}
+	}
+
+	return now.Add(expires)
+}
@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUStore(t *testing.T) {
+	s := NewLRUStore(2, 0)
+
+	s.Set("a", 1, time.Minute)
+	s.Set("b", 2, time.Minute)
+	s.Get("a")
+	s.Incr("c", 3, time.Minute)
+
+	if _, _, found := s.Get("b"); found {
+		t.Fatal("expected least recently used key to be evicted")
+	}
+	if value, _, found := s.Get("a"); !found || value != 1 {
+		t.FailNow()
+	}
+	if s.Len() != 2 || s.Evictions() != 1 {
+		t.FailNow()
+	}
+
+	s.Set("d", 4, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	s.deleteExpired()
+	if _, _, found := s.Get("d"); found || s.Expirations() != 1 {
+		t.FailNow()
+	}
+}
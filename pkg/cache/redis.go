@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// maxUpdateAttempts is how many times an update is retried when another
+// client changes the keys in between
+const maxUpdateAttempts = 16
+
+// redisMinBackoff and redisMaxBackoff bound how long Redis isn't contacted
+// after a connection failure, doubling with every failure in a row
+const (
+	redisMinBackoff = 500 * time.Millisecond
+	redisMaxBackoff = 30 * time.Second
+)
+
+// errUnavailable is returned without contacting Redis while backing off
+var errUnavailable = errors.New("redis: unavailable")
+
+// errConflict is returned when the watched keys kept changing
+var errConflict = errors.New("redis: keys changed during update")
+
+// RedisStore is a Store backed by Redis so that rate limit counters and bans
+// are shared between proxy replicas. Errors are logged and treated as a miss,
+// so an unavailable Redis fails open instead of rejecting every request.
+// After a connection failure Redis isn't contacted for a backoff period, so
+// requests don't each wait for the timeout while it's down.
+type RedisStore struct {
+	client *redis.Client
+
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+}
+
+// NewRedisStore connects to the redis URL, e.g. redis://:password@localhost:6379/0
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	options, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	options.DialTimeout = 5 * time.Second
+	options.ReadTimeout = 5 * time.Second
+	options.WriteTimeout = 5 * time.Second
+
+	s := &RedisStore{
+		client: redis.NewClient(options),
+	}
+
+	if err := s.client.Ping().Err(); err != nil {
+		s.client.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Get ...
+func (s *RedisStore) Get(key string) (int64, time.Time, bool) {
+	var get *redis.StringCmd
+	var pttl *redis.DurationCmd
+	err := s.do(func() error {
+		_, err := s.client.Pipelined(func(pipe redis.Pipeliner) error {
+			get = pipe.Get(key)
+			pttl = pipe.PTTL(key)
+			return nil
+		})
+		return err
+	})
+	if err == redis.Nil {
+		return 0, time.Time{}, false
+	}
+	if err != nil {
+		logError(err)
+		return 0, time.Time{}, false
+	}
+
+	n, err := get.Int64()
+	if err != nil {
+		fmt.Printf("REDIS ERROR %v\n", err)
+		return 0, time.Time{}, false
+	}
+
+	return n, expiration(pttl.Val()), true
+}
+
+// Set ...
+func (s *RedisStore) Set(key string, value int64, expires time.Duration) {
+	err := s.do(func() error {
+		return s.client.Set(key, value, redisExpiration(expires)).Err()
+	})
+	if err != nil {
+		logError(err)
+	}
+}
+
+// Incr ...
+func (s *RedisStore) Incr(key string, delta int64, expires time.Duration) (int64, time.Time) {
+	var incr *redis.IntCmd
+	var pttl *redis.DurationCmd
+	// creating the key with its expiration and incrementing it happen in one transaction
+	err := s.do(func() error {
+		_, err := s.client.TxPipelined(func(pipe redis.Pipeliner) error {
+			pipe.SetNX(key, 0, redisExpiration(expires))
+			incr = pipe.IncrBy(key, delta)
+			pttl = pipe.PTTL(key)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		logError(err)
+		return delta, expirationFor(time.Now(), expires)
+	}
+
+	return incr.Val(), expiration(pttl.Val())
+}
+
+// Delete ...
+func (s *RedisStore) Delete(key string) {
+	err := s.do(func() error {
+		return s.client.Del(key).Err()
+	})
+	if err != nil {
+		logError(err)
+	}
+}
+
+// Update watches the keys so the new values are only stored if no other
+// client changed them since they were read, retrying otherwise
+func (s *RedisStore) Update(keys []string, fn UpdateFunc) error {
+	for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+		err := s.do(func() error {
+			return s.client.Watch(func(tx *redis.Tx) error {
+				return update(tx, keys, fn)
+			}, keys...)
+		})
+		if err != redis.TxFailedErr {
+			if err != nil {
+				logError(err)
+			}
+			return err
+		}
+	}
+
+	logError(errConflict)
+	return errConflict
+}
+
+func update(tx *redis.Tx, keys []string, fn UpdateFunc) error {
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		value, err := tx.Get(key).Int64()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		values[i] = value
+	}
+
+	values, expires, ok := fn(values)
+	if !ok {
+		return nil
+	}
+
+	_, err := tx.TxPipelined(func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			pipe.Set(key, values[i], redisExpiration(expires[i]))
+		}
+		return nil
+	})
+
+	return err
+}
+
+// do calls fn unless backing off, and backs off after connection errors.
+// Error replies such as redis.Nil leave Redis usable.
+func (s *RedisStore) do(fn func() error) error {
+	if err := s.available(); err != nil {
+		return err
+	}
+
+	err := fn()
+	var redisErr redis.Error
+	if err != nil && !errors.As(err, &redisErr) {
+		s.failed(err)
+		return err
+	}
+
+	s.succeeded()
+	return err
+}
+
+// available returns errUnavailable while backing off after a failure
+func (s *RedisStore) available() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().Before(s.retryAt) {
+		return errUnavailable
+	}
+
+	return nil
+}
+
+func (s *RedisStore) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backoff := redisMaxBackoff
+	if s.failures < 16 {
+		if shifted := redisMinBackoff << uint(s.failures); shifted < backoff {
+			backoff = shifted
+		}
+	}
+	s.failures++
+	s.retryAt = time.Now().Add(backoff)
+
+	fmt.Printf("REDIS ERROR %v, retrying in %s\n", err, backoff)
+}
+
+func (s *RedisStore) succeeded() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = 0
+}
+
+// logError logs errors other than those already logged when backing off
+func logError(err error) {
+	if err == errUnavailable {
+		return
+	}
+
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) || err == errConflict {
+		fmt.Printf("REDIS ERROR %v\n", err)
+	}
+}
+
+// expiration converts a PTTL reply, negative if the key has no expiration
+// or doesn't exist, to an expiration time
+func expiration(ttl time.Duration) time.Time {
+	if ttl < 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(ttl)
+}
+
+// redisExpiration maps non positive durations to go-redis's never, and
+// rounds shorter ones up so they don't mean never too
+func redisExpiration(expires time.Duration) time.Duration {
+	if expires <= 0 {
+		return 0
+	}
+	if expires < time.Millisecond {
+		return time.Millisecond
+	}
+
+	return expires
+}
@@ -0,0 +1,369 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a stand-in Redis server supporting the commands RedisStore uses
+type fakeRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+	values   map[string]string
+	expires  map[string]time.Time
+	// versions change on every write to a key, so EXEC can tell if a
+	// watched key changed
+	versions map[string]int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{
+		listener: listener,
+		values:   make(map[string]string),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeRedis) url() string {
+	return fmt.Sprintf("redis://%s", f.listener.Addr())
+}
+
+// close stops accepting connections and drops the open ones
+func (f *fakeRedis) close() {
+	f.listener.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	var queued [][]string
+	inMulti := false
+	watched := make(map[string]int)
+	for {
+		cmd, err := readCommand(r)
+		if err != nil || len(cmd) == 0 {
+			return
+		}
+
+		switch strings.ToUpper(cmd[0]) {
+		case "MULTI":
+			inMulti = true
+			conn.Write([]byte("+OK\r\n"))
+		case "WATCH":
+			f.mu.Lock()
+			for _, key := range cmd[1:] {
+				watched[key] = f.versions[key]
+			}
+			f.mu.Unlock()
+			conn.Write([]byte("+OK\r\n"))
+		case "UNWATCH":
+			watched = make(map[string]int)
+			conn.Write([]byte("+OK\r\n"))
+		case "EXEC":
+			f.mu.Lock()
+			changed := false
+			for key, version := range watched {
+				if f.versions[key] != version {
+					changed = true
+				}
+			}
+			if changed {
+				conn.Write([]byte("*-1\r\n"))
+			} else {
+				fmt.Fprintf(conn, "*%d\r\n", len(queued))
+				for _, queuedCmd := range queued {
+					conn.Write([]byte(f.run(queuedCmd)))
+				}
+			}
+			f.mu.Unlock()
+			inMulti = false
+			queued = nil
+			watched = make(map[string]int)
+		default:
+			if inMulti {
+				queued = append(queued, cmd)
+				conn.Write([]byte("+QUEUED\r\n"))
+				continue
+			}
+			conn.Write([]byte(f.exec(cmd)))
+		}
+	}
+}
+
+func (f *fakeRedis) exec(cmd []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.run(cmd)
+}
+
+func (f *fakeRedis) run(cmd []string) string {
+	key := ""
+	if len(cmd) > 1 {
+		key = cmd[1]
+		if expiration, ok := f.expires[key]; ok && time.Now().After(expiration) {
+			delete(f.values, key)
+			delete(f.expires, key)
+		}
+	}
+
+	switch strings.ToUpper(cmd[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		value, ok := f.values[key]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "SET", "SETNX":
+		nx := strings.ToUpper(cmd[0]) == "SETNX"
+		var expiration time.Time
+		for i := 3; i < len(cmd); i++ {
+			switch strings.ToUpper(cmd[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				ms, _ := strconv.Atoi(cmd[i+1])
+				expiration = time.Now().Add(time.Duration(ms) * time.Millisecond)
+				i++
+			case "EX":
+				seconds, _ := strconv.Atoi(cmd[i+1])
+				expiration = time.Now().Add(time.Duration(seconds) * time.Second)
+				i++
+			}
+		}
+		if _, ok := f.values[key]; ok && nx {
+			return "$-1\r\n"
+		}
+		f.values[key] = cmd[2]
+		f.versions[key]++
+		delete(f.expires, key)
+		if !expiration.IsZero() {
+			f.expires[key] = expiration
+		}
+		return "+OK\r\n"
+	case "INCRBY":
+		n, _ := strconv.ParseInt(f.values[key], 10, 64)
+		delta, _ := strconv.ParseInt(cmd[2], 10, 64)
+		f.values[key] = strconv.FormatInt(n+delta, 10)
+		f.versions[key]++
+		return fmt.Sprintf(":%d\r\n", n+delta)
+	case "PTTL":
+		if _, ok := f.values[key]; !ok {
+			return ":-2\r\n"
+		}
+		expiration, ok := f.expires[key]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", time.Until(expiration).Milliseconds())
+	case "DEL":
+		_, ok := f.values[key]
+		f.versions[key]++
+		delete(f.values, key)
+		delete(f.expires, key)
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd[0])
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected command %q", line)
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := make([]string, n)
+	for i := range cmd {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("unexpected argument %q", line)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		cmd[i] = string(buf[:size])
+	}
+
+	return cmd, nil
+}
+
+func TestRedisStore(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.close()
+
+	s, err := NewRedisStore(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, expiration := s.Incr("ratelimit:1.2.3.4", 2, time.Minute)
+	if n != 2 || time.Until(expiration) <= 59*time.Second {
+		t.Fatalf("got %v %v", n, expiration)
+	}
+
+	n, _ = s.Incr("ratelimit:1.2.3.4", 3, time.Hour)
+	if n != 5 {
+		t.Fatalf("got %v", n)
+	}
+
+	value, expiration, found := s.Get("ratelimit:1.2.3.4")
+	if !found || value != 5 || time.Until(expiration) > time.Minute {
+		t.Fatalf("got %v %v %v", value, expiration, found)
+	}
+
+	s.Set("ban:1.2.3.4", 1, time.Minute)
+	if _, _, found := s.Get("ban:1.2.3.4"); !found {
+		t.FailNow()
+	}
+
+	s.Delete("ban:1.2.3.4")
+	if _, _, found := s.Get("ban:1.2.3.4"); found {
+		t.FailNow()
+	}
+}
+
+func TestRedisStoreUpdate(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.close()
+
+	s, err := NewRedisStore(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := NewRedisStore(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another replica changing a key between the read and the write makes
+	// the update start over from the new value
+	calls := 0
+	err = s.Update([]string{"a", "b"}, func(values []int64) ([]int64, []time.Duration, bool) {
+		calls++
+		if calls == 1 {
+			other.Set("a", 10, time.Minute)
+		}
+		return []int64{values[0] + 1, values[1] + 2}, []time.Duration{time.Minute, 0}, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("got %v calls", calls)
+	}
+
+	if value, expiration, _ := s.Get("a"); value != 11 || time.Until(expiration) <= 59*time.Second {
+		t.Fatalf("got %v %v", value, expiration)
+	}
+	if value, expiration, _ := s.Get("b"); value != 2 || !expiration.IsZero() {
+		t.Fatalf("got %v %v", value, expiration)
+	}
+
+	// nothing is stored unless fn returns true
+	err = s.Update([]string{"a"}, func(values []int64) ([]int64, []time.Duration, bool) {
+		return []int64{0}, []time.Duration{0}, false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _, _ := s.Get("a"); value != 11 {
+		t.Fatalf("got %v", value)
+	}
+}
+
+func TestRedisStoreBackoff(t *testing.T) {
+	f := newFakeRedis(t)
+
+	s, err := NewRedisStore(f.url())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop the pooled connections and stop accepting new ones
+	f.close()
+
+	s.Set("a", 1, time.Minute)
+	if s.available() != errUnavailable {
+		t.Fatal("expected backoff after a failed dial")
+	}
+
+	if _, _, found := s.Get("a"); found {
+		t.FailNow()
+	}
+	if err := s.Update([]string{"a"}, nil); err != errUnavailable {
+		t.Fatalf("got %v", err)
+	}
+
+	// the backoff doubles with failures in a row and resets on success
+	s.failed(errUnavailable)
+	if until := time.Until(s.retryAt); until <= redisMinBackoff {
+		t.Fatalf("got %s", until)
+	}
+
+	s.succeeded()
+	if s.failures != 0 {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// Item is a key with its value and expiration, the zero time meaning never
+type Item struct {
+	Key        string    `json:"key"`
+	Value      int64     `json:"value"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// Snapshotter is implemented by stores whose contents can be saved and restored
+type Snapshotter interface {
+	Items() []Item
+	Restore(items []Item)
+}
+
+type snapshot struct {
+	CreatedAt time.Time `json:"createdAt"`
+	Items     []Item    `json:"items"`
+}
+
+// SaveSnapshot writes the unexpired items of the store to the file
+func SaveSnapshot(path string, s Snapshotter) (int, error) {
+	items := s.Items()
+	buf, err := json.Marshal(&snapshot{
+		CreatedAt: time.Now(),
+		Items:     items,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// write to a temporary file first so a crash never leaves a partial snapshot
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+
+	return len(items), nil
+}
+
+// LoadSnapshot restores the unexpired items in the file to the store. A
+// missing file is not an error.
+func LoadSnapshot(path string, s Snapshotter) (int, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(buf, &snap); err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	items := make([]Item, 0, len(snap.Items))
+	for _, item := range snap.Items {
+		if !item.Expiration.IsZero() && now.After(item.Expiration) {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	s.Restore(items)
+	return len(items), nil
+}
+
+// Items ...
+func (s *MemoryStore) Items() []Item {
+	cached := s.cache.Items()
+	items := make([]Item, 0, len(cached))
+	for key, item := range cached {
+		value, ok := item.Object.(int64)
+		if !ok {
+			continue
+		}
+
+		var expiration time.Time
+		if item.Expiration > 0 {
+			expiration = time.Unix(0, item.Expiration)
+		}
+
+		items = append(items, Item{
+			Key:        key,
+			Value:      value,
+			Expiration: expiration,
+		})
+	}
+
+	return items
+}
+
+// Restore ...
+func (s *MemoryStore) Restore(items []Item) {
+	now := time.Now()
+	for _, item := range items {
+		expires := gocache.NoExpiration
+		if !item.Expiration.IsZero() {
+			expires = item.Expiration.Sub(now)
+			if expires <= 0 {
+				continue
+			}
+		}
+
+		s.cache.Set(item.Key, item.Value, expires)
+	}
+}
+
+// Items returns the items from least to most recently used
+func (s *LRUStore) Items() []Item {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	items := make([]Item, 0, s.order.Len())
+	for element := s.order.Back(); element != nil; element = element.Prev() {
+		entry := element.Value.(*lruEntry)
+		value, ok := entry.value.(int64)
+		if !ok || expired(entry, now) {
+			continue
+		}
+
+		items = append(items, Item{
+			Key:        entry.key,
+			Value:      value,
+			Expiration: entry.expiration,
+		})
+	}
+
+	return items
+}
+
+// Restore adds the items in order, so restoring the result of Items keeps
+// the recency order.
+func (s *LRUStore) Restore(items []Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, item := range items {
+		if !item.Expiration.IsZero() && now.After(item.Expiration) {
+			continue
+		}
+
+		s.set(item.Key, item.Value, item.Expiration)
+	}
+}
@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "store.json")
+	if n, err := LoadSnapshot(path, NewMemoryStore(time.Minute)); err != nil || n != 0 {
+		t.Fatalf("expected missing snapshot to be ignored, got %v %v", n, err)
+	}
+
+	s := NewMemoryStore(time.Minute)
+	s.Set("ban:1.2.3.4", 1, time.Hour)
+	s.Incr("ratelimit:1.2.3.4:min:1", 5, time.Minute)
+	s.Set("seen:1.2.3.4", 1, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if n, err := SaveSnapshot(path, s); err != nil || n != 2 {
+		t.Fatalf("got %v %v", n, err)
+	}
+
+	restored := NewLRUStore(10, 0)
+	if n, err := LoadSnapshot(path, restored); err != nil || n != 2 {
+		t.Fatalf("got %v %v", n, err)
+	}
+
+	value, expiration, found := restored.Get("ban:1.2.3.4")
+	if !found || value != 1 || time.Until(expiration) < 59*time.Minute {
+		t.Fatalf("got %v %v %v", value, expiration, found)
+	}
+
+	if value, _, found := restored.Get("ratelimit:1.2.3.4:min:1"); !found || value != 5 {
+		t.FailNow()
+	}
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"sync"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// Store is a key/value store of expiring int64 values such as rate limit
+// counters and bans. Implementations may be shared by multiple proxies.
+// Expirations of zero or less mean the key never expires, and a returned
+// zero expiration time means the same.
+type Store interface {
+	Get(key string) (int64, time.Time, bool)
+	Set(key string, value int64, expires time.Duration)
+	// Incr atomically adds delta to the value at key, creating it with the
+	// given expiration if it doesn't exist. It returns the new value and expiration.
+	Incr(key string, delta int64, expires time.Duration) (int64, time.Time)
+	// Update atomically reads the keys, missing keys being 0, and stores the
+	// values fn returns with their expirations if it returns true. fn may
+	// be called again if another client changed the keys in between.
+	Update(keys []string, fn UpdateFunc) error
+	Delete(key string)
+}
+
+// UpdateFunc returns the new values of keys and their expirations given the
+// current values, and whether to store them
+type UpdateFunc func(values []int64) ([]int64, []time.Duration, bool)
+
+// MemoryStore is an unbounded Store local to the process
+type MemoryStore struct {
+	cache *gocache.Cache
+	mu    sync.Mutex
+}
+
+// NewMemoryStore returns a store that removes expired keys every cleanup interval
+func NewMemoryStore(cleanupInterval time.Duration) *MemoryStore {
+	return &MemoryStore{
+		cache: gocache.New(5*time.Minute, cleanupInterval),
+	}
+}
+
+// Get ...
+func (s *MemoryStore) Get(key string) (int64, time.Time, bool) {
+	value, expiration, found := s.cache.GetWithExpiration(key)
+	if !found {
+		return 0, time.Time{}, false
+	}
+
+	n, ok := value.(int64)
+	return n, expiration, ok
+}
+
+// Set ...
+func (s *MemoryStore) Set(key string, value int64, expires time.Duration) {
+	s.cache.Set(key, value, noExpiration(expires))
+}
+
+// Incr ...
+func (s *MemoryStore) Incr(key string, delta int64, expires time.Duration) (int64, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	value, expiration, found := s.cache.GetWithExpiration(key)
+	if counter, ok := value.(int64); found && ok {
+		counter += delta
+		if expiration.IsZero() {
+			s.cache.Set(key, counter, gocache.NoExpiration)
+			return counter, expiration
+		}
+		if remaining := expiration.Sub(now); remaining > 0 {
+			s.cache.Set(key, counter, remaining)
+			return counter, expiration
+		}
+	}
+
+	s.cache.Set(key, delta, noExpiration(expires))
+	return delta, expirationFor(now, expires)
+}
+
+// Update ...
+func (s *MemoryStore) Update(keys []string, fn UpdateFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make([]int64, len(keys))
+	for i, key := range keys {
+		if value, found := s.cache.Get(key); found {
+			values[i], _ = value.(int64)
+		}
+	}
+
+	values, expires, ok := fn(values)
+	if !ok {
+		return nil
+	}
+
+	for i, key := range keys {
+		s.cache.Set(key, values[i], noExpiration(expires[i]))
+	}
+
+	return nil
+}
+
+// Delete ...
+func (s *MemoryStore) Delete(key string) {
+	s.cache.Delete(key)
+}
+
+// Len returns the number of keys, including expired keys not yet cleaned up
+func (s *MemoryStore) Len() int {
+	return s.cache.ItemCount()
+}
+
+// noExpiration maps non positive durations to go-cache's never, rather than
+// its default expiration
+func noExpiration(expires time.Duration) time.Duration {
+	if expires <= 0 {
+		return gocache.NoExpiration
+	}
+
+	return expires
+}
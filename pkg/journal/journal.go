@@ -0,0 +1,263 @@
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is where a transaction is in its life
+type Status string
+
+const (
+	// Pending transactions were accepted by an upstream but aren't mined yet
+	Pending Status = "pending"
+	// Rejected transactions were refused by the upstreams
+	Rejected Status = "rejected"
+	// Mined transactions have a receipt
+	Mined Status = "mined"
+	// Dropped transactions left the pool without being mined
+	Dropped Status = "dropped"
+	// Replaced transactions lost their nonce to another transaction
+	Replaced Status = "replaced"
+)
+
+// Entry is a transaction sent through the proxy. Hashes and addresses are
+// lowercase hex with the 0x prefix.
+type Entry struct {
+	Hash        string    `json:"hash"`
+	Chain       string    `json:"chain"`
+	From        string    `json:"from"`
+	Nonce       uint64    `json:"nonce"`
+	IP          string    `json:"ip"`
+	APIKey      string    `json:"apiKey,omitempty"`
+	Upstream    string    `json:"upstream,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Status      Status    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	BlockNumber uint64    `json:"blockNumber,omitempty"`
+	Reverted    bool      `json:"reverted,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Journal is an embedded store of transactions. Every write appends the
+// entry to a file of JSON lines, with later lines replacing earlier ones,
+// and the file is compacted when opened.
+type Journal struct {
+	mu       sync.RWMutex
+	path     string
+	file     *os.File
+	entries  map[string]*Entry
+	bySender map[string]map[string]bool
+}
+
+// Open loads the journal at the path, creating it if it doesn't exist
+func Open(path string) (*Journal, error) {
+	j := &Journal{
+		path:     path,
+		entries:  make(map[string]*Entry),
+		bySender: make(map[string]map[string]bool),
+	}
+
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		// a crash can leave the last line partially written
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Hash == "" {
+			continue
+		}
+		j.index(&entry)
+	}
+
+	return scanner.Err()
+}
+
+// compact rewrites the file with only the current entries and opens it for
+// appending
+func (j *Journal) compact() error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, entry := range j.entries {
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(buf)
+		w.WriteByte('\n')
+	}
+
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0644)
+	return err
+}
+
+func (j *Journal) index(entry *Entry) {
+	if previous, ok := j.entries[entry.Hash]; ok && previous.From != entry.From {
+		delete(j.bySender[previous.From], entry.Hash)
+	}
+
+	j.entries[entry.Hash] = entry
+	if j.bySender[entry.From] == nil {
+		j.bySender[entry.From] = make(map[string]bool)
+	}
+	j.bySender[entry.From][entry.Hash] = true
+}
+
+// Put adds or replaces the entry with the same hash
+func (j *Journal) Put(entry *Entry) error {
+	stored := *entry
+	stored.Hash = strings.ToLower(stored.Hash)
+	stored.From = strings.ToLower(stored.From)
+
+	buf, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.file.Write(append(buf, '\n')); err != nil {
+		return err
+	}
+
+	j.index(&stored)
+	return nil
+}
+
+// Get returns the entry with the hash
+func (j *Journal) Get(hash string) (*Entry, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	entry, ok := j.entries[strings.ToLower(hash)]
+	if !ok {
+		return nil, false
+	}
+
+	copied := *entry
+	return &copied, true
+}
+
+// BySender returns the entries sent by the address, oldest first
+func (j *Journal) BySender(from string) []*Entry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var entries []*Entry
+	for hash := range j.bySender[strings.ToLower(from)] {
+		copied := *j.entries[hash]
+		entries = append(entries, &copied)
+	}
+
+	sortEntries(entries)
+	return entries
+}
+
+// Pending returns the entries waiting to be mined, oldest first
+func (j *Journal) Pending() []*Entry {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var entries []*Entry
+	for _, entry := range j.entries {
+		if entry.Status == Pending {
+			copied := *entry
+			entries = append(entries, &copied)
+		}
+	}
+
+	sortEntries(entries)
+	return entries
+}
+
+// Prune removes the entries sent before the time and compacts the file if
+// any were removed. It returns the number of entries removed.
+func (j *Journal) Prune(before time.Time) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	removed := 0
+	for hash, entry := range j.entries {
+		if entry.Timestamp.Before(before) {
+			delete(j.entries, hash)
+			delete(j.bySender[entry.From], hash)
+			if len(j.bySender[entry.From]) == 0 {
+				delete(j.bySender, entry.From)
+			}
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+
+	return removed, j.compact()
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.file.Close()
+}
+
+func sortEntries(entries []*Entry) {
+	sort.Slice(entries, func(a, b int) bool {
+		if !entries[a].Timestamp.Equal(entries[b].Timestamp) {
+			return entries[a].Timestamp.Before(entries[b].Timestamp)
+		}
+		return entries[a].Nonce < entries[b].Nonce
+	})
+}
@@ -0,0 +1,85 @@
+package journal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "transactions.jsonl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	entries := []*Entry{
+		{Hash: "0xAA", From: "0xF1", Nonce: 1, Timestamp: now.Add(-2 * time.Hour), Status: Pending},
+		{Hash: "0xbb", From: "0xf1", Nonce: 2, Timestamp: now.Add(-time.Minute), Status: Pending},
+		{Hash: "0xcc", From: "0xf2", Nonce: 0, Timestamp: now, Status: Rejected},
+	}
+	for _, entry := range entries {
+		if err := j.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mined := *entries[1]
+	mined.Status = Mined
+	mined.BlockNumber = 100
+	if err := j.Put(&mined); err != nil {
+		t.Fatal(err)
+	}
+
+	// write a partial line like a crash would
+	j.file.Write([]byte(`{"hash":"0xdd","fro`))
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	entry, ok := j.Get("0xbb")
+	if !ok || entry.Status != Mined || entry.BlockNumber != 100 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	sent := j.BySender("0xF1")
+	if len(sent) != 2 || sent[0].Hash != "0xaa" || sent[1].Hash != "0xbb" {
+		t.Fatalf("unexpected entries by sender %+v", sent)
+	}
+
+	if pending := j.Pending(); len(pending) != 1 || pending[0].Hash != "0xaa" {
+		t.Fatalf("unexpected pending entries %+v", pending)
+	}
+
+	if n, err := j.Prune(now.Add(-time.Hour)); err != nil || n != 1 {
+		t.Fatalf("expected 1 pruned entry, got %v %v", n, err)
+	}
+	if _, ok := j.Get("0xaa"); ok {
+		t.Fatal("expected the old entry to be pruned")
+	}
+
+	// the compacted file has one line per entry
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(buf, []byte("\n")); lines != 2 {
+		t.Fatalf("expected 2 lines after compaction, got %v", lines)
+	}
+}
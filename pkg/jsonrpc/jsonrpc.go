@@ -0,0 +1,156 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// Version ...
+const Version = "2.0"
+
+// Error codes, see https://eips.ethereum.org/EIPS/eip-1474#error-codes
+const (
+	CodeParseError          = -32700
+	CodeInvalidRequest      = -32600
+	CodeMethodNotFound      = -32601
+	CodeInvalidParams       = -32602
+	CodeInternalError       = -32603
+	CodeResourceNotFound    = -32001
+	CodeTransactionRejected = -32003
+	CodeQuorumNotReached    = -32004
+	CodeLimitExceeded       = -32005
+)
+
+// Request ...
+type Request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// Response ...
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error ...
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error ...
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrEmptyBody is returned when there is no request to parse
+var ErrEmptyBody = errors.New("empty body")
+
+// ParseRequests parses a single request or a batch of requests. The returned
+// bool reports whether the body was a batch.
+func ParseRequests(body []byte) ([]*Request, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, false, ErrEmptyBody
+	}
+
+	if body[0] == '[' {
+		var reqs []*Request
+		if err := json.Unmarshal(body, &reqs); err != nil {
+			return nil, true, err
+		}
+		for _, req := range reqs {
+			if req == nil {
+				return nil, true, errors.New("invalid batch element")
+			}
+		}
+		return reqs, true, nil
+	}
+
+	req := new(Request)
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, false, err
+	}
+
+	return []*Request{req}, false, nil
+}
+
+// IsNotification returns true if the request has no id and expects no response
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// ParseResponses parses a single response or a batch of responses
+func ParseResponses(body []byte) ([]*Response, bool, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, false, ErrEmptyBody
+	}
+
+	if body[0] == '[' {
+		var resps []*Response
+		if err := json.Unmarshal(body, &resps); err != nil {
+			return nil, true, err
+		}
+		for _, resp := range resps {
+			if resp == nil {
+				return nil, true, errors.New("invalid batch element")
+			}
+		}
+		return resps, true, nil
+	}
+
+	resp := new(Response)
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, false, err
+	}
+
+	return []*Response{resp}, false, nil
+}
+
+// IDKey returns the request id in a form usable as a map key
+func IDKey(id json.RawMessage) string {
+	buf := new(bytes.Buffer)
+	if err := json.Compact(buf, id); err != nil {
+		return string(id)
+	}
+
+	return buf.String()
+}
+
+// NewResultResponse ...
+func NewResultResponse(id json.RawMessage, result json.RawMessage) *Response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return &Response{
+		JSONRPC: Version,
+		ID:      id,
+		Result:  result,
+	}
+}
+
+// NewErrorResponse ...
+func NewErrorResponse(id json.RawMessage, code int, message string, data interface{}) *Response {
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	return &Response{
+		JSONRPC: Version,
+		ID:      id,
+		Error: &Error{
+			Code:    code,
+			Message: message,
+			Data:    data,
+		},
+	}
+}
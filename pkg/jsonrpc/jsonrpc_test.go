@@ -0,0 +1,30 @@
+package jsonrpc
+
+import (
+	"testing"
+)
+
+func TestParseRequests(t *testing.T) {
+	reqs, isBatch, err := ParseRequests([]byte(`{"jsonrpc":"2.0","method":"eth_chainId","id":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isBatch || len(reqs) != 1 || reqs[0].Method != "eth_chainId" {
+		t.FailNow()
+	}
+
+	reqs, isBatch, err = ParseRequests([]byte(` [{"jsonrpc":"2.0","method":"eth_chainId","id":1},{"jsonrpc":"2.0","method":"eth_blockNumber"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isBatch || len(reqs) != 2 {
+		t.FailNow()
+	}
+	if reqs[0].IsNotification() || !reqs[1].IsNotification() {
+		t.FailNow()
+	}
+
+	if _, _, err := ParseRequests([]byte(`[1]`)); err == nil {
+		t.FailNow()
+	}
+}
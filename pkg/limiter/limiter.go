@@ -0,0 +1,92 @@
+package limiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+)
+
+// Algorithms
+const (
+	SlidingWindow = "sliding-window"
+	TokenBucket   = "token-bucket"
+)
+
+// Rule limits usage to Limit within Window. Burst is the token bucket
+// capacity and defaults to Limit.
+type Rule struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+	Burst  int
+}
+
+// Usage is the state of a rule after a call to Allow
+type Usage struct {
+	Rule      Rule
+	Used      int
+	Remaining int
+	Reset     time.Duration
+}
+
+// Result ...
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long to wait before the same request would be allowed
+	RetryAfter time.Duration
+	// Usages has the state of every rule, in the order the rules were given
+	Usages []Usage
+}
+
+// Usage returns the usage of the named rule
+func (r *Result) Usage(name string) (Usage, bool) {
+	for _, usage := range r.Usages {
+		if usage.Rule.Name == name {
+			return usage, true
+		}
+	}
+
+	return Usage{}, false
+}
+
+// Tightest returns the usage of the rule with the least remaining
+func (r *Result) Tightest() (Usage, bool) {
+	if len(r.Usages) == 0 {
+		return Usage{}, false
+	}
+
+	tightest := r.Usages[0]
+	for _, usage := range r.Usages[1:] {
+		if usage.Remaining < tightest.Remaining {
+			tightest = usage
+		}
+	}
+
+	return tightest, true
+}
+
+// Limiter ...
+type Limiter interface {
+	// Allow charges cost against every rule for key. Nothing is charged
+	// if any rule would be exceeded.
+	Allow(key string, cost int) *Result
+}
+
+// New returns a limiter for the algorithm that keeps its state in store
+func New(algorithm string, store cache.Store, rules []Rule) (Limiter, error) {
+	for _, rule := range rules {
+		if rule.Limit <= 0 || rule.Window <= 0 {
+			return nil, fmt.Errorf("invalid rate limit rule %q", rule.Name)
+		}
+	}
+
+	switch algorithm {
+	case "", SlidingWindow:
+		return NewSlidingWindowLimiter(store, rules), nil
+	case TokenBucket:
+		return NewTokenBucketLimiter(store, rules), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+}
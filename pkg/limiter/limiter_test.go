@@ -0,0 +1,89 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+)
+
+func TestSlidingWindowLimiter(t *testing.T) {
+	now := time.Unix(1600000020, 0)
+	l := NewSlidingWindowLimiter(cache.NewMemoryStore(10*time.Minute), []Rule{
+		{Name: "minute", Limit: 10, Window: time.Minute},
+	})
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("1.2.3.4", 10).Allowed {
+		t.Fatal("expected allowed")
+	}
+
+	result := l.Allow("1.2.3.4", 1)
+	if result.Allowed || result.RetryAfter <= 0 {
+		t.Fatal("expected rejected")
+	}
+
+	// half of the previous window still counts
+	now = now.Add(90 * time.Second)
+	if !l.Allow("1.2.3.4", 5).Allowed {
+		t.Fatal("expected allowed")
+	}
+	if l.Allow("1.2.3.4", 1).Allowed {
+		t.Fatal("expected rejected")
+	}
+}
+
+func TestTokenBucketLimiter(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	l := NewTokenBucketLimiter(cache.NewMemoryStore(10*time.Minute), []Rule{
+		{Name: "second", Limit: 10, Window: time.Second, Burst: 20},
+	})
+	l.now = func() time.Time { return now }
+
+	if !l.Allow("1.2.3.4", 20).Allowed {
+		t.Fatal("expected burst allowed")
+	}
+
+	result := l.Allow("1.2.3.4", 1)
+	if result.Allowed || result.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("expected rejected, got %+v", result)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	result = l.Allow("1.2.3.4", 5)
+	if !result.Allowed || result.Usages[0].Remaining != 0 {
+		t.Fatalf("expected allowed, got %+v", result)
+	}
+}
+
+func TestTokenBucketLimiterSharedStore(t *testing.T) {
+	// limiters of different replicas sharing a store never admit more than
+	// the limit between them
+	store := cache.NewMemoryStore(10 * time.Minute)
+	rules := []Rule{{Name: "hour", Limit: 10, Window: time.Hour}}
+	limiters := []*TokenBucketLimiter{
+		NewTokenBucketLimiter(store, rules),
+		NewTokenBucketLimiter(store, rules),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(l *TokenBucketLimiter) {
+			defer wg.Done()
+			if l.Allow("1.2.3.4", 1).Allowed {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}(limiters[i%2])
+	}
+	wg.Wait()
+
+	if allowed != 10 {
+		t.Fatalf("got %v allowed", allowed)
+	}
+}
@@ -0,0 +1,109 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+)
+
+// SlidingWindowLimiter approximates a sliding window by weighting the count of
+// the previous fixed window by how much of it still overlaps the sliding
+// window. Counters are only ever incremented, so it's safe to share the store
+// between processes.
+type SlidingWindowLimiter struct {
+	store cache.Store
+	rules []Rule
+	now   func() time.Time
+}
+
+// NewSlidingWindowLimiter ...
+func NewSlidingWindowLimiter(store cache.Store, rules []Rule) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		store: store,
+		rules: rules,
+		now:   time.Now,
+	}
+}
+
+// Allow ...
+func (l *SlidingWindowLimiter) Allow(key string, cost int) *Result {
+	now := l.now()
+	result := &Result{
+		Allowed: true,
+		Usages:  make([]Usage, len(l.rules)),
+	}
+
+	var charged []string
+	for i, rule := range l.rules {
+		window := now.UnixNano() / int64(rule.Window)
+		elapsed := time.Duration(now.UnixNano() - window*int64(rule.Window))
+		weight := 1 - float64(elapsed)/float64(rule.Window)
+
+		previous, _, _ := l.store.Get(l.key(key, rule, window-1))
+
+		currentKey := l.key(key, rule, window)
+		current, _ := l.store.Incr(currentKey, int64(cost), 2*rule.Window)
+		charged = append(charged, currentKey)
+
+		used := int(math.Floor(float64(previous)*weight)) + int(current)
+		remaining := rule.Limit - used
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		result.Usages[i] = Usage{
+			Rule:      rule,
+			Used:      used,
+			Remaining: remaining,
+			Reset:     rule.Window - elapsed,
+		}
+
+		if used > rule.Limit {
+			result.Allowed = false
+			retryAfter := l.retryAfter(rule, elapsed, previous, current-int64(cost), cost)
+			if retryAfter > result.RetryAfter {
+				result.RetryAfter = retryAfter
+			}
+		}
+	}
+
+	// roll back so rejected requests aren't counted
+	if !result.Allowed {
+		for i, chargedKey := range charged {
+			l.store.Incr(chargedKey, -int64(cost), 2*l.rules[i].Window)
+			result.Usages[i].Used -= cost
+			if result.Usages[i].Used < 0 {
+				result.Usages[i].Used = 0
+			}
+			result.Usages[i].Remaining = l.rules[i].Limit - result.Usages[i].Used
+			if result.Usages[i].Remaining < 0 {
+				result.Usages[i].Remaining = 0
+			}
+		}
+	}
+
+	return result
+}
+
+// retryAfter estimates how long until the previous window's weight decays
+// enough for the request to fit.
+func (l *SlidingWindowLimiter) retryAfter(rule Rule, elapsed time.Duration, previous, current int64, cost int) time.Duration {
+	room := int64(rule.Limit) - current - int64(cost)
+	if room < 0 || previous == 0 {
+		// wait for the current window to become the previous window
+		return rule.Window - elapsed
+	}
+
+	decayed := time.Duration((1 - float64(room)/float64(previous)) * float64(rule.Window))
+	if decayed <= elapsed {
+		return time.Second
+	}
+
+	return decayed - elapsed
+}
+
+func (l *SlidingWindowLimiter) key(key string, rule Rule, window int64) string {
+	return fmt.Sprintf("ratelimit:%s:%s:%v", key, rule.Name, window)
+}
@@ -0,0 +1,115 @@
+package limiter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+)
+
+// TokenBucketLimiter is a token bucket implemented as a generic cell rate
+// algorithm, so the only state per rule is the theoretical arrival time of the
+// next request. Buckets refill at Limit per Window and hold up to Burst tokens.
+// Buckets are read and written in one atomic store update, so replicas
+// sharing a store never admit more than the limit between them.
+type TokenBucketLimiter struct {
+	store cache.Store
+	rules []Rule
+	now   func() time.Time
+}
+
+// NewTokenBucketLimiter ...
+func NewTokenBucketLimiter(store cache.Store, rules []Rule) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		store: store,
+		rules: rules,
+		now:   time.Now,
+	}
+}
+
+// Allow ...
+func (l *TokenBucketLimiter) Allow(key string, cost int) *Result {
+	keys := make([]string, len(l.rules))
+	for i, rule := range l.rules {
+		keys[i] = l.key(key, rule)
+	}
+
+	now := l.now()
+	var result *Result
+	err := l.store.Update(keys, func(values []int64) ([]int64, []time.Duration, bool) {
+		var tats []time.Time
+		result, tats = l.take(values, now, cost)
+		if !result.Allowed {
+			return nil, nil, false
+		}
+
+		expires := make([]time.Duration, len(tats))
+		for i, tat := range tats {
+			values[i] = tat.UnixNano()
+			expires[i] = tat.Sub(now) + time.Second
+		}
+
+		return values, expires, true
+	})
+
+	// the store failing before the buckets were read fails open, like a miss
+	if err != nil && result == nil {
+		result, _ = l.take(make([]int64, len(l.rules)), now, cost)
+	}
+
+	return result
+}
+
+// take returns the result of spending cost tokens from buckets with the
+// given theoretical arrival times, and the new arrival times if allowed
+func (l *TokenBucketLimiter) take(values []int64, now time.Time, cost int) (*Result, []time.Time) {
+	result := &Result{
+		Allowed: true,
+		Usages:  make([]Usage, len(l.rules)),
+	}
+
+	tats := make([]time.Time, len(l.rules))
+	for i, rule := range l.rules {
+		capacity := rule.Burst
+		if capacity <= 0 {
+			capacity = rule.Limit
+		}
+
+		interval := rule.Window / time.Duration(rule.Limit)
+		tolerance := interval * time.Duration(capacity)
+
+		tat := now
+		if values[i] > now.UnixNano() {
+			tat = time.Unix(0, values[i])
+		}
+
+		newTat := tat.Add(interval * time.Duration(cost))
+		tats[i] = newTat
+
+		if over := newTat.Sub(now) - tolerance; over > 0 {
+			result.Allowed = false
+			if over > result.RetryAfter {
+				result.RetryAfter = over
+			}
+			newTat = tat
+		}
+
+		remaining := int((tolerance - newTat.Sub(now)) / interval)
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		result.Usages[i] = Usage{
+			Rule:      rule,
+			Used:      capacity - remaining,
+			Remaining: remaining,
+			Reset:     newTat.Sub(now),
+		}
+	}
+
+	return result, tats
+}
+
+func (l *TokenBucketLimiter) key(key string, rule Rule) string {
+	return fmt.Sprintf("ratelimit:%s:%s", key, rule.Name)
+}
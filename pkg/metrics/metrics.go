@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+type metric interface {
+	write(w io.Writer, name string)
+}
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Handler serves the metrics
+func (r *Registry) Handler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// Write writes every metric sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = r.metrics[name]
+	}
+	r.mu.Unlock()
+
+	for i, m := range metrics {
+		m.write(w, names[i])
+	}
+}
+
+// register returns the existing metric with the name, so packages can share
+// metrics without coordinating who creates them.
+func (r *Registry) register(name string, m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, ok := r.metrics[name]; ok {
+		return existing
+	}
+
+	r.metrics[name] = m
+	return m
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	help  string
+	value uint64
+}
+
+// NewCounter ...
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.register(name, &Counter{help: help}).(*Counter)
+}
+
+// Inc ...
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add ...
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.value, n)
+}
+
+// Value ...
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+func (c *Counter) write(w io.Writer, name string) {
+	writeHeader(w, name, c.help, "counter")
+	fmt.Fprintf(w, "%s %v\n", name, c.Value())
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	help string
+	bits uint64
+}
+
+// NewGauge ...
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.register(name, &Gauge{help: help}).(*Gauge)
+}
+
+// Set ...
+func (g *Gauge) Set(value float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(value))
+}
+
+// Value ...
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) write(w io.Writer, name string) {
+	writeHeader(w, name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %v\n", name, g.Value())
+}
+
+// Func reads its value when metrics are written
+type Func struct {
+	help string
+	kind string
+	fn   func() float64
+}
+
+// NewCounterFunc ...
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(name, &Func{help: help, kind: "counter", fn: fn})
+}
+
+// NewGaugeFunc ...
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &Func{help: help, kind: "gauge", fn: fn})
+}
+
+func (f *Func) write(w io.Writer, name string) {
+	writeHeader(w, name, f.help, f.kind)
+	fmt.Fprintf(w, "%s %v\n", name, f.fn())
+}
+
+// Vec is a set of counters or gauges partitioned by label values
+type Vec struct {
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	values map[string]*vecValue
+}
+
+type vecValue struct {
+	labelValues []string
+	counter     Counter
+	gauge       Gauge
+}
+
+// NewCounterVec ...
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *Vec {
+	return r.register(name, &Vec{help: help, kind: "counter", labels: labels, values: make(map[string]*vecValue)}).(*Vec)
+}
+
+// NewGaugeVec ...
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *Vec {
+	return r.register(name, &Vec{help: help, kind: "gauge", labels: labels, values: make(map[string]*vecValue)}).(*Vec)
+}
+
+func (v *Vec) with(labelValues []string) *vecValue {
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	value, ok := v.values[key]
+	if !ok {
+		value = &vecValue{labelValues: labelValues}
+		v.values[key] = value
+	}
+
+	return value
+}
+
+// Counter returns the counter for the label values
+func (v *Vec) Counter(labelValues ...string) *Counter {
+	return &v.with(labelValues).counter
+}
+
+// Gauge returns the gauge for the label values
+func (v *Vec) Gauge(labelValues ...string) *Gauge {
+	return &v.with(labelValues).gauge
+}
+
+func (v *Vec) write(w io.Writer, name string) {
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make([]*vecValue, len(keys))
+	for i, key := range keys {
+		values[i] = v.values[key]
+	}
+	v.mu.Unlock()
+
+	writeHeader(w, name, v.help, v.kind)
+	for _, value := range values {
+		pairs := make([]string, len(v.labels))
+		for i, label := range v.labels {
+			labelValue := ""
+			if i < len(value.labelValues) {
+				labelValue = value.labelValues[i]
+			}
+			pairs[i] = fmt.Sprintf("%s=%q", label, labelValue)
+		}
+
+		if v.kind == "counter" {
+			fmt.Fprintf(w, "%s{%s} %v\n", name, strings.Join(pairs, ","), value.counter.Value())
+			continue
+		}
+		fmt.Fprintf(w, "%s{%s} %v\n", name, strings.Join(pairs, ","), value.gauge.Value())
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// archiveTag marks upstreams that keep the state of every block
+const archiveTag = "archive"
+
+// blockParamIndexes are the positions of the block parameter of methods
+// reading state at a block
+var blockParamIndexes = map[string]int{
+	"eth_call":         1,
+	"eth_getBalance":   1,
+	"eth_getStorageAt": 2,
+	"eth_getCode":      1,
+}
+
+// blockRef is a block a request refers to
+type blockRef struct {
+	// number is set if the block was given by number or as "earliest"
+	number   uint64
+	isNumber bool
+	// tag is "latest", "pending", "safe" or "finalized" if given by tag
+	tag string
+	// hash is set if the block was given by hash, and can't be placed
+	hash bool
+}
+
+// requestedBlock returns the block the request reads state at, if the method
+// takes a block
+func requestedBlock(req *jsonrpc.Request) (*blockRef, bool) {
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, false
+		}
+	}
+
+	if req.Method == "eth_getLogs" {
+		if len(params) == 0 {
+			return nil, false
+		}
+
+		var filter struct {
+			FromBlock json.RawMessage `json:"fromBlock"`
+			BlockHash string          `json:"blockHash"`
+		}
+		if err := json.Unmarshal(params[0], &filter); err != nil {
+			return nil, false
+		}
+		if filter.BlockHash != "" {
+			return &blockRef{hash: true}, true
+		}
+
+		return parseBlockRef(filter.FromBlock)
+	}
+
+	index, ok := blockParamIndexes[req.Method]
+	if !ok {
+		return nil, false
+	}
+
+	// a missing block parameter means latest
+	if index >= len(params) {
+		return &blockRef{tag: "latest"}, true
+	}
+
+	return parseBlockRef(params[index])
+}
+
+// parseBlockRef parses a block number, tag or EIP-1898 block object
+func parseBlockRef(param json.RawMessage) (*blockRef, bool) {
+	if len(param) == 0 || string(param) == "null" {
+		return &blockRef{tag: "latest"}, true
+	}
+
+	var s string
+	if err := json.Unmarshal(param, &s); err != nil {
+		var object struct {
+			BlockNumber json.RawMessage `json:"blockNumber"`
+			BlockHash   string          `json:"blockHash"`
+		}
+		if err := json.Unmarshal(param, &object); err != nil {
+			return nil, false
+		}
+		if object.BlockHash != "" {
+			return &blockRef{hash: true}, true
+		}
+
+		return parseBlockRef(object.BlockNumber)
+	}
+
+	switch s {
+	case "latest", "pending", "safe", "finalized":
+		return &blockRef{tag: s}, true
+	case "earliest":
+		return &blockRef{number: 0, isNumber: true}, true
+	}
+
+	if !strings.HasPrefix(s, "0x") {
+		return nil, false
+	}
+
+	number, err := strconv.ParseUint(s[2:], 16, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	return &blockRef{number: number, isNumber: true}, true
+}
+
+// needsArchive returns true if any request reads state further behind the
+// head than full nodes keep
+func (c *chain) needsArchive(reqs []*jsonrpc.Request) bool {
+	head := c.head()
+	if head == 0 {
+		return false
+	}
+
+	for _, req := range reqs {
+		ref, ok := requestedBlock(req)
+		if !ok || !ref.isNumber {
+			continue
+		}
+
+		if ref.number+c.archiveBlocks < head {
+			return true
+		}
+	}
+
+	return false
+}
+
+// routeArchive narrows the upstreams to archive upstreams for historical
+// requests and to full nodes for everything else, keeping all of them if
+// none of the right kind are available.
+func (c *chain) routeArchive(reqs []*jsonrpc.Request, upstreams []*upstream) []*upstream {
+	if !c.hasArchive {
+		return upstreams
+	}
+
+	archive := c.needsArchive(reqs)
+	routed := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if u.tags[archiveTag] == archive {
+			routed = append(routed, u)
+		}
+	}
+
+	if len(routed) == 0 {
+		return upstreams
+	}
+
+	return routed
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestRequestedBlock(t *testing.T) {
+	tests := []struct {
+		method   string
+		params   string
+		number   uint64
+		isNumber bool
+		tag      string
+		hash     bool
+	}{
+		{"eth_getBalance", `["0xabc","0x10"]`, 16, true, "", false},
+		{"eth_getBalance", `["0xabc"]`, 0, false, "latest", false},
+		{"eth_getStorageAt", `["0xabc","0x0","earliest"]`, 0, true, "", false},
+		{"eth_call", `[{},{"blockNumber":"0x20"}]`, 32, true, "", false},
+		{"eth_call", `[{},{"blockHash":"0x01"}]`, 0, false, "", true},
+		{"eth_getLogs", `[{"fromBlock":"0x5","toBlock":"latest"}]`, 5, true, "", false},
+		{"eth_getLogs", `[{}]`, 0, false, "latest", false},
+	}
+
+	for _, test := range tests {
+		ref, ok := requestedBlock(&jsonrpc.Request{Method: test.method, Params: json.RawMessage(test.params)})
+		if !ok {
+			t.Fatalf("%s %s: expected block", test.method, test.params)
+		}
+		if ref.number != test.number || ref.isNumber != test.isNumber || ref.tag != test.tag || ref.hash != test.hash {
+			t.Fatalf("%s %s: unexpected block %+v", test.method, test.params, ref)
+		}
+	}
+
+	if _, ok := requestedBlock(&jsonrpc.Request{Method: "eth_chainId"}); ok {
+		t.Fatal("expected no block")
+	}
+}
+
+func TestRouteArchive(t *testing.T) {
+	full := &upstream{name: "full", tags: map[string]bool{}}
+	archive := &upstream{name: "archive", tags: map[string]bool{archiveTag: true}}
+	full.setHead(1000)
+	c := &chain{upstreams: []*upstream{full, archive}, hasArchive: true, archiveBlocks: 128}
+
+	recent := []*jsonrpc.Request{{Method: "eth_getBalance", Params: json.RawMessage(`["0xabc","0x3e7"]`)}}
+	if routed := c.routeArchive(recent, c.upstreams); len(routed) != 1 || routed[0] != full {
+		t.Fatal("expected recent block to go to the full node")
+	}
+
+	old := []*jsonrpc.Request{{Method: "eth_getBalance", Params: json.RawMessage(`["0xabc","0x1"]`)}}
+	if routed := c.routeArchive(old, c.upstreams); len(routed) != 1 || routed[0] != archive {
+		t.Fatal("expected old block to go to the archive node")
+	}
+
+	if routed := c.routeArchive(old, []*upstream{full}); len(routed) != 1 || routed[0] != full {
+		t.Fatal("expected fallback to the full node")
+	}
+}
@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load balancing strategies
+const (
+	Failover     = "failover"
+	RoundRobin   = "round-robin"
+	Weighted     = "weighted"
+	LeastLatency = "least-latency"
+)
+
+// latencyDecay is the weight of the newest sample in the latency EWMA
+const latencyDecay = 0.2
+
+// failureLatency is the latency a failed request counts as in the EWMA, so
+// upstreams that fail fast rank behind slow ones that answer
+const failureLatency = 5 * time.Second
+
+// balancer orders a chain's upstreams for each request. The first upstream
+// is tried first and the rest are fallbacks.
+type balancer struct {
+	strategy string
+	next     uint64
+	mu       sync.Mutex
+	rand     *rand.Rand
+}
+
+func newBalancer(strategy string) (*balancer, error) {
+	switch strategy {
+	case "":
+		strategy = Failover
+	case Failover, RoundRobin, Weighted, LeastLatency:
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+
+	return &balancer{
+		strategy: strategy,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// order returns the available upstreams in the order they should be tried
+func (b *balancer) order(upstreams []*upstream) []*upstream {
+	available := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if u.available() {
+			available = append(available, u)
+		}
+	}
+
+	if len(available) < 2 {
+		return available
+	}
+
+	switch b.strategy {
+	case RoundRobin:
+		offset := int(atomic.AddUint64(&b.next, 1) % uint64(len(available)))
+		return append(available[offset:], available[:offset]...)
+	case Weighted:
+		return b.weighted(available)
+	case LeastLatency:
+		// upstreams without samples go first so they get measured
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].latency() < available[j].latency()
+		})
+		return available
+	default:
+		return available
+	}
+}
+
+// weighted picks upstreams at random in proportion to their weight
+func (b *balancer) weighted(upstreams []*upstream) []*upstream {
+	total := 0
+	for _, u := range upstreams {
+		total += u.weight
+	}
+
+	ordered := make([]*upstream, 0, len(upstreams))
+	remaining := append([]*upstream(nil), upstreams...)
+	for len(remaining) > 0 {
+		b.mu.Lock()
+		pick := b.rand.Intn(total)
+		b.mu.Unlock()
+
+		for i, u := range remaining {
+			if pick < u.weight {
+				ordered = append(ordered, u)
+				total -= u.weight
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+			pick -= u.weight
+		}
+	}
+
+	return ordered
+}
+
+// observe records the outcome of a request to the upstream
+func (u *upstream) observe(latency time.Duration, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.requests++
+	if err != nil {
+		// failures stay out of the samples hedging delays are based on
+		u.failures++
+		if latency < failureLatency {
+			latency = failureLatency
+		}
+	} else {
+		u.samples[u.sampleCount%latencySamples] = latency
+		u.sampleCount++
+	}
+
+	if u.latencyEWMA == 0 {
+		u.latencyEWMA = latency
+		return
+	}
+
+	u.latencyEWMA = time.Duration(latencyDecay*float64(latency) + (1-latencyDecay)*float64(u.latencyEWMA))
+}
+
+func (u *upstream) latency() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.latencyEWMA
+}
+
+// available returns true if the upstream can be selected
+func (u *upstream) available() bool {
+	return !u.isEjected() && (u.breaker == nil || u.breaker.ready())
+}
+
+type upstreamStatus struct {
+	Name      string   `json:"name"`
+	Host      string   `json:"host"`
+	Weight    int      `json:"weight"`
+	Tags      []string `json:"tags,omitempty"`
+	Head      uint64   `json:"head,omitempty"`
+	LatencyMs float64  `json:"latencyMs"`
+	Requests  uint64   `json:"requests"`
+	Failures  uint64   `json:"failures"`
+	Available bool     `json:"available"`
+	Ejected   bool     `json:"ejected"`
+	Circuit   string   `json:"circuit,omitempty"`
+}
+
+type chainStatus struct {
+	Name          string            `json:"name"`
+	Path          string            `json:"path"`
+	LoadBalancing string            `json:"loadBalancing"`
+	Upstreams     []*upstreamStatus `json:"upstreams"`
+}
+
+func (u *upstream) status() *upstreamStatus {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return &upstreamStatus{
+		Name:      u.name,
+		Host:      u.url.Hostname(),
+		Weight:    u.weight,
+		Head:      u.latestBlock(),
+		LatencyMs: float64(u.latencyEWMA) / float64(time.Millisecond),
+		Requests:  u.requests,
+		Failures:  u.failures,
+		Ejected:   u.isEjected(),
+	}
+}
+
+// StatusHandler shows the state of every chain's upstreams used for load balancing
+func (p *Proxy) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	chains := make([]*chainStatus, len(p.chains))
+	for i, c := range p.chains {
+		status := &chainStatus{
+			Name:          c.name,
+			Path:          c.path,
+			LoadBalancing: c.balancer.strategy,
+		}
+
+		for _, u := range c.upstreams {
+			upstreamStatus := u.status()
+			upstreamStatus.Available = u.available()
+			for tag := range u.tags {
+				upstreamStatus.Tags = append(upstreamStatus.Tags, tag)
+			}
+			sort.Strings(upstreamStatus.Tags)
+			if u.breaker != nil {
+				upstreamStatus.Circuit = u.breaker.currentState()
+			}
+			status.Upstreams = append(status.Upstreams, upstreamStatus)
+		}
+
+		chains[i] = status
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"chains": chains,
+	})
+}
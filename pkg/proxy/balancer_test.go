@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLeastLatencyFailures(t *testing.T) {
+	b, err := newBalancer(LeastLatency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var upstreams []*upstream
+	for _, name := range []string{"failing", "slow"} {
+		u, err := newUpstream(&UpstreamConfig{Name: name, URL: "http://localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		upstreams = append(upstreams, u)
+	}
+	failing, slow := upstreams[0], upstreams[1]
+
+	failing.observe(10*time.Millisecond, nil)
+	slow.observe(500*time.Millisecond, nil)
+	if order := b.order(upstreams); order[0] != failing {
+		t.Fatalf("expected %s first, got %s", failing.name, order[0].name)
+	}
+
+	// failing fast counts as slower than answering slowly
+	failing.observe(time.Millisecond, errors.New("upstream status 503"))
+	if order := b.order(upstreams); order[0] != slow {
+		t.Fatalf("expected %s first, got %s", slow.name, order[0].name)
+	}
+
+	// an upstream that never answered isn't mistaken for an unmeasured one
+	unmeasured, err := newUpstream(&UpstreamConfig{Name: "unmeasured", URL: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unmeasured.observe(time.Millisecond, errors.New("connection refused"))
+	if order := b.order([]*upstream{unmeasured, slow}); order[0] != slow {
+		t.Fatalf("expected %s first, got %s", slow.name, order[0].name)
+	}
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// handleRPC answers what it can of the JSON-RPC requests locally and forwards
+// the remainder upstream, splitting batches into chunks if configured.
+func (p *Proxy) handleRPC(s *session, body []byte, reqs []*jsonrpc.Request, isBatch bool) (*proxyResponse, error) {
+	c := s.chain
+	if isBatch && c.maxBatchSize > 0 && len(reqs) > c.maxBatchSize {
+		msg := fmt.Sprintf("Batch size %v exceeds maximum of %v", len(reqs), c.maxBatchSize)
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, msg, s.ipAddress, c.name)
+		return newJSONResponse(jsonrpc.NewErrorResponse(nil, jsonrpc.CodeInvalidRequest, msg, nil))
+	}
+
+	responses := make([]*jsonrpc.Response, len(reqs))
+	// computed are the requests answered from several upstream requests
+	var pending, quorums, computed []int
+	for i, req := range reqs {
+		if resp := p.localResponse(c, req); resp != nil {
+			responses[i] = resp
+			continue
+		}
+		if resp := p.txPolicyResponse(s, req); resp != nil {
+			responses[i] = resp
+			continue
+		}
+		if resp := p.broadcastResponse(s, req); resp != nil {
+			responses[i] = resp
+			continue
+		}
+		if resp := p.filterResponse(s, req); resp != nil {
+			responses[i] = resp
+			continue
+		}
+		if resp := p.logsResponse(s, req); resp != nil {
+			responses[i] = resp
+			computed = append(computed, i)
+			continue
+		}
+		if c.quorum.required(req) {
+			quorums = append(quorums, i)
+			continue
+		}
+		pending = append(pending, i)
+	}
+
+	pendingReqs := make([]*jsonrpc.Request, len(pending))
+	for i, index := range pending {
+		pendingReqs[i] = reqs[index]
+	}
+	pinned := c.pinLatest(pendingReqs)
+
+	// nothing was answered locally, so proxy the body untouched unless
+	// requests were pinned to a block
+	if len(pending) == len(reqs) && (!isBatch || c.batchChunkSize <= 0 || len(reqs) <= c.batchChunkSize) {
+		if pinned {
+			var err error
+			if isBatch {
+				body, err = json.Marshal(reqs)
+			} else {
+				body, err = json.Marshal(reqs[0])
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := p.forward(s, reqs, body)
+		if err != nil {
+			return nil, err
+		}
+
+		if upstreamResponses, _, err := jsonrpc.ParseResponses(resp.body); err == nil {
+			if matched, ambiguous := matchResponses(reqs, upstreamResponses); !ambiguous {
+				p.cacheResponses(c, reqs, matched)
+			}
+			p.recordTransactions(s, reqs, upstreamResponses, resp.upstream)
+		}
+
+		p.mirror(s, reqs, body, resp)
+
+		return resp, nil
+	}
+
+	chunkSize := len(pending)
+	if isBatch && c.batchChunkSize > 0 {
+		chunkSize = c.batchChunkSize
+	}
+
+	// each quorum request waits on every upstream, so they're sent
+	// alongside each other and the chunks
+	var wg sync.WaitGroup
+	for _, index := range quorums {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			responses[index] = p.quorumResponse(s, reqs[index])
+		}(index)
+	}
+
+	for start := 0; start < len(pending); start += chunkSize {
+		end := start + chunkSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+
+			chunk := make([]*jsonrpc.Request, len(indexes))
+			for i, index := range indexes {
+				chunk[i] = reqs[index]
+			}
+
+			for i, resp := range p.forwardBatch(s, chunk) {
+				responses[indexes[i]] = resp
+			}
+		}(pending[start:end])
+	}
+	wg.Wait()
+
+	// forwarded responses were cached as they came in
+	computed = append(computed, quorums...)
+	computedReqs := make([]*jsonrpc.Request, len(computed))
+	computedResps := make([]*jsonrpc.Response, len(computed))
+	for i, index := range computed {
+		computedReqs[i], computedResps[i] = reqs[index], responses[index]
+	}
+	p.cacheResponses(c, computedReqs, computedResps)
+
+	if !isBatch {
+		if reqs[0].IsNotification() {
+			return &proxyResponse{statusCode: http.StatusOK, header: http.Header{}}, nil
+		}
+		return newJSONResponse(responses[0])
+	}
+
+	// notifications don't get a response
+	result := make([]*jsonrpc.Response, 0, len(responses))
+	for i, resp := range responses {
+		if reqs[i].IsNotification() || resp == nil {
+			continue
+		}
+		result = append(result, resp)
+	}
+
+	return newJSONResponse(result)
+}
+
+// invalidRequestResponse rejects a body that isn't valid JSON-RPC. Bodies
+// aren't forwarded unless every request in them can be checked, since an
+// upstream would still answer the valid elements of a malformed batch.
+func invalidRequestResponse(s *session, body []byte, err error) (*proxyResponse, error) {
+	code, msg := jsonrpc.CodeInvalidRequest, "Invalid request"
+	if !json.Valid(body) {
+		code, msg = jsonrpc.CodeParseError, "Parse error"
+	}
+	if err == nil {
+		err = errors.New("empty batch")
+	}
+
+	fmt.Printf("ERROR ID=%v: %s: %s IP=%s CHAIN=%s\n", s.id, msg, err, s.ipAddress, s.chain.name)
+	return newJSONResponse(jsonrpc.NewErrorResponse(nil, code, msg, nil))
+}
+
+// forwardBatch forwards the requests upstream as a batch and returns the
+// responses in the same order as the requests, matched by id.
+func (p *Proxy) forwardBatch(s *session, reqs []*jsonrpc.Request) []*jsonrpc.Response {
+	responses := make([]*jsonrpc.Response, len(reqs))
+	fail := func(msg string) []*jsonrpc.Response {
+		for i, req := range reqs {
+			responses[i] = jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, msg, nil)
+		}
+		return responses
+	}
+
+	body, err := json.Marshal(reqs)
+	if err != nil {
+		return fail(err.Error())
+	}
+
+	resp, err := p.forward(s, reqs, body)
+	if err != nil {
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, s.chain.name)
+		return fail("Upstream request failed")
+	}
+
+	upstreamResponses, isBatch, err := jsonrpc.ParseResponses(resp.body)
+	if err != nil {
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s STATUS=%v\n", s.id, err, s.ipAddress, s.chain.name, resp.statusCode)
+		return fail(fmt.Sprintf("Invalid upstream response (status %v)", resp.statusCode))
+	}
+
+	// the upstream rejected the batch as a whole
+	if !isBatch && upstreamResponses[0].Error != nil {
+		for i, req := range reqs {
+			responses[i] = jsonrpc.NewErrorResponse(req.ID, upstreamResponses[0].Error.Code, upstreamResponses[0].Error.Message, upstreamResponses[0].Error.Data)
+		}
+		return responses
+	}
+
+	matched, ambiguous := matchResponses(reqs, upstreamResponses)
+	for i, req := range reqs {
+		if req.IsNotification() {
+			continue
+		}
+
+		if matched[i] == nil {
+			matched[i] = jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, "Missing response from upstream", nil)
+		}
+		responses[i] = matched[i]
+	}
+
+	if !ambiguous {
+		p.cacheResponses(s.chain, reqs, responses)
+	}
+	p.recordTransactions(s, reqs, responses, resp.upstream)
+	p.mirror(s, reqs, body, resp)
+
+	return responses
+}
+
+// matchResponses returns the responses in the order of the requests,
+// matched by id, with nil for requests without one. Ids should be unique but
+// nothing stops a client or an upstream from reusing them, in which case
+// responses may be matched to the wrong requests and ambiguous is true.
+func matchResponses(reqs []*jsonrpc.Request, resps []*jsonrpc.Response) (matched []*jsonrpc.Response, ambiguous bool) {
+	byID := make(map[string][]*jsonrpc.Response, len(resps))
+	for _, resp := range resps {
+		key := jsonrpc.IDKey(resp.ID)
+		if len(byID[key]) > 0 {
+			ambiguous = true
+		}
+		byID[key] = append(byID[key], resp)
+	}
+
+	matched = make([]*jsonrpc.Response, len(reqs))
+	requested := make(map[string]bool, len(reqs))
+	for i, req := range reqs {
+		if req.IsNotification() {
+			continue
+		}
+
+		key := jsonrpc.IDKey(req.ID)
+		if requested[key] {
+			ambiguous = true
+		}
+		requested[key] = true
+
+		if matches := byID[key]; len(matches) > 0 {
+			matched[i] = matches[0]
+			byID[key] = matches[1:]
+		}
+	}
+
+	return matched, ambiguous
+}
+
+// newJSONResponse builds a locally answered response
+func newJSONResponse(v interface{}) (*proxyResponse, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	return &proxyResponse{
+		statusCode: http.StatusOK,
+		header:     header,
+		body:       body,
+	}, nil
+}
@@ -0,0 +1,166 @@
+package proxy_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestBatchChunking(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+	server.SetMaxBatchSize(2)
+	server.SetBlockNumber(5)
+
+	config := proxytest.Config(server)
+	config.BatchChunkSize = 2
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the notification is forwarded but gets no response, and the reused id
+	// is matched to the responses in order
+	resps := postBatch(t, p.URL, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]},
+		{"jsonrpc":"2.0","method":"eth_blockNumber","params":[]},
+		{"jsonrpc":"2.0","id":"a","method":"net_version","params":[]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_gasPrice","params":[]}
+	]`)
+
+	expected := []struct{ id, result string }{
+		{"1", `"0x1"`},
+		{"1", `"0x5"`},
+		{`"a"`, `"1"`},
+		{"3", `"0x3b9aca00"`},
+	}
+	if len(resps) != len(expected) {
+		t.Fatalf("expected %v responses, got %v", len(expected), len(resps))
+	}
+	for i, resp := range resps {
+		if string(resp.ID) != expected[i].id || string(resp.Result) != expected[i].result {
+			t.Fatalf("response %v: expected %s %s, got %s %s %+v", i, expected[i].id, expected[i].result, resp.ID, resp.Result, resp.Error)
+		}
+	}
+
+	if server.Count("eth_blockNumber") != 2 {
+		t.Fatalf("expected the notification to be forwarded, got %v", server.Count("eth_blockNumber"))
+	}
+}
+
+func TestBatchRejectedByUpstream(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+	server.SetMaxBatchSize(2)
+
+	config := proxytest.Config(server)
+	config.BatchChunkSize = 3
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the first chunk of three is rejected as a whole, the second is answered
+	resps := postBatch(t, p.URL, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":3,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":4,"method":"eth_chainId","params":[]}
+	]`)
+	if len(resps) != 4 {
+		t.Fatalf("expected 4 responses, got %v", len(resps))
+	}
+	for i, resp := range resps[:3] {
+		if resp.Error == nil || resp.Error.Code != jsonrpc.CodeLimitExceeded || string(resp.ID) != strconv.Itoa(i+1) {
+			t.Fatalf("response %v: expected the batch error with its own id, got %s %+v", i, resp.ID, resp.Error)
+		}
+	}
+	if resps[3].Error != nil || string(resps[3].Result) != `"0x1"` {
+		t.Fatalf("expected the last chunk to be answered, got %s %+v", resps[3].Result, resps[3].Error)
+	}
+}
+
+func TestMalformedBodyRejected(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+
+	config := proxytest.Config(server)
+	config.BlockedMethods = []string{"debug_traceTransaction"}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`[1, {"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction","params":["0x01"]}]`, jsonrpc.CodeInvalidRequest},
+		{`[]`, jsonrpc.CodeInvalidRequest},
+		{`{"jsonrpc":"2.0","id":1,"method":`, jsonrpc.CodeParseError},
+		{`hello`, jsonrpc.CodeParseError},
+	}
+
+	for _, test := range tests {
+		resps := postBatch(t, p.URL, test.body)
+		if len(resps) != 1 || resps[0].Error == nil || resps[0].Error.Code != test.code {
+			t.Fatalf("%s: expected error %v, got %+v", test.body, test.code, resps[0])
+		}
+	}
+
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expected nothing forwarded, got %v requests", len(server.Requests()))
+	}
+}
+
+func TestMalformedBatchWithDeniedTransaction(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+
+	// the EIP-155 example transaction, sent by a denied sender
+	config := proxytest.Config(server)
+	config.TxDeniedSenders = []string{"0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	sendTx := `{"jsonrpc":"2.0","id":1,"method":"eth_sendRawTransaction","params":["0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"]}`
+
+	resps := postBatch(t, p.URL, "["+sendTx+"]")
+	if len(resps) != 1 || resps[0].Error == nil || resps[0].Error.Code != jsonrpc.CodeTransactionRejected {
+		t.Fatalf("expected the transaction to be rejected, got %+v", resps[0])
+	}
+
+	// a malformed element must not let the transaction around the policy
+	resps = postBatch(t, p.URL, "[1, "+sendTx+"]")
+	if len(resps) != 1 || resps[0].Error == nil || resps[0].Error.Code != jsonrpc.CodeInvalidRequest {
+		t.Fatalf("expected the malformed batch to be rejected, got %+v", resps[0])
+	}
+
+	if len(server.Requests()) != 0 {
+		t.Fatalf("expected nothing forwarded, got %v requests", len(server.Requests()))
+	}
+}
+
+// postBatch posts the body to the proxy and returns the JSON-RPC responses
+func postBatch(t *testing.T, url string, body string) []*jsonrpc.Response {
+	_, respBody := post(t, url, body)
+	resps, _, err := jsonrpc.ParseResponses(respBody)
+	if err != nil {
+		t.Fatalf("invalid response %q: %s", respBody, err)
+	}
+
+	return resps
+}
@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// breakerConfig holds the thresholds that open a circuit
+type breakerConfig struct {
+	// errorRate opens the circuit when this share of the recent requests failed
+	errorRate float64
+	// window is the number of recent requests the error rate is computed over
+	window int
+	// consecutiveFailures opens the circuit after this many failures in a row
+	consecutiveFailures int
+	// cooldown is how long the circuit stays open before a probe is let through
+	cooldown time.Duration
+}
+
+// newBreakerConfig returns the circuit breaker thresholds with defaults
+func newBreakerConfig(config *Config) (breakerConfig, error) {
+	errorRate := config.CircuitBreakerErrorRate
+	if errorRate == 0 {
+		errorRate = 0.5
+	}
+	if errorRate < 0 || errorRate > 1 {
+		return breakerConfig{}, fmt.Errorf("invalid circuit breaker error rate %v", errorRate)
+	}
+
+	window := firstInt(config.CircuitBreakerWindow, 20)
+	if window < 1 {
+		return breakerConfig{}, fmt.Errorf("invalid circuit breaker window %v", window)
+	}
+
+	cooldown := time.Duration(config.CircuitBreakerCooldown)
+	if cooldown == 0 {
+		cooldown = 30 * time.Second
+	}
+
+	return breakerConfig{
+		errorRate:           errorRate,
+		window:              window,
+		consecutiveFailures: firstInt(config.CircuitBreakerFailures, 5),
+		cooldown:            cooldown,
+	}, nil
+}
+
+// breaker stops requests to an upstream that keeps failing. After the
+// cooldown a single probe request is let through while half-open, closing
+// the circuit if it succeeds and opening it again if it fails.
+type breaker struct {
+	config   breakerConfig
+	mu       sync.Mutex
+	state    string
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+	// consecutive is the number of failures in a row
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+	now         func() time.Time
+}
+
+func newBreaker(config breakerConfig) *breaker {
+	return &breaker{
+		config:   config,
+		state:    circuitClosed,
+		outcomes: make([]bool, config.window),
+		now:      time.Now,
+	}
+}
+
+// ready returns true if a request could be sent, without claiming the probe
+func (b *breaker) ready() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		return b.now().Sub(b.openedAt) >= b.config.cooldown
+	case circuitHalfOpen:
+		return !b.probing
+	default:
+		return true
+	}
+}
+
+// acquire returns true if a request may be sent, claiming the probe if the
+// circuit is half-open. It also returns the new state if it changed.
+func (b *breaker) acquire() (bool, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if b.now().Sub(b.openedAt) < b.config.cooldown {
+			return false, ""
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true, circuitHalfOpen
+	case circuitHalfOpen:
+		if b.probing {
+			return false, ""
+		}
+		b.probing = true
+		return true, ""
+	default:
+		return true, ""
+	}
+}
+
+// release gives back a probe whose outcome is unknown
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+// record adds the outcome of a request and returns the new state if it changed
+func (b *breaker) record(failed bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitHalfOpen:
+		b.probing = false
+		if failed {
+			b.open()
+			return circuitOpen
+		}
+		b.reset()
+		return circuitClosed
+	case circuitOpen:
+		// a request sent before the circuit opened
+		return ""
+	}
+
+	if b.count == len(b.outcomes) && b.outcomes[b.next] {
+		b.failures--
+	}
+	if b.count < len(b.outcomes) {
+		b.count++
+	}
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % len(b.outcomes)
+
+	if !failed {
+		b.consecutive = 0
+		return ""
+	}
+
+	b.failures++
+	b.consecutive++
+
+	if b.config.consecutiveFailures > 0 && b.consecutive >= b.config.consecutiveFailures {
+		b.open()
+		return circuitOpen
+	}
+
+	if b.config.errorRate > 0 && b.count == len(b.outcomes) && float64(b.failures)/float64(b.count) >= b.config.errorRate {
+		b.open()
+		return circuitOpen
+	}
+
+	return ""
+}
+
+// currentState returns the state of the circuit
+func (b *breaker) currentState() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+func (b *breaker) open() {
+	b.state = circuitOpen
+	b.openedAt = b.now()
+}
+
+func (b *breaker) reset() {
+	b.state = circuitClosed
+	b.outcomes = make([]bool, len(b.outcomes))
+	b.next = 0
+	b.count = 0
+	b.failures = 0
+	b.consecutive = 0
+}
+
+// circuitStateValue is the value of the circuit state gauge
+func circuitStateValue(state string) float64 {
+	switch state {
+	case circuitOpen:
+		return 1
+	case circuitHalfOpen:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
+// acquireUpstream returns true if a request may be sent to the upstream
+func (p *Proxy) acquireUpstream(c *chain, u *upstream) bool {
+	if u.breaker == nil {
+		return true
+	}
+
+	ok, state := u.breaker.acquire()
+	if state != "" {
+		p.circuitChanged(c, u, state, "cooldown elapsed")
+	}
+
+	return ok
+}
+
+// recordUpstream feeds the outcome of a request to the upstream's breaker
+func (p *Proxy) recordUpstream(c *chain, u *upstream, err error) {
+	if u.breaker == nil {
+		return
+	}
+
+	if state := u.breaker.record(err != nil); state != "" {
+		reason := "probe succeeded"
+		if err != nil {
+			reason = err.Error()
+		}
+		p.circuitChanged(c, u, state, reason)
+	}
+}
+
+func (p *Proxy) circuitChanged(c *chain, u *upstream, state string, reason string) {
+	p.circuitStateGauge.Gauge(c.name, u.name).Set(circuitStateValue(state))
+
+	icon := "✅"
+	switch state {
+	case circuitOpen:
+		icon = "⛔"
+	case circuitHalfOpen:
+		icon = "🔎"
+	}
+
+	notification := fmt.Sprintf("%s Circuit %s: %s CHAIN=%s UPSTREAM=%s PROXY=%s\n", icon, state, reason, c.name, u.name, u.url.Hostname())
+	fmt.Printf(notification)
+	p.sendNotification(notification)
+}
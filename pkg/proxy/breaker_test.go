@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	b := newBreaker(breakerConfig{errorRate: 0.5, window: 4, consecutiveFailures: 3, cooldown: time.Minute})
+	b.now = func() time.Time { return now }
+
+	b.record(true)
+	b.record(false)
+	if state := b.record(false); state != "" {
+		t.Fatalf("expected closed, got %s", state)
+	}
+
+	// half of the last 4 requests failed
+	if state := b.record(true); state != circuitOpen {
+		t.Fatalf("expected open, got %s", state)
+	}
+	if b.ready() {
+		t.Fatal("expected open circuit to reject")
+	}
+
+	now = now.Add(time.Minute)
+	if ok, state := b.acquire(); !ok || state != circuitHalfOpen {
+		t.Fatalf("expected probe while half-open, got %v %s", ok, state)
+	}
+	if ok, _ := b.acquire(); ok {
+		t.Fatal("expected a single probe")
+	}
+	if state := b.record(false); state != circuitClosed {
+		t.Fatalf("expected closed, got %s", state)
+	}
+
+	b.record(true)
+	b.record(true)
+	if state := b.record(true); state != circuitOpen {
+		t.Fatalf("expected open after consecutive failures, got %s", state)
+	}
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/tx"
+)
+
+// knownTxErrors are upstream errors meaning the transaction is already in
+// the node's pool, which is as good as accepting it
+var knownTxErrors = []string{
+	"already known",
+	"known transaction",
+	"already imported",
+}
+
+// broadcastTargets returns where transactions are sent: the configured
+// broadcast set, or every available upstream.
+func (c *chain) broadcastTargets() []*upstream {
+	candidates := c.broadcastUpstreams
+	if len(candidates) == 0 {
+		candidates = c.upstreams
+	}
+
+	targets := make([]*upstream, 0, len(candidates))
+	for _, u := range candidates {
+		if u.available() {
+			targets = append(targets, u)
+		}
+	}
+
+	return targets
+}
+
+// broadcastResponse sends eth_sendRawTransaction to every broadcast target
+// at once, returning the first success, or the most informative error if
+// none succeed. It returns nil for other requests.
+func (p *Proxy) broadcastResponse(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	c := s.chain
+	if !c.broadcast || req.Method != "eth_sendRawTransaction" {
+		return nil
+	}
+
+	targets := c.broadcastTargets()
+	if len(targets) == 0 {
+		return nil
+	}
+
+	txHash := rawTxHash(req)
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+
+	type result struct {
+		upstream *upstream
+		resp     *jsonrpc.Response
+		err      error
+	}
+
+	// buffered so that slower targets still get the transaction after the
+	// client was answered
+	results := make(chan *result, len(targets))
+	for _, u := range targets {
+		go func(u *upstream) {
+			resp, err := p.forwardTo(context.Background(), s, u, body)
+			if err != nil {
+				results <- &result{u, nil, err}
+				return
+			}
+
+			responses, _, err := jsonrpc.ParseResponses(resp.body)
+			if err != nil || len(responses) != 1 {
+				results <- &result{u, nil, fmt.Errorf("invalid response (status %v)", resp.statusCode)}
+				return
+			}
+
+			results <- &result{u, responses[0], nil}
+		}(u)
+	}
+
+	var rejection *result
+	for range targets {
+		r := <-results
+		if r.err != nil {
+			fmt.Printf("BROADCAST ERROR ID=%v: %s IP=%s CHAIN=%s UPSTREAM=%s\n", s.id, r.err, s.ipAddress, c.name, r.upstream.name)
+			continue
+		}
+
+		if r.resp.Error == nil {
+			p.broadcastsCounter.Counter(c.name, "accepted").Inc()
+			p.recordTransaction(s, req, r.resp, r.upstream)
+			return jsonrpc.NewResultResponse(req.ID, r.resp.Result)
+		}
+
+		if txHash != "" && isKnownTxError(r.resp.Error.Message) {
+			p.broadcastsCounter.Counter(c.name, "known").Inc()
+			p.recordTransaction(s, req, r.resp, r.upstream)
+			return newResult(req.ID, txHash)
+		}
+
+		fmt.Printf("BROADCAST ERROR ID=%v: %s IP=%s CHAIN=%s UPSTREAM=%s\n", s.id, r.resp.Error.Message, s.ipAddress, c.name, r.upstream.name)
+
+		// an error from a node explains more than a failed connection
+		if rejection == nil {
+			rejection = r
+		}
+	}
+
+	p.broadcastsCounter.Counter(c.name, "rejected").Inc()
+	if rejection != nil {
+		p.recordTransaction(s, req, rejection.resp, rejection.upstream)
+		rpcError := rejection.resp.Error
+		return jsonrpc.NewErrorResponse(req.ID, rpcError.Code, rpcError.Message, rpcError.Data)
+	}
+
+	return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, "Transaction broadcast failed", nil)
+}
+
+// rawTxHash returns the hash of the request's raw transaction, or an empty
+// string if it can't be decoded
+func rawTxHash(req *jsonrpc.Request) string {
+	raw, err := rawTx(req)
+	if err != nil {
+		return ""
+	}
+
+	hash, err := tx.Hash(raw)
+	if err != nil {
+		return ""
+	}
+
+	return "0x" + hex.EncodeToString(hash)
+}
+
+// rawTx returns the raw transaction of an eth_sendRawTransaction request
+func rawTx(req *jsonrpc.Request) ([]byte, error) {
+	var params []string
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return nil, errors.New("missing raw transaction")
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(params[0], "0x"))
+	if err != nil || len(raw) == 0 {
+		return nil, errors.New("invalid raw transaction")
+	}
+
+	return raw, nil
+}
+
+func isKnownTxError(message string) bool {
+	message = strings.ToLower(message)
+	for _, known := range knownTxErrors {
+		if strings.Contains(message, known) {
+			return true
+		}
+	}
+
+	return false
+}
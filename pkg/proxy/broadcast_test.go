@@ -0,0 +1,109 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+// signedTx is the EIP-155 example transaction and its hash
+const (
+	signedTx     = "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	signedTxHash = "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788"
+)
+
+// replyServer returns an upstream answering every request with the reply
+func replyServer(reply string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(reply))
+	}))
+}
+
+// newBroadcastProxy returns a proxy broadcasting to the servers and to the
+// raw upstreams
+func newBroadcastProxy(t *testing.T, servers []*proxytest.Server, raw ...*httptest.Server) *proxytest.Proxy {
+	config := proxytest.Config(servers...)
+	config.Broadcast = true
+	for _, server := range raw {
+		config.Upstreams = append(config.Upstreams, &proxy.UpstreamConfig{Name: "raw", URL: server.URL})
+	}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestBroadcastFirstSuccessWins(t *testing.T) {
+	rejecting := proxytest.NewServer()
+	defer rejecting.Close()
+	accepting := proxytest.NewServer()
+	defer accepting.Close()
+
+	rejecting.SetError("eth_sendRawTransaction", -32000, "nonce too low")
+
+	p := newBroadcastProxy(t, []*proxytest.Server{rejecting, accepting})
+	defer p.Close()
+
+	expectResult(t, p.URL, `"`+signedTxHash+`"`, "eth_sendRawTransaction", signedTx)
+	if rejecting.Count("eth_sendRawTransaction") != 1 || accepting.Count("eth_sendRawTransaction") != 1 {
+		t.Fatal("expected the transaction sent to every upstream")
+	}
+
+	// a node that already has it counts as accepting it
+	accepting.SetError("eth_sendRawTransaction", -32000, "already known")
+	expectResult(t, p.URL, `"`+signedTxHash+`"`, "eth_sendRawTransaction", signedTx)
+}
+
+func TestBroadcastAllFail(t *testing.T) {
+	first := proxytest.NewServer()
+	defer first.Close()
+	second := proxytest.NewServer()
+	defer second.Close()
+
+	first.SetError("eth_sendRawTransaction", -32000, "nonce too low")
+	second.SetError("eth_sendRawTransaction", -32000, "nonce too low")
+
+	p := newBroadcastProxy(t, []*proxytest.Server{first, second})
+	defer p.Close()
+
+	resp, err := p.Call("eth_sendRawTransaction", signedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Message != "nonce too low" {
+		t.Fatalf("expected the node's error, got %s %+v", resp.Result, resp.Error)
+	}
+}
+
+func TestBroadcastMalformedReply(t *testing.T) {
+	accepting := proxytest.NewServer()
+	defer accepting.Close()
+
+	empty := replyServer(`[]`)
+	defer empty.Close()
+	invalid := replyServer(`not json`)
+	defer invalid.Close()
+
+	p := newBroadcastProxy(t, []*proxytest.Server{accepting}, empty, invalid)
+	defer p.Close()
+
+	expectResult(t, p.URL, `"`+signedTxHash+`"`, "eth_sendRawTransaction", signedTx)
+
+	// without a valid reply the broadcast fails
+	p = newBroadcastProxy(t, nil, empty)
+	defer p.Close()
+
+	resp, err := p.Call("eth_sendRawTransaction", signedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Message != "Transaction broadcast failed" {
+		t.Fatalf("expected the broadcast to fail, got %s %+v", resp.Result, resp.Error)
+	}
+}
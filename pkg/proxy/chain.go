@@ -0,0 +1,443 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/limiter"
+)
+
+// ChainConfig configures a network served on its own path. Unset fields
+// default to the values in Config.
+type ChainConfig struct {
+	Name                       string              `json:"name"`
+	Path                       string              `json:"path"`
+	ChainID                    uint64              `json:"chainId"`
+	ProxyURL                   string              `json:"proxyUrl"`
+	Upstreams                  []*UpstreamConfig   `json:"upstreams"`
+	LoadBalancing              string              `json:"loadBalancing"`
+	ArchiveBlocks              int                 `json:"archiveBlocks"`
+	ServeHead                  bool                `json:"serveHead"`
+	ProxyMethod                string              `json:"proxyMethod"`
+	AuthorizationSecret        string              `json:"authSecret"`
+	SoftCapIPRequestsPerMinute int                 `json:"softCapIpRequestsPerMinute"`
+	HardCapIPRequestsPerMinute int                 `json:"hardCapIpRequestsPerMinute"`
+	IPRequestsPerSecond        int                 `json:"ipRequestsPerSecond"`
+	IPRequestsPerDay           int                 `json:"ipRequestsPerDay"`
+	IPBurst                    int                 `json:"ipBurst"`
+	IPMinuteBurst              int                 `json:"ipMinuteBurst"`
+	IPDayBurst                 int                 `json:"ipDayBurst"`
+	RateLimitAlgorithm         string              `json:"rateLimitAlgorithm"`
+	MethodCosts                map[string]int      `json:"methodCosts"`
+	DefaultMethodCost          int                 `json:"defaultMethodCost"`
+	MaxBatchSize               int                 `json:"maxBatchSize"`
+	BatchChunkSize             int                 `json:"batchChunkSize"`
+	BlockedMethods             []string            `json:"blockedMethods"`
+	LogsMaxBlockRange          int                 `json:"logsMaxBlockRange"`
+	LogsMaxAddresses           int                 `json:"logsMaxAddresses"`
+	LogsMaxTopics              int                 `json:"logsMaxTopics"`
+	LogsChunking               bool                `json:"logsChunking"`
+	LogsChunkConcurrency       int                 `json:"logsChunkConcurrency"`
+	LogsMaxChunks              int                 `json:"logsMaxChunks"`
+	Broadcast                  bool                `json:"broadcast"`
+	BroadcastUpstreams         []*UpstreamConfig   `json:"broadcastUpstreams"`
+	TxAllowedSenders           []string            `json:"txAllowedSenders"`
+	TxDeniedSenders            []string            `json:"txDeniedSenders"`
+	TxAllowedRecipients        []string            `json:"txAllowedRecipients"`
+	TxDeniedRecipients         []string            `json:"txDeniedRecipients"`
+	TxAllowedChainIDs          []uint64            `json:"txAllowedChainIds"`
+	TxMaxValue                 string              `json:"txMaxValue"`
+	TxMaxGasPrice              string              `json:"txMaxGasPrice"`
+	EmulateFilters             bool                `json:"emulateFilters"`
+	FilterTimeout              Duration            `json:"filterTimeout"`
+	MaxFiltersPerIP            int                 `json:"maxFiltersPerIp"`
+	HedgedMethods              []string            `json:"hedgedMethods"`
+	HedgePercentile            int                 `json:"hedgePercentile"`
+	HedgeDelay                 Duration            `json:"hedgeDelay"`
+	CachedMethods              map[string]Duration `json:"cachedMethods"`
+	ShadowUpstream             *UpstreamConfig     `json:"shadowUpstream"`
+	ShadowMethods              []string            `json:"shadowMethods"`
+	ShadowTimeout              Duration            `json:"shadowTimeout"`
+	QuorumMethods              map[string]int      `json:"quorumMethods"`
+	QuorumTimeout              Duration            `json:"quorumTimeout"`
+}
+
+// chain holds the upstreams and policies of a network served on a path
+type chain struct {
+	name                       string
+	path                       string
+	chainID                    uint64
+	upstreams                  []*upstream
+	balancer                   *balancer
+	hasArchive                 bool
+	archiveBlocks              uint64
+	tracksHead                 bool
+	pinsLatest                 bool
+	servesHead                 bool
+	headMaxAge                 time.Duration
+	proxyMethod                string
+	authorizationSecret        string
+	softCapIPRequestsPerMinute int
+	hardCapIPRequestsPerMinute int
+	limiter                    limiter.Limiter
+	rateLimitAlgorithm         string
+	methodCosts                map[string]int
+	defaultMethodCost          int
+	maxBatchSize               int
+	batchChunkSize             int
+	blockedMethods             map[string]bool
+	logs                       *logsPolicy
+	broadcast                  bool
+	broadcastUpstreams         []*upstream
+	txPolicy                   *txPolicy
+	emulateFilters             bool
+	filterTimeout              time.Duration
+	maxFiltersPerIP            int
+	filterOwners               *filterOwners
+	hedgedMethods              map[string]bool
+	hedgePercentile            int
+	hedgeDelay                 time.Duration
+	cachedMethods              map[string]time.Duration
+	shadow                     *shadowPolicy
+	quorum                     *quorumPolicy
+}
+
+// newChain builds a chain from its config, falling back to the proxy config
+// for anything the chain doesn't set.
+func newChain(config *Config, chainConfig *ChainConfig, store cache.Store) (*chain, error) {
+	name := chainConfig.Name
+	if name == "" {
+		return nil, fmt.Errorf("chain name is required")
+	}
+
+	path := "/" + strings.Trim(chainConfig.Path, "/")
+
+	upstreamConfigs := chainConfig.Upstreams
+	if len(upstreamConfigs) == 0 && chainConfig.ProxyURL != "" {
+		upstreamConfigs = []*UpstreamConfig{{URL: chainConfig.ProxyURL}}
+	}
+	if len(upstreamConfigs) == 0 {
+		return nil, fmt.Errorf("chain %s has no upstreams", name)
+	}
+
+	var circuitConfig breakerConfig
+	if config.CircuitBreaker {
+		var err error
+		circuitConfig, err = newBreakerConfig(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upstreams := make([]*upstream, len(upstreamConfigs))
+	for i, upstreamConfig := range upstreamConfigs {
+		u, err := newUpstream(upstreamConfig)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: %s", name, err)
+		}
+		if config.CircuitBreaker {
+			u.breaker = newBreaker(circuitConfig)
+		}
+		upstreams[i] = u
+	}
+
+	hasArchive := false
+	for _, u := range upstreams {
+		if u.tags[archiveTag] {
+			hasArchive = true
+		}
+	}
+
+	archiveBlocks := firstInt(chainConfig.ArchiveBlocks, config.ArchiveBlocks, 128)
+	if archiveBlocks < 0 {
+		return nil, fmt.Errorf("chain %s: invalid archive blocks %v", name, archiveBlocks)
+	}
+
+	balancer, err := newBalancer(firstString(chainConfig.LoadBalancing, config.LoadBalancing))
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %s", name, err)
+	}
+
+	method := "GET"
+	if proxyMethod := firstString(chainConfig.ProxyMethod, config.ProxyMethod); proxyMethod != "" {
+		method = strings.ToUpper(proxyMethod)
+	}
+
+	softCapIPRequestsPerMinute := firstInt(chainConfig.SoftCapIPRequestsPerMinute, config.SoftCapIPRequestsPerMinute, 100)
+	hardCapIPRequestsPerMinute := firstInt(chainConfig.HardCapIPRequestsPerMinute, config.HardCapIPRequestsPerMinute, 1000)
+	ipRequestsPerSecond := firstInt(chainConfig.IPRequestsPerSecond, config.IPRequestsPerSecond)
+	ipRequestsPerDay := firstInt(chainConfig.IPRequestsPerDay, config.IPRequestsPerDay)
+	ipBurst := firstInt(chainConfig.IPBurst, config.IPBurst)
+	ipMinuteBurst := firstInt(chainConfig.IPMinuteBurst, config.IPMinuteBurst)
+	ipDayBurst := firstInt(chainConfig.IPDayBurst, config.IPDayBurst)
+
+	// the burst applies to the shortest window only, a burst sized for
+	// seconds would make a day limit refill one request at a time
+	rateLimitRules := []limiter.Rule{
+		{Name: "min", Limit: hardCapIPRequestsPerMinute, Window: 1 * time.Minute, Burst: ipMinuteBurst},
+	}
+
+	if ipRequestsPerSecond != 0 {
+		rateLimitRules = append([]limiter.Rule{
+			{Name: "sec", Limit: ipRequestsPerSecond, Window: 1 * time.Second, Burst: ipBurst},
+		}, rateLimitRules...)
+	} else {
+		rateLimitRules[0].Burst = firstInt(ipMinuteBurst, ipBurst)
+	}
+
+	if ipRequestsPerDay != 0 {
+		rateLimitRules = append(rateLimitRules, limiter.Rule{
+			Name: "day", Limit: ipRequestsPerDay, Window: 24 * time.Hour, Burst: ipDayBurst,
+		})
+	}
+
+	rateLimitAlgorithm := firstString(chainConfig.RateLimitAlgorithm, config.RateLimitAlgorithm, limiter.SlidingWindow)
+	ipLimiter, err := limiter.New(rateLimitAlgorithm, store, rateLimitRules)
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %s", name, err)
+	}
+
+	methodCosts := make(map[string]int)
+	for method, cost := range config.MethodCosts {
+		methodCosts[method] = cost
+	}
+	for method, cost := range chainConfig.MethodCosts {
+		methodCosts[method] = cost
+	}
+
+	blockedMethods := make(map[string]bool)
+	for _, method := range config.BlockedMethods {
+		blockedMethods[method] = true
+	}
+	for _, method := range chainConfig.BlockedMethods {
+		blockedMethods[method] = true
+	}
+
+	logs := &logsPolicy{
+		maxAddresses:     firstInt(chainConfig.LogsMaxAddresses, config.LogsMaxAddresses),
+		maxTopics:        firstInt(chainConfig.LogsMaxTopics, config.LogsMaxTopics),
+		chunk:            chainConfig.LogsChunking || config.LogsChunking,
+		chunkConcurrency: firstInt(chainConfig.LogsChunkConcurrency, config.LogsChunkConcurrency, 4),
+	}
+	maxBlockRange := firstInt(chainConfig.LogsMaxBlockRange, config.LogsMaxBlockRange)
+	maxChunks := firstInt(chainConfig.LogsMaxChunks, config.LogsMaxChunks, 10)
+	if maxBlockRange < 0 || logs.maxAddresses < 0 || logs.maxTopics < 0 || logs.chunkConcurrency < 1 || maxChunks < 1 {
+		return nil, fmt.Errorf("chain %s: invalid log query limits", name)
+	}
+	logs.maxBlockRange = uint64(maxBlockRange)
+	logs.maxChunks = uint64(maxChunks)
+
+	broadcastConfigs := chainConfig.BroadcastUpstreams
+	if len(broadcastConfigs) == 0 {
+		broadcastConfigs = config.BroadcastUpstreams
+	}
+
+	broadcastUpstreams := make([]*upstream, len(broadcastConfigs))
+	for i, broadcastConfig := range broadcastConfigs {
+		u, err := newUpstream(broadcastConfig)
+		if err != nil {
+			return nil, fmt.Errorf("chain %s: broadcast %s", name, err)
+		}
+		broadcastUpstreams[i] = u
+	}
+
+	txPolicy, err := newTxPolicy(config, chainConfig)
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %s", name, err)
+	}
+
+	servesHead := chainConfig.ServeHead || config.ServeHead
+	emulateFilters := chainConfig.EmulateFilters || config.EmulateFilters
+	headMaxAge := time.Duration(config.HeadMaxAge)
+	if headMaxAge == 0 {
+		headMaxAge = 15 * time.Second
+	}
+
+	filterTimeout := time.Duration(chainConfig.FilterTimeout)
+	if filterTimeout == 0 {
+		filterTimeout = time.Duration(config.FilterTimeout)
+	}
+	if filterTimeout == 0 {
+		filterTimeout = defaultFilterTimeout
+	}
+
+	maxFiltersPerIP := firstInt(chainConfig.MaxFiltersPerIP, config.MaxFiltersPerIP, defaultMaxFiltersPerIP)
+	if maxFiltersPerIP < 1 {
+		return nil, fmt.Errorf("chain %s: invalid max filters per IP %v", name, maxFiltersPerIP)
+	}
+
+	hedgedMethodNames := chainConfig.HedgedMethods
+	if hedgedMethodNames == nil {
+		hedgedMethodNames = config.HedgedMethods
+	}
+
+	hedgedMethods, err := parseHedgedMethods(hedgedMethodNames)
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %s", name, err)
+	}
+
+	hedgePercentile := firstInt(chainConfig.HedgePercentile, config.HedgePercentile, 95)
+	if hedgePercentile < 1 || hedgePercentile > 100 {
+		return nil, fmt.Errorf("chain %s: invalid hedge percentile %v", name, hedgePercentile)
+	}
+
+	hedgeDelay := time.Duration(chainConfig.HedgeDelay)
+	if hedgeDelay == 0 {
+		hedgeDelay = time.Duration(config.HedgeDelay)
+	}
+	if hedgeDelay == 0 {
+		hedgeDelay = 250 * time.Millisecond
+	}
+
+	cachedMethods := make(map[string]time.Duration)
+	for method, ttl := range config.CachedMethods {
+		cachedMethods[method] = time.Duration(ttl)
+	}
+	for method, ttl := range chainConfig.CachedMethods {
+		cachedMethods[method] = time.Duration(ttl)
+	}
+
+	shadow, err := newShadowPolicy(config, chainConfig)
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %s", name, err)
+	}
+
+	quorum, err := newQuorumPolicy(config, chainConfig, len(upstreams))
+	if err != nil {
+		return nil, fmt.Errorf("chain %s: %s", name, err)
+	}
+
+	return &chain{
+		name:                       name,
+		path:                       path,
+		chainID:                    chainConfig.ChainID,
+		upstreams:                  upstreams,
+		balancer:                   balancer,
+		hasArchive:                 hasArchive,
+		archiveBlocks:              uint64(archiveBlocks),
+		tracksHead:                 hasArchive || config.TrackHeads || config.PinLatest || servesHead || emulateFilters,
+		pinsLatest:                 config.PinLatest,
+		servesHead:                 servesHead,
+		headMaxAge:                 headMaxAge,
+		proxyMethod:                method,
+		authorizationSecret:        firstString(chainConfig.AuthorizationSecret, config.AuthorizationSecret),
+		softCapIPRequestsPerMinute: softCapIPRequestsPerMinute,
+		hardCapIPRequestsPerMinute: hardCapIPRequestsPerMinute,
+		limiter:                    ipLimiter,
+		rateLimitAlgorithm:         rateLimitAlgorithm,
+		methodCosts:                methodCosts,
+		defaultMethodCost:          firstInt(chainConfig.DefaultMethodCost, config.DefaultMethodCost, 1),
+		maxBatchSize:               firstInt(chainConfig.MaxBatchSize, config.MaxBatchSize),
+		batchChunkSize:             firstInt(chainConfig.BatchChunkSize, config.BatchChunkSize),
+		blockedMethods:             blockedMethods,
+		logs:                       logs,
+		broadcast:                  chainConfig.Broadcast || config.Broadcast,
+		broadcastUpstreams:         broadcastUpstreams,
+		txPolicy:                   txPolicy,
+		emulateFilters:             emulateFilters,
+		filterTimeout:              filterTimeout,
+		maxFiltersPerIP:            maxFiltersPerIP,
+		filterOwners:               newFilterOwners(),
+		hedgedMethods:              hedgedMethods,
+		hedgePercentile:            hedgePercentile,
+		hedgeDelay:                 hedgeDelay,
+		cachedMethods:              cachedMethods,
+		shadow:                     shadow,
+		quorum:                     quorum,
+	}, nil
+}
+
+// newChains builds the configured chains, or a single chain serving every
+// path from the top level config if none are configured.
+func newChains(config *Config, store cache.Store) ([]*chain, error) {
+	chainConfigs := config.Chains
+	if len(chainConfigs) == 0 {
+		if config.ProxyURL == "" && len(config.Upstreams) == 0 {
+			return nil, fmt.Errorf("a proxy URL, upstreams or chains are required")
+		}
+
+		chainConfigs = []*ChainConfig{
+			{
+				Name:      "default",
+				Path:      "/",
+				ChainID:   config.ChainID,
+				ProxyURL:  config.ProxyURL,
+				Upstreams: config.Upstreams,
+			},
+		}
+	}
+
+	chains := make([]*chain, 0, len(chainConfigs))
+	names := make(map[string]bool, len(chainConfigs))
+	paths := make(map[string]bool, len(chainConfigs))
+	for _, chainConfig := range chainConfigs {
+		c, err := newChain(config, chainConfig, store)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[c.name] || paths[c.path] {
+			return nil, fmt.Errorf("chain %s has a duplicate name or path %s", c.name, c.path)
+		}
+		names[c.name] = true
+		paths[c.path] = true
+
+		chains = append(chains, c)
+	}
+
+	// match the most specific path first
+	sort.SliceStable(chains, func(i, j int) bool {
+		return len(chains[i].path) > len(chains[j].path)
+	})
+
+	return chains, nil
+}
+
+// matchChain returns the chain serving the request path
+func (p *Proxy) matchChain(path string) *chain {
+	for _, c := range p.chains {
+		if c.path == "/" || path == c.path || strings.HasPrefix(path, c.path+"/") {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// rateLimitKey scopes rate limits to the chain
+func (c *chain) rateLimitKey(ipAddress string) string {
+	return fmt.Sprintf("%s:%s", c.name, ipAddress)
+}
+
+// hostnames returns the upstream hostnames for logs and notifications
+func (c *chain) hostnames() string {
+	hostnames := make([]string, len(c.upstreams))
+	for i, u := range c.upstreams {
+		hostnames[i] = u.url.Hostname()
+	}
+
+	return strings.Join(hostnames, ",")
+}
+
+func firstString(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+
+	return ""
+}
+
+func firstInt(values ...int) int {
+	for _, value := range values {
+		if value != 0 {
+			return value
+		}
+	}
+
+	return 0
+}
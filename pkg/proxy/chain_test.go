@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/limiter"
+)
+
+func TestRateLimitBursts(t *testing.T) {
+	config := &Config{
+		ProxyURL:                   "http://localhost:8545",
+		RateLimitAlgorithm:         limiter.TokenBucket,
+		IPRequestsPerSecond:        10,
+		IPBurst:                    2,
+		HardCapIPRequestsPerMinute: 600,
+		IPRequestsPerDay:           10000,
+	}
+
+	chains, err := newChains(config, cache.NewMemoryStore(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := chains[0]
+
+	var result *limiter.Result
+	for i := 0; i < 2; i++ {
+		if result = c.limiter.Allow("1.2.3.4", 1); !result.Allowed {
+			t.Fatal("expected the burst allowed")
+		}
+	}
+
+	// the longer limits hold their whole limit, not the per second burst
+	if usage, _ := result.Usage("min"); usage.Remaining != 598 {
+		t.Fatalf("expected 598 remaining per minute, got %+v", usage)
+	}
+	if usage, _ := result.Usage("day"); usage.Remaining != 9998 {
+		t.Fatalf("expected 9998 remaining per day, got %+v", usage)
+	}
+	result = c.limiter.Allow("1.2.3.4", 1)
+	if result.Allowed {
+		t.Fatal("expected the per second limit to reject past its burst")
+	}
+	if result.RetryAfter > time.Second {
+		t.Fatalf("expected to wait for the per second bucket only, got %s", result.RetryAfter)
+	}
+}
+
+func TestMatchChain(t *testing.T) {
+	upstreams := []*UpstreamConfig{{Name: "node", URL: "http://localhost:8545"}}
+
+	withChains := func(chains ...*ChainConfig) *Config {
+		return &Config{Chains: chains, LeakyBucketLimitPerSecond: 100}
+	}
+	mainnet := &ChainConfig{Name: "mainnet", Path: "/mainnet", Upstreams: upstreams}
+	mainnetArchive := &ChainConfig{Name: "mainnet-archive", Path: "mainnet/archive/", Upstreams: upstreams}
+	goerli := &ChainConfig{Name: "goerli", Path: "/goerli", Upstreams: upstreams}
+	fallback := &ChainConfig{Name: "fallback", Path: "/", Upstreams: upstreams}
+
+	tests := []struct {
+		config *Config
+		path   string
+		// chain is the name of the matched chain, or empty for a 404
+		chain string
+	}{
+		{withChains(mainnet, goerli), "/mainnet", "mainnet"},
+		{withChains(mainnet, goerli), "/mainnet/", "mainnet"},
+		{withChains(mainnet, goerli), "/mainnet/apikey", "mainnet"},
+		{withChains(mainnet, goerli), "/goerli", "goerli"},
+		{withChains(mainnet, goerli), "/mainnetx", ""},
+		{withChains(mainnet, goerli), "/", ""},
+		{withChains(mainnet, goerli), "/sepolia", ""},
+		// the most specific path wins whatever the configured order
+		{withChains(mainnet, mainnetArchive), "/mainnet/archive", "mainnet-archive"},
+		{withChains(mainnet, mainnetArchive), "/mainnet/archived", "mainnet"},
+		// a chain on / serves every path no other chain does
+		{withChains(fallback, mainnet), "/mainnet", "mainnet"},
+		{withChains(fallback, mainnet), "/sepolia", "fallback"},
+		// without chains the top level config serves every path
+		{&Config{Upstreams: upstreams, LeakyBucketLimitPerSecond: 100}, "/", "default"},
+		{&Config{Upstreams: upstreams, LeakyBucketLimitPerSecond: 100}, "/anything", "default"},
+	}
+
+	for _, test := range tests {
+		p, err := New(test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c := p.matchChain(test.path)
+		if test.chain == "" {
+			if c != nil {
+				t.Errorf("%s: expected no chain, got %s", test.path, c.name)
+				continue
+			}
+
+			w := httptest.NewRecorder()
+			p.ProxyHandler(w, httptest.NewRequest("POST", test.path, strings.NewReader(`{}`)))
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s: expected 404, got %v", test.path, w.Code)
+			}
+			continue
+		}
+
+		if c == nil {
+			t.Errorf("%s: expected chain %s, got none", test.path, test.chain)
+		} else if c.name != test.chain {
+			t.Errorf("%s: expected chain %s, got %s", test.path, test.chain, c.name)
+		}
+	}
+}
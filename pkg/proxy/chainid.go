@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verifyChainIDs checks that every upstream of chains with an expected chain
+// id is serving that chain. Upstreams on the wrong chain are ejected until
+// they report the expected chain id again. It returns an error describing
+// the mismatched upstreams, if any.
+func (p *Proxy) verifyChainIDs() error {
+	var mismatches []string
+	for _, c := range p.chains {
+		if c.chainID == 0 {
+			continue
+		}
+
+		for _, u := range c.upstreams {
+			chainID, err := p.fetchChainID(c, u)
+			if err != nil {
+				// an unreachable upstream isn't proof of a misconfiguration
+				fmt.Printf("CHAIN ID ERROR: %s CHAIN=%s UPSTREAM=%s\n", err, c.name, u.name)
+				continue
+			}
+
+			if chainID != c.chainID {
+				mismatch := fmt.Sprintf("upstream %s of chain %s has chain id %v, expected %v", u.name, c.name, chainID, c.chainID)
+				mismatches = append(mismatches, mismatch)
+
+				if u.setEjected(true) {
+					notification := fmt.Sprintf("🔌 Upstream ejected: chain id %v, expected %v CHAIN=%s UPSTREAM=%s PROXY=%s\n", chainID, c.chainID, c.name, u.name, u.url.Hostname())
+					fmt.Printf(notification)
+					p.sendNotification(notification)
+				}
+				continue
+			}
+
+			if u.setEjected(false) {
+				notification := fmt.Sprintf("✅ Upstream restored: chain id %v CHAIN=%s UPSTREAM=%s PROXY=%s\n", chainID, c.name, u.name, u.url.Hostname())
+				fmt.Printf(notification)
+				p.sendNotification(notification)
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("chain id mismatch: %s", strings.Join(mismatches, "; "))
+	}
+
+	return nil
+}
+
+// chainIDLoop verifies chain ids periodically
+func (p *Proxy) chainIDLoop() {
+	ticker := time.NewTicker(p.chainIDCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.verifyChainIDs()
+	}
+}
+
+// fetchChainID returns the upstream's chain id from eth_chainId, falling back
+// to net_version for nodes that don't support it.
+func (p *Proxy) fetchChainID(c *chain, u *upstream) (uint64, error) {
+	result, err := p.call(c, u, "eth_chainId")
+	if err == nil {
+		return parseQuantity(result)
+	}
+
+	result, netVersionErr := p.call(c, u, "net_version")
+	if netVersionErr != nil {
+		return 0, err
+	}
+
+	return parseQuantity(result)
+}
+
+// parseQuantity parses a JSON string holding a hex quantity such as "0x1" or a
+// decimal such as "1".
+func parseQuantity(result json.RawMessage) (uint64, error) {
+	var s string
+	if err := json.Unmarshal(result, &s); err != nil {
+		return 0, fmt.Errorf("invalid quantity %s", result)
+	}
+
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return strconv.ParseUint(s[2:], 16, 64)
+	}
+
+	return strconv.ParseUint(s, 10, 64)
+}
@@ -0,0 +1,85 @@
+package proxy_test
+
+import (
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+const address = "0x407d73d8a49eeb85d32cf465507dd71d507100c1"
+
+func TestChainIDMismatchAtStart(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+	server.SetChainID(5)
+
+	config := proxytest.Config(server)
+	config.ChainID = 1
+
+	if _, err := proxytest.NewProxy(config); err == nil {
+		t.Fatal("expected an upstream on the wrong chain to be refused")
+	}
+}
+
+func TestChainIDEjectsAndReadmits(t *testing.T) {
+	first := proxytest.NewServer()
+	defer first.Close()
+	second := proxytest.NewServer()
+	defer second.Close()
+
+	config := proxytest.Config(first, second)
+	config.ChainID = 1
+	config.LoadBalancing = "round-robin"
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	second.SetChainID(5)
+	if err := p.VerifyChainIDs(); err == nil {
+		t.Fatal("expected a chain id mismatch")
+	}
+
+	for i := 0; i < 10; i++ {
+		expectResult(t, p.URL, `"0x0"`, "eth_getBalance", address, "latest")
+	}
+	if first.Count("eth_getBalance") != 10 || second.Count("eth_getBalance") != 0 {
+		t.Fatalf("expected the ejected upstream skipped, got %v and %v requests", first.Count("eth_getBalance"), second.Count("eth_getBalance"))
+	}
+
+	second.SetChainID(1)
+	if err := p.VerifyChainIDs(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		expectResult(t, p.URL, `"0x0"`, "eth_getBalance", address, "latest")
+	}
+	if second.Count("eth_getBalance") != 5 {
+		t.Fatalf("expected the readmitted upstream used again, got %v requests", second.Count("eth_getBalance"))
+	}
+}
+
+func TestChainIDMalformedReply(t *testing.T) {
+	for _, reply := range []string{`[]`, `{"jsonrpc":"2.0","id":2,"result":"0x5"}`} {
+		server := replyServer(reply)
+
+		config := &proxy.Config{
+			Upstreams:   []*proxy.UpstreamConfig{{Name: "raw", URL: server.URL}},
+			ProxyMethod: "POST",
+			ChainID:     1,
+		}
+
+		// an upstream without a valid reply isn't proof of the wrong chain
+		p, err := proxytest.NewProxy(config)
+		if err != nil {
+			server.Close()
+			t.Fatalf("%s: %v", reply, err)
+		}
+		p.Close()
+		server.Close()
+	}
+}
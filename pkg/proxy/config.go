@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Duration is a time.Duration written in config files as a string such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON ...
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s: expected a string such as \"1m\"", data)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON ...
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads a JSON config file
+func LoadConfig(path string) (*Config, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(Config)
+	if err := json.Unmarshal(buf, config); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %s", path, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// requestCost returns the compute units charged against the per IP caps for
+// the requests. Bodies that aren't JSON-RPC are charged the default cost.
+func (c *chain) requestCost(reqs []*jsonrpc.Request) int {
+	if len(reqs) == 0 {
+		return c.defaultMethodCost
+	}
+
+	cost := 0
+	for _, req := range reqs {
+		if methodCost, ok := c.methodCosts[req.Method]; ok {
+			cost += methodCost
+			continue
+		}
+		cost += c.defaultMethodCost
+	}
+
+	return cost
+}
+
+// chargeExtra charges the IP for extra upstream requests sent for the
+// request, which was only charged once, and returns a rate limit error if
+// they're over its limits
+func (p *Proxy) chargeExtra(s *session, req *jsonrpc.Request, requests int, reason string) *jsonrpc.Response {
+	c := s.chain
+	if _, ok := p.alwaysAllowedIps[s.ipAddress]; ok || requests <= 0 {
+		return nil
+	}
+
+	cost := c.requestCost([]*jsonrpc.Request{req}) * requests
+	if result := c.limiter.Allow(c.rateLimitKey(s.ipAddress), cost); !result.Allowed {
+		p.rateLimitedCounter.Counter(c.name).Inc()
+		fmt.Printf("ERROR ID=%v: Rate limit exceeded by %s IP=%s CHAIN=%s COST=%v\n", s.id, reason, s.ipAddress, c.name, cost)
+		return rateLimitErrorResponse(req.ID, result.RetryAfter)
+	}
+
+	return nil
+}
+
+// ParseMethodCosts parses a comma separated list of method=cost pairs,
+// e.g. "eth_getLogs=75,eth_call=26"
+func ParseMethodCosts(s string) (map[string]int, error) {
+	costs := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid method cost %q", pair)
+		}
+
+		cost, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || cost < 0 {
+			return nil, fmt.Errorf("invalid method cost %q", pair)
+		}
+
+		costs[strings.TrimSpace(parts[0])] = cost
+	}
+
+	return costs, nil
+}
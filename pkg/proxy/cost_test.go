@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestRequestCost(t *testing.T) {
+	c := &chain{
+		methodCosts:       map[string]int{"eth_getLogs": 75, "eth_call": 26, "eth_chainId": 0},
+		defaultMethodCost: 2,
+	}
+
+	tests := []struct {
+		methods []string
+		cost    int
+	}{
+		// bodies that aren't JSON-RPC
+		{nil, 2},
+		{[]string{"eth_blockNumber"}, 2},
+		{[]string{"eth_getLogs"}, 75},
+		{[]string{"eth_chainId"}, 0},
+		{[]string{"eth_getLogs", "eth_call", "eth_blockNumber", "eth_chainId"}, 103},
+	}
+
+	for _, test := range tests {
+		var reqs []*jsonrpc.Request
+		for _, method := range test.methods {
+			reqs = append(reqs, &jsonrpc.Request{Method: method})
+		}
+
+		if cost := c.requestCost(reqs); cost != test.cost {
+			t.Errorf("%v: expected cost %v, got %v", test.methods, test.cost, cost)
+		}
+	}
+}
+
+func TestChargeExtra(t *testing.T) {
+	p, err := New(&Config{
+		Upstreams:                  []*UpstreamConfig{{Name: "node", URL: "http://127.0.0.1:8545"}},
+		LeakyBucketLimitPerSecond:  100,
+		SoftCapIPRequestsPerMinute: 100,
+		HardCapIPRequestsPerMinute: 10,
+		MethodCosts:                map[string]int{"eth_getLogs": 3},
+		AlwaysAllowedIps:           []string{"10.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, _ := http.NewRequest("POST", "/", nil)
+	s := &session{id: 1, ipAddress: "1.2.3.4", chain: p.chains[0], r: r}
+	req := &jsonrpc.Request{ID: json.RawMessage("7"), Method: "eth_getLogs"}
+
+	if resp := p.chargeExtra(s, req, 0, "test"); resp != nil {
+		t.Fatalf("expected nothing charged, got %+v", resp.Error)
+	}
+
+	// 3 extra requests cost 9 of the 10 per minute
+	if resp := p.chargeExtra(s, req, 3, "test"); resp != nil {
+		t.Fatalf("expected the requests allowed, got %+v", resp.Error)
+	}
+
+	resp := p.chargeExtra(s, req, 1, "test")
+	if resp == nil || resp.Error.Code != jsonrpc.CodeLimitExceeded || string(resp.ID) != "7" {
+		t.Fatalf("expected the rate limit, got %+v", resp)
+	}
+
+	allowed := &session{id: 2, ipAddress: "10.0.0.1", chain: p.chains[0], r: r}
+	if resp := p.chargeExtra(allowed, req, 100, "test"); resp != nil {
+		t.Fatalf("expected always allowed IPs not charged, got %+v", resp.Error)
+	}
+}
+
+func TestParseMethodCosts(t *testing.T) {
+	costs, err := ParseMethodCosts(" eth_getLogs=75, eth_call = 26,,eth_chainId=0 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{"eth_getLogs": 75, "eth_call": 26, "eth_chainId": 0}
+	if !reflect.DeepEqual(costs, expected) {
+		t.Fatalf("expected %v, got %v", expected, costs)
+	}
+
+	for _, s := range []string{"eth_getLogs", "eth_getLogs=", "eth_getLogs=x", "eth_getLogs=-1", "eth_getLogs=1.5"} {
+		if _, err := ParseMethodCosts(s); err == nil {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
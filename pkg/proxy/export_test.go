@@ -0,0 +1,11 @@
+package proxy
+
+// UpdateHeads fetches the upstreams' head blocks, as the head loop does
+func (p *Proxy) UpdateHeads() {
+	p.updateHeads()
+}
+
+// VerifyChainIDs checks the upstreams' chain ids, as the chain id loop does
+func (p *Proxy) VerifyChainIDs() error {
+	return p.verifyChainIDs()
+}
@@ -0,0 +1,401 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// maxFilterBlocks is the most blocks a block filter returns per poll
+const maxFilterBlocks = 1000
+
+// defaultFilterTimeout is how long an unpolled filter lives, as in geth
+const defaultFilterTimeout = 5 * time.Minute
+
+// defaultMaxFiltersPerIP is how many live filters an IP may have
+const defaultMaxFiltersPerIP = 100
+
+// Filter types
+const (
+	logFilterType   = "logs"
+	blockFilterType = "blocks"
+)
+
+// filter is a log or block filter owned by the proxy, so that polls work
+// whichever upstream serves them. Reorgs aren't reported.
+type filter struct {
+	mu         sync.Mutex
+	filterType string
+	// criteria is the eth_newFilter filter object
+	criteria map[string]json.RawMessage
+	// lastBlock is the last block the filter returned changes for
+	lastBlock uint64
+	// owner is the IP address that created the filter
+	owner string
+}
+
+// filterOwners tracks the live filters of each IP address, so that one
+// client can't crowd out the filters and cached responses of others
+type filterOwners struct {
+	mu sync.Mutex
+	// expirations are the expiration of each filter by owner and id
+	expirations map[string]map[string]time.Time
+	swept       time.Time
+}
+
+func newFilterOwners() *filterOwners {
+	return &filterOwners{
+		expirations: make(map[string]map[string]time.Time),
+		swept:       time.Now(),
+	}
+}
+
+// add records the filter unless the owner already has max live filters
+func (o *filterOwners) add(owner, id string, max int, expiration time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(o.swept) > defaultFilterTimeout {
+		for owner := range o.expirations {
+			o.prune(owner, now)
+		}
+		o.swept = now
+	}
+
+	o.prune(owner, now)
+	if len(o.expirations[owner]) >= max {
+		return false
+	}
+
+	if o.expirations[owner] == nil {
+		o.expirations[owner] = make(map[string]time.Time)
+	}
+	o.expirations[owner][id] = expiration
+
+	return true
+}
+
+// touch extends the filter's expiration
+func (o *filterOwners) touch(owner, id string, expiration time.Time) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if ids, ok := o.expirations[owner]; ok {
+		ids[id] = expiration
+	}
+}
+
+func (o *filterOwners) remove(owner, id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.expirations[owner], id)
+	if len(o.expirations[owner]) == 0 {
+		delete(o.expirations, owner)
+	}
+}
+
+func (o *filterOwners) prune(owner string, now time.Time) {
+	for id, expiration := range o.expirations[owner] {
+		if now.After(expiration) {
+			delete(o.expirations[owner], id)
+		}
+	}
+	if len(o.expirations[owner]) == 0 {
+		delete(o.expirations, owner)
+	}
+}
+
+// filterResponse answers the filter methods for chains that emulate filters,
+// or returns nil for other requests.
+func (p *Proxy) filterResponse(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	if !s.chain.emulateFilters {
+		return nil
+	}
+
+	switch req.Method {
+	case "eth_newFilter":
+		return p.newFilter(s, req, logFilterType)
+	case "eth_newBlockFilter":
+		return p.newFilter(s, req, blockFilterType)
+	case "eth_newPendingTransactionFilter":
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeMethodNotFound, "Pending transaction filters are not supported", nil)
+	case "eth_getFilterChanges":
+		return p.filterChanges(s, req)
+	case "eth_getFilterLogs":
+		return p.filterLogs(s, req)
+	case "eth_uninstallFilter":
+		id, ok := filterID(req)
+		if !ok {
+			return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, "Invalid filter id", nil)
+		}
+
+		key := filterKey(s.chain, id)
+		value, _, found := p.cache.Get(key)
+		if found {
+			s.chain.filterOwners.remove(value.(*filter).owner, id)
+		}
+		p.cache.Delete(key)
+		return newResult(req.ID, found)
+	}
+
+	return nil
+}
+
+func (p *Proxy) newFilter(s *session, req *jsonrpc.Request, filterType string) *jsonrpc.Response {
+	f := &filter{filterType: filterType, owner: s.ipAddress}
+	if filterType == logFilterType {
+		logFilter, err := parseLogFilter(req)
+		if err != nil {
+			return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, err.Error(), nil)
+		}
+		f.criteria = logFilter.fields
+	}
+
+	head, err := p.filterHead(s.chain)
+	if err != nil {
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, s.chain.name)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, "Unable to get head block", nil)
+	}
+	f.lastBlock = head
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+	id := "0x" + hex.EncodeToString(idBytes)
+
+	if !s.chain.filterOwners.add(s.ipAddress, id, s.chain.maxFiltersPerIP, time.Now().Add(s.chain.filterTimeout)) {
+		msg := fmt.Sprintf("Too many filters, maximum is %v per client", s.chain.maxFiltersPerIP)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeLimitExceeded, msg, nil)
+	}
+
+	p.cache.Set(filterKey(s.chain, id), f, s.chain.filterTimeout)
+	return newResult(req.ID, id)
+}
+
+// lookupFilter returns the request's filter, extending its expiry
+func (p *Proxy) lookupFilter(s *session, req *jsonrpc.Request) (*filter, *jsonrpc.Response) {
+	id, ok := filterID(req)
+	if !ok {
+		return nil, jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, "Invalid filter id", nil)
+	}
+
+	key := filterKey(s.chain, id)
+	value, _, found := p.cache.Get(key)
+	if !found {
+		return nil, jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeResourceNotFound, "filter not found", nil)
+	}
+
+	f := value.(*filter)
+	p.cache.Set(key, f, s.chain.filterTimeout)
+	s.chain.filterOwners.touch(f.owner, id, time.Now().Add(s.chain.filterTimeout))
+	return f, nil
+}
+
+// filterHead returns the highest block every upstream has, so the logs up
+// to it are complete whichever upstream answers the query
+func (p *Proxy) filterHead(c *chain) (uint64, error) {
+	if head := c.safeHead(); head > 0 {
+		return head, nil
+	}
+
+	return p.chainHead(c)
+}
+
+// filterChanges returns the logs or block hashes since the last poll
+func (p *Proxy) filterChanges(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	f, errResp := p.lookupFilter(s, req)
+	if errResp != nil {
+		return errResp
+	}
+
+	// nobody reads the answer to a notification, so polling with one would
+	// only lose the changes
+	if req.IsNotification() {
+		return notificationPollResponse(req)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	head, err := p.filterHead(s.chain)
+	if err != nil {
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, s.chain.name)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, "Unable to get head block", nil)
+	}
+
+	if head <= f.lastBlock {
+		return jsonrpc.NewResultResponse(req.ID, json.RawMessage("[]"))
+	}
+
+	if f.filterType == blockFilterType {
+		return p.blockFilterChanges(s, req, f, head)
+	}
+
+	from := f.lastBlock + 1
+	if ref, ok := parseBlockRef(f.criteria["fromBlock"]); ok && ref.isNumber && ref.number > from {
+		from = ref.number
+	}
+	to := head
+	if ref, ok := parseBlockRef(f.criteria["toBlock"]); ok && ref.isNumber && ref.number < to {
+		to = ref.number
+	}
+
+	if from > to {
+		f.lastBlock = head
+		return jsonrpc.NewResultResponse(req.ID, json.RawMessage("[]"))
+	}
+
+	resp := p.getLogs(s, req.ID, f.criteria, from, to)
+	if resp.Error == nil {
+		f.lastBlock = head
+	}
+
+	return resp
+}
+
+// blockFilterChanges returns the hashes of the blocks after the last poll
+func (p *Proxy) blockFilterChanges(s *session, req *jsonrpc.Request, f *filter, head uint64) *jsonrpc.Response {
+	from := f.lastBlock + 1
+	if head-from+1 > maxFilterBlocks {
+		from = head - maxFilterBlocks + 1
+	}
+
+	var blockReqs []*jsonrpc.Request
+	for n := from; n <= head; n++ {
+		blockReqs = append(blockReqs, &jsonrpc.Request{
+			JSONRPC: jsonrpc.Version,
+			ID:      json.RawMessage(fmt.Sprintf("%v", n)),
+			Method:  "eth_getBlockByNumber",
+			Params:  json.RawMessage(fmt.Sprintf(`[%s,false]`, quantity(n))),
+		})
+	}
+
+	// the poll was charged once, but each block fetched costs as much as a
+	// request for it
+	if resp := p.chargeExtra(s, blockReqs[0], len(blockReqs), "block filter"); resp != nil {
+		resp.ID = req.ID
+		return resp
+	}
+
+	chunkSize := len(blockReqs)
+	if s.chain.batchChunkSize > 0 {
+		chunkSize = s.chain.batchChunkSize
+	}
+
+	hashes := make([]string, 0, len(blockReqs))
+	for start := 0; start < len(blockReqs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(blockReqs) {
+			end = len(blockReqs)
+		}
+
+		for i, resp := range p.forwardBatch(s, blockReqs[start:end]) {
+			if resp.Error != nil {
+				// the hashes so far are reported, and the rest next poll
+				if len(hashes) > 0 {
+					return newResult(req.ID, hashes)
+				}
+				return jsonrpc.NewErrorResponse(req.ID, resp.Error.Code, resp.Error.Message, resp.Error.Data)
+			}
+
+			var block struct {
+				Hash string `json:"hash"`
+			}
+			if err := json.Unmarshal(resp.Result, &block); err != nil || block.Hash == "" {
+				// the upstream hasn't seen the block yet, so report it next poll
+				return newResult(req.ID, hashes)
+			}
+
+			hashes = append(hashes, block.Hash)
+			f.lastBlock = from + uint64(start+i)
+		}
+	}
+
+	return newResult(req.ID, hashes)
+}
+
+// filterLogs returns every log matching a log filter
+func (p *Proxy) filterLogs(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	f, errResp := p.lookupFilter(s, req)
+	if errResp != nil {
+		return errResp
+	}
+	if f.filterType != logFilterType {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, "filter is not a log filter", nil)
+	}
+
+	logsReq, err := logsRequest(req.ID, f.criteria)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+
+	return p.queryLogs(s, logsReq)
+}
+
+// getLogs queries the logs matching the criteria in the block range
+func (p *Proxy) getLogs(s *session, id json.RawMessage, criteria map[string]json.RawMessage, from, to uint64) *jsonrpc.Response {
+	fields := make(map[string]json.RawMessage, len(criteria))
+	for k, v := range criteria {
+		fields[k] = v
+	}
+	fields["fromBlock"] = quantity(from)
+	fields["toBlock"] = quantity(to)
+
+	req, err := logsRequest(id, fields)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(id, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+
+	return p.queryLogs(s, req)
+}
+
+// queryLogs sends an eth_getLogs request made by the proxy upstream,
+// applying the chain's log query policy
+func (p *Proxy) queryLogs(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	// upstreams don't answer notifications, so there'd be no response
+	if req.IsNotification() {
+		return notificationPollResponse(req)
+	}
+
+	if resp := p.logsResponse(s, req); resp != nil {
+		return resp
+	}
+
+	return p.forwardBatch(s, []*jsonrpc.Request{req})[0]
+}
+
+// notificationPollResponse is the response to polling a filter with a
+// notification, which the client never sees
+func notificationPollResponse(req *jsonrpc.Request) *jsonrpc.Response {
+	return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidRequest, "Filters can't be polled with notifications", nil)
+}
+
+func filterID(req *jsonrpc.Request) (string, bool) {
+	var params []string
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return "", false
+	}
+
+	return params[0], true
+}
+
+func filterKey(c *chain, id string) string {
+	return fmt.Sprintf("filter:%s:%s", c.name, id)
+}
+
+func newResult(id json.RawMessage, result interface{}) *jsonrpc.Response {
+	rawResult, err := json.Marshal(result)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(id, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+
+	return jsonrpc.NewResultResponse(id, rawResult)
+}
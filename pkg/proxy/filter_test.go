@@ -0,0 +1,220 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+// logsAt answers eth_getLogs with a log in the block, if the server has it
+func logsAt(s *proxytest.Server, block uint64) proxytest.Handler {
+	return func(params json.RawMessage) (interface{}, error) {
+		var filters []map[string]string
+		if err := json.Unmarshal(params, &filters); err != nil {
+			return nil, err
+		}
+
+		from, _ := strconv.ParseUint(strings.TrimPrefix(filters[0]["fromBlock"], "0x"), 16, 64)
+		to, _ := strconv.ParseUint(strings.TrimPrefix(filters[0]["toBlock"], "0x"), 16, 64)
+		logs := []map[string]string{}
+		if from <= block && block <= to && block <= s.BlockNumber() {
+			logs = append(logs, map[string]string{"blockNumber": "0x" + strconv.FormatUint(block, 16), "logIndex": "0x0"})
+		}
+
+		return logs, nil
+	}
+}
+
+func TestFilterChangesLaggingUpstream(t *testing.T) {
+	lagging := proxytest.NewServer()
+	defer lagging.Close()
+	ahead := proxytest.NewServer()
+	defer ahead.Close()
+
+	for _, s := range []*proxytest.Server{lagging, ahead} {
+		s.SetBlockNumber(10)
+		s.Handle("eth_getLogs", logsAt(s, 15))
+	}
+
+	config := proxytest.Config(ahead, lagging)
+	config.LoadBalancing = "round-robin"
+	config.EmulateFilters = true
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	resp, err := p.Call("eth_newFilter", map[string]string{})
+	if err != nil || resp.Error != nil {
+		t.Fatalf("got %+v %v", resp, err)
+	}
+	var id string
+	json.Unmarshal(resp.Result, &id)
+
+	// changes are only reported up to the block every upstream has, so the
+	// lagging upstream answering doesn't skip the log in block 15
+	lagging.SetBlockNumber(12)
+	ahead.SetBlockNumber(20)
+	p.UpdateHeads()
+	for i := 0; i < 2; i++ {
+		expectFilterChanges(t, p, id, `[]`)
+	}
+
+	lagging.SetBlockNumber(20)
+	p.UpdateHeads()
+	expectFilterChanges(t, p, id, `[{"blockNumber":"0xf","logIndex":"0x0"}]`)
+	expectFilterChanges(t, p, id, `[]`)
+}
+
+func TestMaxFiltersPerIP(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	config := proxytest.Config(s)
+	config.EmulateFilters = true
+	config.MaxFiltersPerIP = 2
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		resp, err := p.Call("eth_newBlockFilter")
+		if err != nil || resp.Error != nil {
+			t.Fatalf("got %+v %v", resp, err)
+		}
+		var id string
+		json.Unmarshal(resp.Result, &id)
+		ids = append(ids, id)
+	}
+
+	resp, err := p.Call("eth_newBlockFilter")
+	if err != nil || resp.Error == nil || resp.Error.Code != jsonrpc.CodeLimitExceeded {
+		t.Fatalf("expected the filter limit, got %+v %v", resp, err)
+	}
+
+	// uninstalling a filter makes room for another
+	if resp, err := p.Call("eth_uninstallFilter", ids[0]); err != nil || string(resp.Result) != "true" {
+		t.Fatalf("got %+v %v", resp, err)
+	}
+	if resp, err := p.Call("eth_newBlockFilter"); err != nil || resp.Error != nil {
+		t.Fatalf("got %+v %v", resp, err)
+	}
+}
+
+func TestBlockFilterChunked(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+	s.SetMaxBatchSize(2)
+
+	config := proxytest.Config(s)
+	config.EmulateFilters = true
+	config.BatchChunkSize = 2
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	id := newFilter(t, p, "eth_newBlockFilter")
+
+	// the new blocks are fetched in batches the upstream accepts
+	s.SetBlockNumber(5)
+	var hashes []string
+	for n := 1; n <= 5; n++ {
+		hashes = append(hashes, fmt.Sprintf(`"0x%064x"`, n+1))
+	}
+	expectFilterChanges(t, p, id, "["+strings.Join(hashes, ",")+"]")
+	expectFilterChanges(t, p, id, `[]`)
+}
+
+func TestBlockFilterCharged(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	config := proxytest.Config(s)
+	config.EmulateFilters = true
+	config.HardCapIPRequestsPerMinute = 5
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	id := newFilter(t, p, "eth_newBlockFilter")
+
+	// the poll and the ten blocks it fetches are over the cap
+	s.SetBlockNumber(10)
+	resp, err := p.Call("eth_getFilterChanges", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != jsonrpc.CodeLimitExceeded || string(resp.ID) != "1" {
+		t.Fatalf("expected the rate limit, got %s %+v", resp.Result, resp.Error)
+	}
+	if s.Count("eth_getBlockByNumber") != 0 {
+		t.Fatal("expected no blocks fetched over the cap")
+	}
+}
+
+func TestFilterPolledWithNotification(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+	s.Handle("eth_getLogs", logsAt(s, 3))
+
+	config := proxytest.Config(s)
+	config.EmulateFilters = true
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	id := newFilter(t, p, "eth_newFilter", map[string]string{})
+
+	// a poll nobody reads the answer to doesn't consume the changes
+	s.SetBlockNumber(5)
+	for _, method := range []string{"eth_getFilterChanges", "eth_getFilterLogs"} {
+		post(t, p.URL, fmt.Sprintf(`{"jsonrpc":"2.0","method":%q,"params":[%q]}`, method, id))
+	}
+	if s.Count("eth_getLogs") != 0 {
+		t.Fatal("expected no log queries for notifications")
+	}
+
+	expectFilterChanges(t, p, id, `[{"blockNumber":"0x3","logIndex":"0x0"}]`)
+}
+
+// newFilter creates a filter with the method and returns its id
+func newFilter(t *testing.T, p *proxytest.Proxy, method string, params ...interface{}) string {
+	resp, err := p.Call(method, params...)
+	if err != nil || resp.Error != nil {
+		t.Fatalf("got %+v %v", resp, err)
+	}
+
+	var id string
+	json.Unmarshal(resp.Result, &id)
+	return id
+}
+
+func expectFilterChanges(t *testing.T, p *proxytest.Proxy, id string, expected string) {
+	resp, err := p.Call("eth_getFilterChanges", id)
+	if err != nil || resp.Error != nil {
+		t.Fatalf("got %+v %v", resp, err)
+	}
+	if string(resp.Result) != expected {
+		t.Fatalf("expected %s, got %s", expected, resp.Result)
+	}
+}
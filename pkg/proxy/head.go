@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// headBlock is the latest block of a chain, kept to answer head requests
+// without going upstream
+type headBlock struct {
+	number uint64
+	// block is the eth_getBlockByNumber("latest", false) result
+	block     json.RawMessage
+	timestamp time.Time
+	// checkedAt is when the upstream reported this block as its head
+	checkedAt time.Time
+}
+
+// setHead records the latest block number reported by the upstream
+func (u *upstream) setHead(head uint64) {
+	atomic.StoreUint64(&u.head, head)
+}
+
+// latestBlock returns the latest block number reported by the upstream, or 0
+// if it's unknown
+func (u *upstream) latestBlock() uint64 {
+	return atomic.LoadUint64(&u.head)
+}
+
+// head returns the highest block number reported by the chain's available
+// upstreams, so an ejected upstream or one behind an open circuit can't hold
+// it up
+func (c *chain) head() uint64 {
+	var head uint64
+	for _, u := range c.upstreams {
+		if !u.available() {
+			continue
+		}
+		if block := u.latestBlock(); block > head {
+			head = block
+		}
+	}
+
+	return head
+}
+
+// tracksHeads returns true if any chain needs to know the head block
+func (p *Proxy) tracksHeads() bool {
+	for _, c := range p.chains {
+		if c.tracksHead {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updateHeads fetches the head block of every upstream of chains that track it
+func (p *Proxy) updateHeads() {
+	var wg sync.WaitGroup
+	for _, c := range p.chains {
+		if !c.tracksHead {
+			continue
+		}
+
+		for _, u := range c.upstreams {
+			// upstreams that can't be selected don't count towards the head
+			if !u.available() {
+				continue
+			}
+
+			wg.Add(1)
+			go func(c *chain, u *upstream) {
+				defer wg.Done()
+
+				head, err := p.fetchHead(c, u)
+				if err != nil {
+					fmt.Printf("HEAD ERROR: %s CHAIN=%s UPSTREAM=%s\n", err, c.name, u.name)
+					return
+				}
+
+				u.setHead(head)
+				p.headBlockGauge.Gauge(c.name, u.name).Set(float64(head))
+			}(c, u)
+		}
+	}
+
+	wg.Wait()
+}
+
+// fetchHead returns the upstream's head block number. Chains serving the
+// head locally fetch the whole block and keep the latest one.
+func (p *Proxy) fetchHead(c *chain, u *upstream) (uint64, error) {
+	if !c.servesHead {
+		result, err := p.call(c, u, "eth_blockNumber")
+		if err != nil {
+			return 0, err
+		}
+
+		return parseQuantity(result)
+	}
+
+	result, err := p.call(c, u, "eth_getBlockByNumber", "latest", false)
+	if err != nil {
+		return 0, err
+	}
+
+	var block struct {
+		Number    json.RawMessage `json:"number"`
+		Timestamp json.RawMessage `json:"timestamp"`
+	}
+	if err := json.Unmarshal(result, &block); err != nil || len(block.Number) == 0 {
+		return 0, fmt.Errorf("invalid block %s", result)
+	}
+
+	number, err := parseQuantity(block.Number)
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp, err := parseQuantity(block.Timestamp)
+	if err != nil {
+		return 0, err
+	}
+
+	u.observeHead(&headBlock{
+		number:    number,
+		block:     result,
+		timestamp: time.Unix(int64(timestamp), 0),
+		checkedAt: time.Now(),
+	})
+
+	return number, nil
+}
+
+// observeHead records the upstream's latest block
+func (u *upstream) observeHead(block *headBlock) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.latest = block
+}
+
+// latestHead returns the newest block of the chain's available upstreams, or
+// nil if it's unknown. Taking it from the available upstreams each time
+// means a block reported by an upstream that's since been ejected or gone
+// ahead on a fork isn't kept.
+func (c *chain) latestHead() *headBlock {
+	var latest *headBlock
+	for _, u := range c.upstreams {
+		if !u.available() {
+			continue
+		}
+
+		u.mu.Lock()
+		block := u.latest
+		u.mu.Unlock()
+
+		if block == nil {
+			continue
+		}
+		if latest == nil || block.number > latest.number || (block.number == latest.number && block.checkedAt.After(latest.checkedAt)) {
+			latest = block
+		}
+	}
+
+	return latest
+}
+
+// headResponse answers eth_blockNumber and eth_getBlockByNumber("latest",
+// false) from the latest block if it was confirmed recently enough.
+func (c *chain) headResponse(req *jsonrpc.Request) *jsonrpc.Response {
+	if !c.servesHead || (req.Method != "eth_blockNumber" && req.Method != "eth_getBlockByNumber") {
+		return nil
+	}
+
+	latest := c.latestHead()
+	if latest == nil || time.Since(latest.checkedAt) > c.headMaxAge {
+		return nil
+	}
+
+	if req.Method == "eth_blockNumber" {
+		return jsonrpc.NewResultResponse(req.ID, quantity(latest.number))
+	}
+
+	var params []json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) != 2 {
+		return nil
+	}
+
+	var tag string
+	var fullTransactions bool
+	if json.Unmarshal(params[0], &tag) != nil || json.Unmarshal(params[1], &fullTransactions) != nil {
+		return nil
+	}
+	if tag != "latest" || fullTransactions {
+		return nil
+	}
+
+	return jsonrpc.NewResultResponse(req.ID, latest.block)
+}
+
+// headLoop fetches head blocks periodically
+func (p *Proxy) headLoop() {
+	ticker := time.NewTicker(p.headPollInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.updateHeads()
+	}
+}
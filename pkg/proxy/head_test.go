@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestHeadResponse(t *testing.T) {
+	ahead := &upstream{name: "ahead"}
+	behind := &upstream{name: "behind"}
+	c := &chain{servesHead: true, headMaxAge: time.Minute, upstreams: []*upstream{ahead, behind}}
+
+	blockNumber := &jsonrpc.Request{ID: json.RawMessage("1"), Method: "eth_blockNumber"}
+	if c.headResponse(blockNumber) != nil {
+		t.Fatal("expected no response without a head")
+	}
+
+	ahead.observeHead(&headBlock{number: 16, block: json.RawMessage(`{"number":"0x10"}`), checkedAt: time.Now()})
+	behind.observeHead(&headBlock{number: 15, block: json.RawMessage(`{"number":"0xf"}`), checkedAt: time.Now()})
+
+	if resp := c.headResponse(blockNumber); resp == nil || string(resp.Result) != `"0x10"` {
+		t.Fatalf("expected head number, got %+v", resp)
+	}
+
+	latest := &jsonrpc.Request{ID: json.RawMessage("2"), Method: "eth_getBlockByNumber", Params: json.RawMessage(`["latest",false]`)}
+	if resp := c.headResponse(latest); resp == nil || string(resp.Result) != `{"number":"0x10"}` {
+		t.Fatalf("expected head block, got %+v", resp)
+	}
+
+	full := &jsonrpc.Request{ID: json.RawMessage("3"), Method: "eth_getBlockByNumber", Params: json.RawMessage(`["latest",true]`)}
+	if c.headResponse(full) != nil {
+		t.Fatal("expected full block to go upstream")
+	}
+
+	ahead.observeHead(&headBlock{number: 16, block: json.RawMessage(`{"number":"0x10"}`), checkedAt: time.Now().Add(-2 * time.Minute)})
+	if c.headResponse(blockNumber) != nil {
+		t.Fatal("expected stale head to go upstream")
+	}
+}
+
+func TestHeadFromAvailableUpstreams(t *testing.T) {
+	ejected := &upstream{name: "ejected"}
+	broken := &upstream{name: "broken", breaker: newBreaker(breakerConfig{window: 1, cooldown: time.Minute})}
+	healthy := &upstream{name: "healthy"}
+	c := &chain{servesHead: true, headMaxAge: time.Minute, upstreams: []*upstream{ejected, broken, healthy}}
+
+	for i, u := range c.upstreams {
+		number := uint64(30 - 10*i)
+		u.setHead(number)
+		u.observeHead(&headBlock{number: number, block: json.RawMessage(quantity(number)), checkedAt: time.Now()})
+	}
+	if c.head() != 30 || c.latestHead().number != 30 {
+		t.Fatalf("expected the highest head, got %v", c.head())
+	}
+
+	// the head goes back down once the upstreams ahead can't be selected
+	ejected.setEjected(true)
+	if c.head() != 20 || c.latestHead().number != 20 {
+		t.Fatalf("expected the ejected upstream skipped, got %v", c.head())
+	}
+
+	broken.breaker.open()
+	if c.head() != 10 || c.latestHead().number != 10 {
+		t.Fatalf("expected the open circuit skipped, got %v", c.head())
+	}
+
+	healthy.setEjected(true)
+	if c.head() != 0 || c.latestHead() != nil {
+		t.Fatalf("expected no head without available upstreams, got %v", c.head())
+	}
+}
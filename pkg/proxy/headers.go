@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/limiter"
+)
+
+// rateLimitErrorData is the data of the JSON-RPC error returned to rate limited clients
+type rateLimitErrorData struct {
+	RetryAfter int `json:"retryAfter"`
+}
+
+// setRateLimitHeaders sets the rate limit headers for the most constrained
+// rule, both as the IETF RateLimit fields and the common X-RateLimit ones.
+func setRateLimitHeaders(header http.Header, result *limiter.Result) {
+	usage, ok := result.Tightest()
+	if !ok {
+		return
+	}
+
+	reset := seconds(usage.Reset)
+	header.Set("RateLimit-Limit", strconv.Itoa(usage.Rule.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(usage.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(reset))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%v;w=%v", usage.Rule.Limit, seconds(usage.Rule.Window)))
+	header.Set("X-RateLimit-Limit", strconv.Itoa(usage.Rule.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(usage.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(usage.Reset).Unix(), 10))
+	header.Set("Access-Control-Expose-Headers", "RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,RateLimit-Policy,X-RateLimit-Limit,X-RateLimit-Remaining,X-RateLimit-Reset,Retry-After")
+
+	if !result.Allowed {
+		header.Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+	}
+}
+
+// writeRateLimitError responds with a JSON-RPC error for every request that
+// includes how long to wait before retrying. Notifications get no response,
+// only the status and headers.
+func writeRateLimitError(w http.ResponseWriter, reqs []*jsonrpc.Request, isBatch bool, retryAfter time.Duration) {
+	var v interface{}
+	if isBatch {
+		resps := make([]*jsonrpc.Response, 0, len(reqs))
+		for _, req := range reqs {
+			if req.IsNotification() {
+				continue
+			}
+			resps = append(resps, rateLimitErrorResponse(req.ID, retryAfter))
+		}
+		if len(resps) > 0 {
+			v = resps
+		}
+	} else if len(reqs) != 1 || !reqs[0].IsNotification() {
+		var id json.RawMessage
+		if len(reqs) == 1 {
+			id = reqs[0].ID
+		}
+		v = rateLimitErrorResponse(id, retryAfter)
+	}
+
+	if v == nil {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "", http.StatusTooManyRequests)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(body)
+}
+
+// rateLimitErrorResponse returns the error for a rate limited request
+func rateLimitErrorResponse(id json.RawMessage, retryAfter time.Duration) *jsonrpc.Response {
+	msg := fmt.Sprintf("Too many requests: Rate limit exceeded. Try again in %vs", seconds(retryAfter))
+	return jsonrpc.NewErrorResponse(id, jsonrpc.CodeLimitExceeded, msg, &rateLimitErrorData{RetryAfter: seconds(retryAfter)})
+}
+
+// seconds rounds the duration up to whole seconds
+func seconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+
+	return int(math.Ceil(d.Seconds()))
+}
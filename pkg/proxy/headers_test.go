@@ -0,0 +1,179 @@
+package proxy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestRateLimitErrorSingle(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+
+	config := proxytest.Config(server)
+	config.HardCapIPRequestsPerMinute = 1
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	resp, body := post(t, p.URL, `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("RateLimit-Limit") != "1" || resp.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("expected the first request allowed with rate limit headers, got %v %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, body = post(t, p.URL, `{"jsonrpc":"2.0","id":"b","method":"eth_chainId","params":[]}`)
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v", resp.StatusCode)
+	}
+	retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Fatalf("expected Retry-After within the minute, got %q", resp.Header.Get("Retry-After"))
+	}
+
+	var rpcResp struct {
+		ID    json.RawMessage `json:"id"`
+		Error struct {
+			Code int `json:"code"`
+			Data struct {
+				RetryAfter int `json:"retryAfter"`
+			} `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &rpcResp); err != nil {
+		t.Fatal(err)
+	}
+	if string(rpcResp.ID) != `"b"` || rpcResp.Error.Code != jsonrpc.CodeLimitExceeded || rpcResp.Error.Data.RetryAfter != retryAfter {
+		t.Fatalf("unexpected error response %s", body)
+	}
+
+	// notifications get the status and headers but no response
+	resp, body = post(t, p.URL, `{"jsonrpc":"2.0","method":"eth_chainId","params":[]}`)
+	if resp.StatusCode != http.StatusTooManyRequests || len(body) != 0 {
+		t.Fatalf("expected an empty 429, got %v %q", resp.StatusCode, body)
+	}
+}
+
+func TestRateLimitErrorBatch(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+
+	config := proxytest.Config(server)
+	config.HardCapIPRequestsPerMinute = 2
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	resp, body := post(t, p.URL, `[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_chainId","params":[]}
+	]`)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("expected 429 with Retry-After, got %v %v", resp.StatusCode, resp.Header)
+	}
+
+	resps, isBatch, err := jsonrpc.ParseResponses(body)
+	if err != nil || !isBatch || len(resps) != 2 {
+		t.Fatalf("expected a response for each request that isn't a notification, got %s", body)
+	}
+	for i, id := range []string{"1", "2"} {
+		if string(resps[i].ID) != id || resps[i].Error == nil || resps[i].Error.Code != jsonrpc.CodeLimitExceeded {
+			t.Fatalf("unexpected response %s", body)
+		}
+	}
+
+	if len(server.Requests()) != 0 {
+		t.Fatal("expected nothing forwarded")
+	}
+}
+
+func TestRateLimitBan(t *testing.T) {
+	server := proxytest.NewServer()
+	defer server.Close()
+
+	config := proxytest.Config(server)
+	config.HardCapIPRequestsPerMinute = 1
+	config.IPBanDuration = proxy.Duration(time.Hour)
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	request := `{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`
+	post(t, p.URL, request)
+
+	resp, _ := post(t, p.URL, request)
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "3600" {
+		t.Fatalf("expected a ban, got %v Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// banned clients are rejected before the rate limit is checked
+	resp, body := post(t, p.URL, request)
+	retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if resp.StatusCode != http.StatusTooManyRequests || retryAfter < 3590 || !bytes.Contains(body, []byte(`"code":-32005`)) {
+		t.Fatalf("expected the ban to hold, got %v %q %s", resp.StatusCode, resp.Header.Get("Retry-After"), body)
+	}
+	if server.Count("eth_chainId") != 1 {
+		t.Fatalf("expected only the first request forwarded, got %v", server.Count("eth_chainId"))
+	}
+}
+
+// post posts the body to the proxy and returns the response and its body
+func post(t *testing.T, url string, body string) (*http.Response, []byte) {
+	resp, err := http.Post(url, "application/json", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, respBody
+}
+
+// expectResult calls the method on the proxy and fails unless the result is
+// the expected one
+func expectResult(t *testing.T, url string, expected string, method string, params ...interface{}) {
+	resp, err := proxytest.Call(url, method, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil || string(resp.Result) != expected {
+		t.Fatalf("%s: expected %s, got %s %+v", method, expected, resp.Result, resp.Error)
+	}
+}
+
+// metricsText returns the proxy's metrics page
+func metricsText(t *testing.T, p *proxytest.Proxy) string {
+	resp, err := http.Get(p.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// latencySamples is the number of recent response times kept per upstream
+const latencySamples = 100
+
+// minHedgeSamples is the number of samples needed before the percentile is
+// used as the hedge delay instead of the configured fallback
+const minHedgeSamples = 20
+
+// parseHedgedMethods returns the set of methods that may be hedged. Only
+// read methods can be sent twice.
+func parseHedgedMethods(methods []string) (map[string]bool, error) {
+	hedgedMethods := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		if !readMethods[method] {
+			return nil, fmt.Errorf("method %s can't be hedged", method)
+		}
+		hedgedMethods[method] = true
+	}
+
+	return hedgedMethods, nil
+}
+
+// hedged returns true if every request may be sent to a second upstream
+func (c *chain) hedged(reqs []*jsonrpc.Request) bool {
+	if len(c.hedgedMethods) == 0 || len(reqs) == 0 {
+		return false
+	}
+
+	for _, req := range reqs {
+		if !c.hedgedMethods[req.Method] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hedgeDelayFor returns how long to wait on the upstream before hedging
+func (c *chain) hedgeDelayFor(u *upstream) time.Duration {
+	if delay, ok := u.latencyPercentile(c.hedgePercentile); ok {
+		return delay
+	}
+
+	return c.hedgeDelay
+}
+
+// hedge sends the body to the primary upstream, and to the secondary as
+// well if the primary hasn't answered within the hedge delay. The first
+// successful response is returned and the other request is cancelled.
+func (p *Proxy) hedge(s *session, primary, secondary *upstream, body []byte) (*proxyResponse, error) {
+	type result struct {
+		upstream *upstream
+		resp     *proxyResponse
+		err      error
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan *result, 2)
+	send := func(u *upstream) {
+		resp, err := p.forwardTo(ctx, s, u, body)
+		results <- &result{u, resp, err}
+	}
+
+	go send(primary)
+
+	timer := time.NewTimer(s.chain.hedgeDelayFor(primary))
+	defer timer.Stop()
+
+	pending := 1
+	hedged := false
+	sendSecondary := func() {
+		hedged = true
+		pending++
+		p.hedgedRequestsCounter.Counter(s.chain.name).Inc()
+		go send(secondary)
+	}
+
+	var errs []string
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if !hedged {
+				sendSecondary()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.upstream == secondary {
+					p.hedgeWinsCounter.Counter(s.chain.name).Inc()
+				}
+				return r.resp, nil
+			}
+
+			fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s UPSTREAM=%s\n", s.id, r.err, s.ipAddress, s.chain.name, r.upstream.name)
+			errs = append(errs, fmt.Sprintf("%s: %s", r.upstream.name, r.err))
+
+			// the primary failed outright, so don't wait for the delay
+			if !hedged {
+				sendSecondary()
+			}
+		}
+	}
+
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+// latencyPercentile returns the percentile of the recent response times
+func (u *upstream) latencyPercentile(percentile int) (time.Duration, bool) {
+	u.mu.Lock()
+	count := u.sampleCount
+	if count > latencySamples {
+		count = latencySamples
+	}
+	samples := make([]time.Duration, count)
+	copy(samples, u.samples[:count])
+	u.mu.Unlock()
+
+	if count < minHedgeSamples {
+		return 0, false
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i] < samples[j]
+	})
+
+	index := (count*percentile+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= count {
+		index = count - 1
+	}
+
+	return samples[index], true
+}
@@ -0,0 +1,75 @@
+package proxy_test
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestHedgesSlowUpstream(t *testing.T) {
+	slow := proxytest.NewServer()
+	defer slow.Close()
+	fast := proxytest.NewServer()
+	defer fast.Close()
+
+	slow.SetLatency("eth_getBalance", time.Second)
+	fast.SetBalance("0xabc", big.NewInt(1))
+
+	config := proxytest.Config(slow, fast)
+	config.HedgedMethods = []string{"eth_getBalance"}
+	config.HedgeDelay = proxy.Duration(10 * time.Millisecond)
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	start := time.Now()
+	expectResult(t, p.URL, `"0x1"`, "eth_getBalance", "0xabc", "latest")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected the hedged request to answer first, took %s", elapsed)
+	}
+}
+
+func TestHedgesFailedUpstream(t *testing.T) {
+	failing := proxytest.NewServer()
+	fast := proxytest.NewServer()
+	defer fast.Close()
+
+	fast.SetBalance("0xabc", big.NewInt(1))
+
+	config := proxytest.Config(failing, fast)
+	config.HedgedMethods = []string{"eth_getBalance"}
+	config.HedgeDelay = proxy.Duration(time.Minute)
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// the secondary is sent as soon as the primary fails, and counted
+	failing.Close()
+	expectResult(t, p.URL, `"0x1"`, "eth_getBalance", "0xabc", "latest")
+	if !strings.Contains(metricsText(t, p), `goproxy_hedged_requests_total{chain="default"} 1`) {
+		t.Fatal("expected the hedged request to be counted")
+	}
+}
+
+func TestHedgesOnlyReadMethods(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	for _, method := range []string{"eth_sendRawTransaction", "personal_sendTransaction", "eth_submitWork"} {
+		config := proxytest.Config(s)
+		config.HedgedMethods = []string{method}
+		if _, err := proxytest.NewProxy(config); err == nil {
+			t.Fatalf("expected %s to be refused", method)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/journal"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/tx"
+)
+
+// recordTransactions journals the eth_sendRawTransaction requests with the
+// upstream responses, matched by id
+func (p *Proxy) recordTransactions(s *session, reqs []*jsonrpc.Request, resps []*jsonrpc.Response, u *upstream) {
+	if p.journal == nil {
+		return
+	}
+
+	byID := make(map[string]*jsonrpc.Response, len(resps))
+	for _, resp := range resps {
+		if resp != nil {
+			byID[jsonrpc.IDKey(resp.ID)] = resp
+		}
+	}
+
+	for _, req := range reqs {
+		if req.Method != "eth_sendRawTransaction" || req.IsNotification() {
+			continue
+		}
+
+		if resp, ok := byID[jsonrpc.IDKey(req.ID)]; ok {
+			p.recordTransaction(s, req, resp, u)
+		}
+	}
+}
+
+// recordTransaction journals the transaction as pending if the upstream
+// accepted it, or rejected with its error
+func (p *Proxy) recordTransaction(s *session, req *jsonrpc.Request, resp *jsonrpc.Response, u *upstream) {
+	if p.journal == nil || req.Method != "eth_sendRawTransaction" {
+		return
+	}
+
+	raw, err := rawTx(req)
+	if err != nil {
+		return
+	}
+
+	transaction, err := tx.Decode(raw)
+	if err != nil {
+		if p.logLevel == "debug" {
+			fmt.Printf("JOURNAL ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, s.chain.name)
+		}
+		return
+	}
+
+	hash := "0x" + hex.EncodeToString(transaction.Hash)
+
+	// a resent transaction keeps its original record
+	if previous, ok := p.journal.Get(hash); ok && previous.Status != journal.Rejected {
+		return
+	}
+
+	now := time.Now()
+	entry := &journal.Entry{
+		Hash:      hash,
+		Chain:     s.chain.name,
+		From:      "0x" + hex.EncodeToString(transaction.From),
+		Nonce:     transaction.Nonce,
+		IP:        s.ipAddress,
+		APIKey:    apiKeyID(s.r),
+		Timestamp: now,
+		Status:    journal.Pending,
+		UpdatedAt: now,
+	}
+	if u != nil {
+		entry.Upstream = u.name
+	}
+	if resp.Error != nil && !isKnownTxError(resp.Error.Message) {
+		entry.Status = journal.Rejected
+		entry.Error = resp.Error.Message
+	}
+
+	if err := p.journal.Put(entry); err != nil {
+		fmt.Printf("JOURNAL ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, s.chain.name)
+		return
+	}
+
+	p.txStatusCounter.Counter(s.chain.name, string(entry.Status)).Inc()
+}
+
+// apiKeyID identifies the client's bearer token without storing it
+func apiKeyID(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	token, err := bearerToken(r)
+	if err != nil || token == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkTransactions updates the status of pending transactions from their
+// receipts
+func (p *Proxy) checkTransactions() {
+	for _, entry := range p.journal.Pending() {
+		c := p.chainNamed(entry.Chain)
+		if c == nil {
+			continue
+		}
+
+		updated, err := p.checkTransaction(c, entry)
+		if err != nil {
+			fmt.Printf("JOURNAL ERROR %s HASH=%s CHAIN=%s\n", err, entry.Hash, c.name)
+			continue
+		}
+		if !updated {
+			continue
+		}
+
+		entry.UpdatedAt = time.Now()
+		if err := p.journal.Put(entry); err != nil {
+			fmt.Printf("JOURNAL ERROR %s HASH=%s CHAIN=%s\n", err, entry.Hash, c.name)
+			continue
+		}
+
+		p.txStatusCounter.Counter(c.name, string(entry.Status)).Inc()
+		if p.logLevel == "debug" {
+			fmt.Printf("TX %s HASH=%s CHAIN=%s\n", entry.Status, entry.Hash, c.name)
+		}
+	}
+}
+
+// checkTransaction marks the transaction mined if it has a receipt, replaced
+// if another transaction used its nonce, or dropped if the upstream no
+// longer knows it after the drop timeout. It returns true if the status
+// changed.
+func (p *Proxy) checkTransaction(c *chain, entry *journal.Entry) (bool, error) {
+	upstreams := c.balancer.order(c.upstreams)
+	if len(upstreams) == 0 {
+		return false, fmt.Errorf("no upstreams available")
+	}
+	u := upstreams[0]
+
+	// the nonce is read before the receipt so a transaction mined in
+	// between isn't mistaken for a replaced one
+	result, err := p.call(c, u, "eth_getTransactionCount", entry.From, "latest")
+	if err != nil {
+		return false, err
+	}
+	nonce, err := parseQuantity(result)
+	if err != nil {
+		return false, err
+	}
+
+	result, err = p.call(c, u, "eth_getTransactionReceipt", entry.Hash)
+	if err != nil {
+		return false, err
+	}
+
+	var receipt *struct {
+		BlockNumber json.RawMessage `json:"blockNumber"`
+		Status      string          `json:"status"`
+	}
+	if err := json.Unmarshal(result, &receipt); err != nil {
+		return false, fmt.Errorf("invalid receipt: %s", err)
+	}
+
+	if receipt != nil {
+		blockNumber, err := parseQuantity(receipt.BlockNumber)
+		if err != nil {
+			return false, err
+		}
+
+		entry.Status = journal.Mined
+		entry.BlockNumber = blockNumber
+		entry.Reverted = receipt.Status == "0x0"
+		return true, nil
+	}
+
+	if nonce > entry.Nonce {
+		entry.Status = journal.Replaced
+		return true, nil
+	}
+
+	if time.Since(entry.Timestamp) < p.journalDropTimeout {
+		return false, nil
+	}
+
+	result, err = p.call(c, u, "eth_getTransactionByHash", entry.Hash)
+	if err != nil {
+		return false, err
+	}
+	if string(result) == "null" {
+		entry.Status = journal.Dropped
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// journalLoop checks pending transactions and prunes old ones periodically
+func (p *Proxy) journalLoop() {
+	ticker := time.NewTicker(p.journalCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.checkTransactions()
+
+		if p.journalRetention > 0 {
+			if _, err := p.journal.Prune(time.Now().Add(-p.journalRetention)); err != nil {
+				fmt.Printf("JOURNAL ERROR %s\n", err)
+			}
+		}
+	}
+}
+
+// TransactionsHandler returns the journaled transactions with the hash or
+// from the sender, e.g. /transactions?hash=0x... or /transactions?sender=0x...
+// It requires the admin secret.
+func (p *Proxy) TransactionsHandler(w http.ResponseWriter, r *http.Request) {
+	if p.journal == nil {
+		http.Error(w, "Transaction journal is disabled", http.StatusNotFound)
+		return
+	}
+
+	if p.adminSecret == "" || checkAuthorization(r, p.adminSecret) != nil {
+		http.Error(w, "", http.StatusUnauthorized)
+		return
+	}
+
+	entries := []*journal.Entry{}
+	query := r.URL.Query()
+	switch {
+	case query.Get("hash") != "":
+		if entry, ok := p.journal.Get(query.Get("hash")); ok {
+			entries = append(entries, entry)
+		}
+	case query.Get("sender") != "":
+		entries = append(entries, p.journal.BySender(query.Get("sender"))...)
+	default:
+		http.Error(w, "A hash or sender is required", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"transactions": entries,
+	})
+}
+
+// chainNamed returns the chain with the name
+func (p *Proxy) chainNamed(name string) *chain {
+	for _, c := range p.chains {
+		if c.name == name {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/journal"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/metrics"
+)
+
+func TestRecordTransactions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	txJournal, err := journal.Open(filepath.Join(dir, "transactions.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txJournal.Close()
+
+	registry := metrics.NewRegistry()
+	p := &Proxy{
+		journal:         txJournal,
+		txStatusCounter: registry.NewCounterVec("goproxy_tx_status_total", "", "chain", "status"),
+	}
+
+	r, _ := http.NewRequest("POST", "/", nil)
+	s := &session{id: 1, ipAddress: "1.2.3.4", chain: &chain{name: "mainnet"}, r: r}
+	u := &upstream{name: "infura"}
+	hash := "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788"
+	reqs := []*jsonrpc.Request{
+		{ID: json.RawMessage("1"), Method: "eth_sendRawTransaction", Params: json.RawMessage(`["0x` + eip155Tx + `"]`)},
+		{ID: json.RawMessage("2"), Method: "eth_blockNumber"},
+	}
+
+	rejected := []*jsonrpc.Response{jsonrpc.NewErrorResponse(json.RawMessage("1"), -32000, "nonce too low", nil)}
+	p.recordTransactions(s, reqs, rejected, u)
+	entry, ok := txJournal.Get(hash)
+	if !ok || entry.Status != journal.Rejected || entry.Error != "nonce too low" {
+		t.Fatalf("expected a rejected entry, got %+v", entry)
+	}
+
+	accepted := []*jsonrpc.Response{jsonrpc.NewResultResponse(json.RawMessage("1"), json.RawMessage(`"`+hash+`"`))}
+	p.recordTransactions(s, reqs, accepted, u)
+	entry, ok = txJournal.Get(hash)
+	if !ok || entry.Status != journal.Pending || entry.From != "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" || entry.Nonce != 9 || entry.IP != "1.2.3.4" || entry.Upstream != "infura" {
+		t.Fatalf("expected a pending entry, got %+v", entry)
+	}
+
+	// a resend that's rejected as already known doesn't change the record
+	known := []*jsonrpc.Response{jsonrpc.NewErrorResponse(json.RawMessage("1"), -32000, "already known", nil)}
+	p.recordTransactions(s, reqs, known, &upstream{name: "alchemy"})
+	if entry, _ := txJournal.Get(hash); entry.Upstream != "infura" {
+		t.Fatalf("expected the original record, got %+v", entry)
+	}
+}
@@ -0,0 +1,64 @@
+package proxy_test
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestServesJournalToAdmins(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	get := func(url string, secret string) int {
+		req, _ := http.NewRequest("GET", url+"/transactions?sender=0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f", nil)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+base64.StdEncoding.EncodeToString([]byte(secret)))
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	config := proxytest.Config(s)
+	config.JournalPath = filepath.Join(dir, "open.jsonl")
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if status := get(p.URL, ""); status == http.StatusOK {
+		t.Fatal("expected the journal not to be served without an admin secret")
+	}
+
+	config = proxytest.Config(s)
+	config.JournalPath = filepath.Join(dir, "admin.jsonl")
+	config.AdminSecret = "admin"
+	p, err = proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	if status := get(p.URL, ""); status != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized, got %v", status)
+	}
+	if status := get(p.URL, "admin"); status != http.StatusOK {
+		t.Fatalf("expected the journal, got %v", status)
+	}
+}
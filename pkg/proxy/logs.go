@@ -0,0 +1,355 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// logsPolicy limits the cost of eth_getLogs queries
+type logsPolicy struct {
+	maxBlockRange uint64
+	maxAddresses  int
+	maxTopics     int
+	// chunk splits queries over the block range instead of rejecting them
+	chunk            bool
+	chunkConcurrency int
+	// maxChunks is the most chunks a query is split into
+	maxChunks uint64
+}
+
+// enabled returns true if any limit is set
+func (l *logsPolicy) enabled() bool {
+	return l.maxBlockRange > 0 || l.maxAddresses > 0 || l.maxTopics > 0
+}
+
+// logFilter is the filter object of an eth_getLogs query
+type logFilter struct {
+	fields    map[string]json.RawMessage
+	addresses int
+	topics    int
+}
+
+func parseLogFilter(req *jsonrpc.Request) (*logFilter, error) {
+	var params []json.RawMessage
+	if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+		return nil, errors.New("missing filter")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(params[0], &fields); err != nil || fields == nil {
+		return nil, errors.New("invalid filter")
+	}
+
+	filter := &logFilter{fields: fields}
+
+	if address, ok := fields["address"]; ok && string(address) != "null" {
+		var addresses []string
+		if err := json.Unmarshal(address, &addresses); err == nil {
+			filter.addresses = len(addresses)
+		} else {
+			filter.addresses = 1
+		}
+	}
+
+	if topics, ok := fields["topics"]; ok {
+		var positions []json.RawMessage
+		if err := json.Unmarshal(topics, &positions); err != nil {
+			return nil, errors.New("invalid topics")
+		}
+
+		for _, position := range positions {
+			var alternatives []string
+			if err := json.Unmarshal(position, &alternatives); err == nil {
+				filter.topics += len(alternatives)
+			} else if string(position) != "null" {
+				filter.topics++
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+// logsResponse enforces the chain's eth_getLogs policy. It returns an error
+// response for queries over the limits, the merged result of chunked
+// queries, or nil if the query can be forwarded as is.
+func (p *Proxy) logsResponse(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	c := s.chain
+	if req.Method != "eth_getLogs" || !c.logs.enabled() {
+		return nil
+	}
+
+	filter, err := parseLogFilter(req)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, err.Error(), nil)
+	}
+
+	if c.logs.maxAddresses > 0 && filter.addresses > c.logs.maxAddresses {
+		msg := fmt.Sprintf("Log query has %v addresses, maximum is %v", filter.addresses, c.logs.maxAddresses)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeLimitExceeded, msg, nil)
+	}
+
+	if c.logs.maxTopics > 0 && filter.topics > c.logs.maxTopics {
+		msg := fmt.Sprintf("Log query has %v topics, maximum is %v", filter.topics, c.logs.maxTopics)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeLimitExceeded, msg, nil)
+	}
+
+	// a single block by hash has no range
+	if _, ok := filter.fields["blockHash"]; ok || c.logs.maxBlockRange == 0 {
+		return nil
+	}
+
+	from, to, err := p.logRange(s, filter)
+	if err != nil {
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, c.name)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, "Unable to resolve log query range", nil)
+	}
+
+	// an inverted range is for the upstream to reject
+	if to < from || to-from+1 <= c.logs.maxBlockRange {
+		return nil
+	}
+
+	if !c.logs.chunk {
+		return logRangeError(req, to-from+1, c.logs.maxBlockRange)
+	}
+
+	// chunks past the limit would fan a single query out without bound
+	if chunks := (to-from)/c.logs.maxBlockRange + 1; chunks > c.logs.maxChunks {
+		return logRangeError(req, to-from+1, c.logs.maxBlockRange*c.logs.maxChunks)
+	}
+
+	// nobody reads the answer to a notification, so it's answered here
+	// instead of being sent in chunks
+	if req.IsNotification() {
+		return logRangeError(req, to-from+1, c.logs.maxBlockRange)
+	}
+
+	return p.chunkLogs(s, req, filter, from, to)
+}
+
+// logRangeError returns the error for a query over the maximum block range
+func logRangeError(req *jsonrpc.Request, blocks, maxBlockRange uint64) *jsonrpc.Response {
+	msg := fmt.Sprintf("Log query spans %v blocks, maximum is %v", blocks, maxBlockRange)
+	return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeLimitExceeded, msg, map[string]uint64{
+		"maxBlockRange": maxBlockRange,
+	})
+}
+
+// logRange resolves the filter's block range to numbers
+func (p *Proxy) logRange(s *session, filter *logFilter) (uint64, uint64, error) {
+	var head uint64
+	resolve := func(param json.RawMessage) (uint64, error) {
+		ref, ok := parseBlockRef(param)
+		if !ok {
+			return 0, fmt.Errorf("invalid block %s", param)
+		}
+		if ref.isNumber {
+			return ref.number, nil
+		}
+
+		if head == 0 {
+			var err error
+			if head, err = p.chainHead(s.chain); err != nil {
+				return 0, err
+			}
+		}
+
+		return head, nil
+	}
+
+	from, err := resolve(filter.fields["fromBlock"])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	to, err := resolve(filter.fields["toBlock"])
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return from, to, nil
+}
+
+// chainHead returns the chain's head block, asking an upstream if it isn't tracked
+func (p *Proxy) chainHead(c *chain) (uint64, error) {
+	if head := c.head(); head > 0 {
+		return head, nil
+	}
+
+	var lastErr error = errors.New("no upstreams available")
+	for _, u := range c.balancer.order(c.upstreams) {
+		result, err := p.call(c, u, "eth_blockNumber")
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return parseQuantity(result)
+	}
+
+	return 0, lastErr
+}
+
+// chunkLogs splits the query into block ranges of the maximum size, runs
+// them with bounded concurrency and merges the logs in order. Every chunk
+// beyond the first is charged to the client's rate limit like a query of
+// its own, and no chunk is sent after one fails.
+func (p *Proxy) chunkLogs(s *session, req *jsonrpc.Request, filter *logFilter, from, to uint64) *jsonrpc.Response {
+	c := s.chain
+
+	var chunks []*jsonrpc.Request
+	for start := from; start <= to; start += c.logs.maxBlockRange {
+		end := start + c.logs.maxBlockRange - 1
+		if end > to || end < start {
+			end = to
+		}
+
+		fields := make(map[string]json.RawMessage, len(filter.fields))
+		for k, v := range filter.fields {
+			fields[k] = v
+		}
+		fields["fromBlock"] = quantity(start)
+		fields["toBlock"] = quantity(end)
+
+		chunk, err := logsRequest(req.ID, fields)
+		if err != nil {
+			return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+		}
+		chunks = append(chunks, chunk)
+
+		if end == to {
+			break
+		}
+	}
+
+	// the query itself was charged before it got here
+	if resp := p.chargeExtra(s, req, len(chunks)-1, "log query chunks"); resp != nil {
+		return resp
+	}
+
+	results := make([]*jsonrpc.Response, len(chunks))
+	sem := make(chan struct{}, c.logs.chunkConcurrency)
+	var wg sync.WaitGroup
+	var failed int32
+	for i, chunk := range chunks {
+		sem <- struct{}{}
+		if atomic.LoadInt32(&failed) != 0 {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, chunk *jsonrpc.Request) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result := p.forwardBatch(s, []*jsonrpc.Request{chunk})[0]
+			if result == nil {
+				result = jsonrpc.NewErrorResponse(chunk.ID, jsonrpc.CodeInternalError, "Upstream request failed", nil)
+			}
+			if result.Error != nil {
+				atomic.StoreInt32(&failed, 1)
+			}
+			results[i] = result
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		if result != nil && result.Error != nil {
+			return jsonrpc.NewErrorResponse(req.ID, result.Error.Code, result.Error.Message, result.Error.Data)
+		}
+	}
+
+	var logs []json.RawMessage
+	for _, result := range results {
+		var chunkLogs []json.RawMessage
+		if err := json.Unmarshal(result.Result, &chunkLogs); err != nil {
+			return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, "Invalid upstream log result", nil)
+		}
+		logs = append(logs, chunkLogs...)
+	}
+
+	sortLogs(logs)
+	p.logChunksCounter.Counter(c.name).Add(uint64(len(chunks)))
+
+	merged, err := json.Marshal(logs)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+	if logs == nil {
+		merged = json.RawMessage("[]")
+	}
+
+	return jsonrpc.NewResultResponse(req.ID, merged)
+}
+
+// logsRequest returns an eth_getLogs request for the filter object
+func logsRequest(id json.RawMessage, fields map[string]json.RawMessage) (*jsonrpc.Request, error) {
+	rawFilter, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jsonrpc.Request{
+		JSONRPC: jsonrpc.Version,
+		ID:      id,
+		Method:  "eth_getLogs",
+		Params:  json.RawMessage("[" + string(rawFilter) + "]"),
+	}, nil
+}
+
+// sortLogs orders logs by block number and log index
+func sortLogs(logs []json.RawMessage) {
+	type position struct {
+		BlockNumber string `json:"blockNumber"`
+		LogIndex    string `json:"logIndex"`
+	}
+
+	keys := make([][2]uint64, len(logs))
+	for i, log := range logs {
+		var pos position
+		json.Unmarshal(log, &pos)
+		keys[i][0], _ = strconv.ParseUint(trimHex(pos.BlockNumber), 16, 64)
+		keys[i][1], _ = strconv.ParseUint(trimHex(pos.LogIndex), 16, 64)
+	}
+
+	indexes := make([]int, len(logs))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		a, b := keys[indexes[i]], keys[indexes[j]]
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		return a[1] < b[1]
+	})
+
+	sorted := make([]json.RawMessage, len(logs))
+	for i, index := range indexes {
+		sorted[i] = logs[index]
+	}
+	copy(logs, sorted)
+}
+
+func trimHex(s string) string {
+	if len(s) > 1 && (s[:2] == "0x" || s[:2] == "0X") {
+		return s[2:]
+	}
+
+	return s
+}
+
+// quantity encodes a number as a JSON hex quantity
+func quantity(n uint64) json.RawMessage {
+	return json.RawMessage(`"0x` + strconv.FormatUint(n, 16) + `"`)
+}
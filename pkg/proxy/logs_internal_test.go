@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestParseLogFilter(t *testing.T) {
+	filter, err := parseLogFilter(&jsonrpc.Request{
+		Method: "eth_getLogs",
+		Params: json.RawMessage(`[{"address":["0x1","0x2"],"topics":["0xa",null,["0xb","0xc"]]}]`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.addresses != 2 || filter.topics != 3 {
+		t.Fatalf("expected 2 addresses and 3 topics, got %v and %v", filter.addresses, filter.topics)
+	}
+
+	filter, err = parseLogFilter(&jsonrpc.Request{Method: "eth_getLogs", Params: json.RawMessage(`[{"address":"0x1"}]`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.addresses != 1 || filter.topics != 0 {
+		t.Fatalf("expected 1 address and no topics, got %v and %v", filter.addresses, filter.topics)
+	}
+}
+
+func TestSortLogs(t *testing.T) {
+	logs := []json.RawMessage{
+		json.RawMessage(`{"blockNumber":"0x2","logIndex":"0x0"}`),
+		json.RawMessage(`{"blockNumber":"0x1","logIndex":"0xa"}`),
+		json.RawMessage(`{"blockNumber":"0x1","logIndex":"0x2"}`),
+	}
+	sortLogs(logs)
+
+	expected := []string{
+		`{"blockNumber":"0x1","logIndex":"0x2"}`,
+		`{"blockNumber":"0x1","logIndex":"0xa"}`,
+		`{"blockNumber":"0x2","logIndex":"0x0"}`,
+	}
+	for i, log := range logs {
+		if string(log) != expected[i] {
+			t.Fatalf("expected %s, got %s", expected[i], log)
+		}
+	}
+}
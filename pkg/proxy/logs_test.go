@@ -0,0 +1,132 @@
+package proxy_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestChunksLogs(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	// each chunk returns one log in its first block, failing on block 20
+	s.Handle("eth_getLogs", func(params json.RawMessage) (interface{}, error) {
+		var filters []map[string]string
+		if err := json.Unmarshal(params, &filters); err != nil {
+			return nil, err
+		}
+		if filters[0]["fromBlock"] == "0x14" {
+			return nil, errors.New("query timeout exceeded")
+		}
+		return []map[string]string{{"blockNumber": filters[0]["fromBlock"], "logIndex": "0x0"}}, nil
+	})
+
+	config := proxytest.Config(s)
+	config.LogsMaxBlockRange = 10
+	config.LogsChunking = true
+	config.LogsChunkConcurrency = 1
+	config.LogsMaxChunks = 2
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	filter := func(from, to string) map[string]string {
+		return map[string]string{"fromBlock": from, "toBlock": to}
+	}
+
+	expectResult(t, p.URL, `[{"blockNumber":"0x0","logIndex":"0x0"},{"blockNumber":"0xa","logIndex":"0x0"}]`, "eth_getLogs", filter("0x0", "0x13"))
+	if s.Count("eth_getLogs") != 2 {
+		t.Fatalf("expected 2 chunks, got %v", s.Count("eth_getLogs"))
+	}
+
+	// more chunks than allowed is the block range error
+	if resp, err := p.Call("eth_getLogs", filter("0x0", "0x14")); err != nil || resp.Error == nil || resp.Error.Message != "Log query spans 21 blocks, maximum is 20" {
+		t.Fatalf("expected a range error, got %+v %v", resp, err)
+	}
+	if s.Count("eth_getLogs") != 2 {
+		t.Fatal("expected no chunks sent")
+	}
+
+	// the second chunk fails, so the third isn't sent
+	config.LogsMaxChunks = 3
+	p2, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p2.Close()
+
+	if resp, err := p2.Call("eth_getLogs", filter("0xa", "0x27")); err != nil || resp.Error == nil || resp.Error.Message != "query timeout exceeded" {
+		t.Fatalf("expected the chunk error, got %+v %v", resp, err)
+	}
+	if s.Count("eth_getLogs") != 4 {
+		t.Fatalf("expected 2 more chunks, got %v", s.Count("eth_getLogs")-2)
+	}
+}
+
+func TestChargesLogChunks(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	s.SetResult("eth_getLogs", []string{})
+
+	config := proxytest.Config(s)
+	config.LogsMaxBlockRange = 10
+	config.LogsChunking = true
+	config.MethodCosts = map[string]int{"eth_getLogs": 10}
+	config.HardCapIPRequestsPerMinute = 40
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// 3 chunks cost 30 of the 40 per minute, so the next query's chunks are
+	// over the limit
+	filter := map[string]string{"fromBlock": "0x0", "toBlock": "0x1d"}
+	expectResult(t, p.URL, `[]`, "eth_getLogs", filter)
+	if resp, err := p.Call("eth_getLogs", filter); err != nil || resp.Error == nil || resp.Error.Code != jsonrpc.CodeLimitExceeded {
+		t.Fatalf("expected a rate limit error, got %+v %v", resp, err)
+	}
+	if s.Count("eth_getLogs") != 3 {
+		t.Fatalf("expected 3 chunks, got %v", s.Count("eth_getLogs"))
+	}
+}
+
+func TestChunkedLogsNotification(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	s.SetResult("eth_getLogs", []string{})
+
+	config := proxytest.Config(s)
+	config.LogsMaxBlockRange = 10
+	config.LogsChunking = true
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	notification := `{"jsonrpc":"2.0","method":"eth_getLogs","params":[{"fromBlock":"0x0","toBlock":"0x1f"}]}`
+	if resp, body := post(t, p.URL, notification); resp.StatusCode != http.StatusOK || len(body) != 0 {
+		t.Fatalf("expected an empty answer, got %v %q", resp.StatusCode, body)
+	}
+
+	resps := postBatch(t, p.URL, `[`+notification+`,{"jsonrpc":"2.0","id":1,"method":"eth_getLogs","params":[{"fromBlock":"0x0","toBlock":"0x1f"}]}]`)
+	if len(resps) != 1 || resps[0].Error != nil || string(resps[0].Result) != "[]" {
+		t.Fatalf("expected only the query to be answered, got %+v", resps)
+	}
+	if s.Count("eth_getLogs") != 4 {
+		t.Fatalf("expected only the query's 4 chunks sent, got %v", s.Count("eth_getLogs"))
+	}
+}
@@ -0,0 +1,41 @@
+package proxy
+
+// readMethods only read chain state, so sending them again or to several
+// upstreams can't change anything. Only they are retried by default, and
+// only they can be hedged, mirrored or require a quorum.
+var readMethods = map[string]bool{
+	"web3_clientVersion":                      true,
+	"net_version":                             true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"eth_chainId":                             true,
+	"eth_protocolVersion":                     true,
+	"eth_syncing":                             true,
+	"eth_blockNumber":                         true,
+	"eth_gasPrice":                            true,
+	"eth_maxPriorityFeePerGas":                true,
+	"eth_feeHistory":                          true,
+	"eth_blobBaseFee":                         true,
+	"eth_getBalance":                          true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionCount":                 true,
+	"eth_getCode":                             true,
+	"eth_getProof":                            true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockReceipts":                    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getUncleByBlockHashAndIndex":         true,
+	"eth_getUncleByBlockNumberAndIndex":       true,
+	"eth_getUncleCountByBlockHash":            true,
+	"eth_getUncleCountByBlockNumber":          true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionReceipt":               true,
+	"eth_getLogs":                             true,
+	"eth_call":                                true,
+	"eth_estimateGas":                         true,
+	"eth_createAccessList":                    true,
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// blockNumberParamIndexes are the positions of the block parameter of other
+// methods taking a block, which don't need an archive node
+var blockNumberParamIndexes = map[string]int{
+	"eth_getBlockByNumber":                    0,
+	"eth_getBlockTransactionCountByNumber":    0,
+	"eth_getUncleCountByBlockNumber":          0,
+	"eth_getTransactionByBlockNumberAndIndex": 0,
+	"eth_getUncleByBlockNumberAndIndex":       0,
+	"eth_getBlockReceipts":                    0,
+	"eth_getTransactionCount":                 1,
+	"eth_getProof":                            2,
+}
+
+// blockParamIndex returns the position of the method's block parameter
+func blockParamIndex(method string) (int, bool) {
+	if index, ok := blockParamIndexes[method]; ok {
+		return index, true
+	}
+
+	index, ok := blockNumberParamIndexes[method]
+	return index, ok
+}
+
+// referencedBlock returns the highest block number the request refers to
+func referencedBlock(req *jsonrpc.Request) (uint64, bool) {
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return 0, false
+		}
+	}
+
+	if req.Method == "eth_getLogs" {
+		if len(params) == 0 {
+			return 0, false
+		}
+
+		var filter struct {
+			FromBlock json.RawMessage `json:"fromBlock"`
+			ToBlock   json.RawMessage `json:"toBlock"`
+		}
+		if err := json.Unmarshal(params[0], &filter); err != nil {
+			return 0, false
+		}
+
+		var highest uint64
+		found := false
+		for _, param := range []json.RawMessage{filter.FromBlock, filter.ToBlock} {
+			if ref, ok := parseBlockRef(param); ok && ref.isNumber {
+				if !found || ref.number > highest {
+					highest = ref.number
+				}
+				found = true
+			}
+		}
+
+		return highest, found
+	}
+
+	index, ok := blockParamIndex(req.Method)
+	if !ok || index >= len(params) {
+		return 0, false
+	}
+
+	ref, ok := parseBlockRef(params[index])
+	if !ok || !ref.isNumber {
+		return 0, false
+	}
+
+	return ref.number, true
+}
+
+// routeHeads narrows the upstreams to those known to have every block the
+// requests refer to. If none are, the upstreams with the highest head go first.
+func (c *chain) routeHeads(reqs []*jsonrpc.Request, upstreams []*upstream) []*upstream {
+	if !c.tracksHead {
+		return upstreams
+	}
+
+	var needed uint64
+	found := false
+	for _, req := range reqs {
+		if block, ok := referencedBlock(req); ok && (!found || block > needed) {
+			needed = block
+			found = true
+		}
+	}
+
+	if !found {
+		return upstreams
+	}
+
+	routed := make([]*upstream, 0, len(upstreams))
+	for _, u := range upstreams {
+		if u.latestBlock() >= needed {
+			routed = append(routed, u)
+		}
+	}
+
+	if len(routed) > 0 {
+		return routed
+	}
+
+	routed = append(routed, upstreams...)
+	sort.SliceStable(routed, func(i, j int) bool {
+		return routed[i].latestBlock() > routed[j].latestBlock()
+	})
+
+	return routed
+}
+
+// safeHead returns the highest block every available upstream has, or 0 if
+// it's unknown
+func (c *chain) safeHead() uint64 {
+	var head uint64
+	for _, u := range c.upstreams {
+		block := u.latestBlock()
+		if !u.available() || block == 0 {
+			continue
+		}
+		if head == 0 || block < head {
+			head = block
+		}
+	}
+
+	return head
+}
+
+// pinLatest rewrites "latest" block parameters, given or implied, to the
+// safe head so that every upstream answers for the same block. It returns
+// true if any request was changed.
+func (c *chain) pinLatest(reqs []*jsonrpc.Request) bool {
+	if !c.pinsLatest {
+		return false
+	}
+
+	head := c.safeHead()
+	if head == 0 {
+		return false
+	}
+
+	block := quantity(head)
+
+	changed := false
+	for _, req := range reqs {
+		if pinRequest(req, block) {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// pinRequest replaces the request's latest block parameter with the block
+func pinRequest(req *jsonrpc.Request, block json.RawMessage) bool {
+	var params []json.RawMessage
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return false
+		}
+	}
+
+	if req.Method == "eth_getLogs" {
+		if len(params) == 0 {
+			return false
+		}
+
+		var filter map[string]json.RawMessage
+		if err := json.Unmarshal(params[0], &filter); err != nil || filter == nil {
+			return false
+		}
+		if _, ok := filter["blockHash"]; ok {
+			return false
+		}
+
+		changed := false
+		for _, key := range []string{"fromBlock", "toBlock"} {
+			if ref, ok := parseBlockRef(filter[key]); ok && ref.tag == "latest" {
+				filter[key] = block
+				changed = true
+			}
+		}
+		if !changed {
+			return false
+		}
+
+		params[0], _ = json.Marshal(filter)
+		return setParams(req, params)
+	}
+
+	index, ok := blockParamIndex(req.Method)
+	if !ok || index > len(params) {
+		return false
+	}
+
+	// a missing last block parameter means latest
+	if index == len(params) {
+		params = append(params, block)
+		return setParams(req, params)
+	}
+
+	var tag string
+	if err := json.Unmarshal(params[index], &tag); err != nil || tag != "latest" {
+		return false
+	}
+
+	params[index] = block
+	return setParams(req, params)
+}
+
+func setParams(req *jsonrpc.Request, params []json.RawMessage) bool {
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return false
+	}
+
+	req.Params = rawParams
+	return true
+}
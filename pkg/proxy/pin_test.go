@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestRouteHeads(t *testing.T) {
+	behind := &upstream{name: "behind"}
+	ahead := &upstream{name: "ahead"}
+	behind.setHead(99)
+	ahead.setHead(100)
+	c := &chain{upstreams: []*upstream{behind, ahead}, tracksHead: true}
+
+	reqs := []*jsonrpc.Request{{Method: "eth_getBlockByNumber", Params: json.RawMessage(`["0x64",false]`)}}
+	if routed := c.routeHeads(reqs, c.upstreams); len(routed) != 1 || routed[0] != ahead {
+		t.Fatal("expected only the upstream with the block")
+	}
+
+	reqs = []*jsonrpc.Request{{Method: "eth_getBlockByNumber", Params: json.RawMessage(`["0x65",false]`)}}
+	if routed := c.routeHeads(reqs, c.upstreams); len(routed) != 2 || routed[0] != ahead {
+		t.Fatal("expected the highest head first")
+	}
+}
+
+func TestPinLatest(t *testing.T) {
+	behind := &upstream{name: "behind"}
+	ahead := &upstream{name: "ahead"}
+	behind.setHead(0x63)
+	ahead.setHead(0x64)
+	c := &chain{upstreams: []*upstream{behind, ahead}, pinsLatest: true}
+
+	reqs := []*jsonrpc.Request{
+		{Method: "eth_getBalance", Params: json.RawMessage(`["0xabc"]`)},
+		{Method: "eth_call", Params: json.RawMessage(`[{},"latest"]`)},
+		{Method: "eth_getLogs", Params: json.RawMessage(`[{"fromBlock":"0x1","toBlock":"latest"}]`)},
+		{Method: "eth_getCode", Params: json.RawMessage(`["0xabc","0x1"]`)},
+	}
+	if !c.pinLatest(reqs) {
+		t.Fatal("expected requests to be pinned")
+	}
+
+	expected := []string{
+		`["0xabc","0x63"]`,
+		`[{},"0x63"]`,
+		`[{"fromBlock":"0x1","toBlock":"0x63"}]`,
+		`["0xabc","0x1"]`,
+	}
+	for i, req := range reqs {
+		if string(req.Params) != expected[i] {
+			t.Fatalf("expected %s, got %s", expected[i], req.Params)
+		}
+	}
+}
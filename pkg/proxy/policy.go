@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// localResponse returns a response for requests that can be answered without
+// going upstream, either because the method is blocked, the result is cached
+// or it's about the head block the proxy tracks.
+func (p *Proxy) localResponse(c *chain, req *jsonrpc.Request) *jsonrpc.Response {
+	if c.blockedMethods[req.Method] {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeMethodNotFound, fmt.Sprintf("Method %s is not allowed", req.Method), nil)
+	}
+
+	if resp := c.headResponse(req); resp != nil {
+		return resp
+	}
+
+	if _, ok := c.cachedMethods[req.Method]; ok {
+		if cached, _, found := p.cache.Get(responseCacheKey(c, req)); found {
+			return jsonrpc.NewResultResponse(req.ID, cached.(json.RawMessage))
+		}
+	}
+
+	return nil
+}
+
+// cacheResponses caches successful results of cacheable methods. The
+// responses are in the same order as the requests.
+func (p *Proxy) cacheResponses(c *chain, reqs []*jsonrpc.Request, resps []*jsonrpc.Response) {
+	if len(c.cachedMethods) == 0 || len(resps) != len(reqs) {
+		return
+	}
+
+	for i, req := range reqs {
+		ttl, ok := c.cachedMethods[req.Method]
+		if !ok || req.IsNotification() {
+			continue
+		}
+
+		if resp := resps[i]; resp != nil && resp.Error == nil && len(resp.Result) > 0 {
+			p.cache.Set(responseCacheKey(c, req), resp.Result, ttl)
+		}
+	}
+}
+
+// responseCacheKey ...
+func responseCacheKey(c *chain, req *jsonrpc.Request) string {
+	params := string(req.Params)
+	if params == "" {
+		params = "[]"
+	}
+
+	return fmt.Sprintf("response:%s:%s:%s", c.name, req.Method, jsonrpc.IDKey(json.RawMessage(params)))
+}
+
+// ParseMethodTTLs parses a comma separated list of method=duration pairs,
+// e.g. "eth_chainId=1h,net_version=1h"
+func ParseMethodTTLs(s string) (map[string]Duration, error) {
+	ttls := make(map[string]Duration)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid method ttl %q", pair)
+		}
+
+		ttl, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid method ttl %q", pair)
+		}
+
+		ttls[strings.TrimSpace(parts[0])] = Duration(ttl)
+	}
+
+	return ttls, nil
+}
@@ -0,0 +1,100 @@
+package proxy_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestCachesMethods(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	config := proxytest.Config(s)
+	config.CachedMethods = map[string]proxy.Duration{"eth_chainId": proxy.Duration(time.Minute)}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	expectResult(t, p.URL, `"0x1"`, "eth_chainId")
+	expectResult(t, p.URL, `"0x1"`, "eth_chainId")
+	if s.Count("eth_chainId") != 1 {
+		t.Fatalf("expected one upstream request, got %v", s.Count("eth_chainId"))
+	}
+}
+
+func TestCacheDuplicateRequestIDs(t *testing.T) {
+	// forwarded untouched, and forwarded after the blocked method is
+	// answered locally
+	batches := []string{
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice"}]`,
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":1,"method":"eth_gasPrice"},{"jsonrpc":"2.0","id":2,"method":"debug_traceTransaction"}]`,
+	}
+
+	for i, batch := range batches {
+		s := proxytest.NewServer()
+		defer s.Close()
+
+		s.SetResult("eth_gasPrice", "0x3b9aca00")
+
+		config := proxytest.Config(s)
+		config.CachedMethods = map[string]proxy.Duration{"eth_chainId": proxy.Duration(time.Minute)}
+		config.BlockedMethods = []string{"debug_traceTransaction"}
+
+		p, err := proxytest.NewProxy(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer p.Close()
+
+		postBatch(t, p.URL, batch)
+		expectResult(t, p.URL, `"0x1"`, "eth_chainId")
+		if s.Count("eth_chainId") != 2 {
+			t.Fatalf("batch %v: expected nothing cached, got %v upstream requests", i, s.Count("eth_chainId"))
+		}
+	}
+}
+
+func TestCacheDuplicateResponseIDs(t *testing.T) {
+	var singles int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, 1)
+		r.Body.Read(body)
+		if body[0] == '[' {
+			w.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x3b9aca00"},{"jsonrpc":"2.0","id":1,"result":"0x1"}]`))
+			return
+		}
+		atomic.AddInt32(&singles, 1)
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer server.Close()
+
+	config := &proxy.Config{
+		Upstreams:                  []*proxy.UpstreamConfig{{Name: "upstream1", URL: server.URL}},
+		ProxyMethod:                "POST",
+		LeakyBucketLimitPerSecond:  100000,
+		SoftCapIPRequestsPerMinute: 100000,
+		HardCapIPRequestsPerMinute: 100000,
+		CachedMethods:              map[string]proxy.Duration{"eth_chainId": proxy.Duration(time.Minute)},
+	}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	postBatch(t, p.URL, `[{"jsonrpc":"2.0","id":1,"method":"eth_chainId"},{"jsonrpc":"2.0","id":2,"method":"eth_gasPrice"}]`)
+	expectResult(t, p.URL, `"0x1"`, "eth_chainId")
+	if atomic.LoadInt32(&singles) != 1 {
+		t.Fatal("expected nothing cached from the ambiguous response")
+	}
+}
@@ -8,59 +8,181 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/journal"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/limiter"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/metrics"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/recording"
 	"github.com/miguelmota/go-rpc-provider-proxy/pkg/slack"
 	"go.uber.org/ratelimit"
 )
 
 // Config ...
 type Config struct {
-	ProxyURL                   string
-	ProxyMethod                string
-	Port                       string
-	LogLevel                   string
-	AuthorizationSecret        string
-	BlockedIps                 []string
-	AlwaysAllowedIps           []string
-	LeakyBucketLimitPerSecond  int
-	SoftCapIPRequestsPerMinute int
-	HardCapIPRequestsPerMinute int
-	SlackWebhookURL            string
-	SlackChannel               string
+	ProxyURL                   string              `json:"proxyUrl"`
+	Upstreams                  []*UpstreamConfig   `json:"upstreams"`
+	LoadBalancing              string              `json:"loadBalancing"`
+	ArchiveBlocks              int                 `json:"archiveBlocks"`
+	HeadPollInterval           Duration            `json:"headPollInterval"`
+	TrackHeads                 bool                `json:"trackHeads"`
+	PinLatest                  bool                `json:"pinLatest"`
+	ServeHead                  bool                `json:"serveHead"`
+	HeadMaxAge                 Duration            `json:"headMaxAge"`
+	ChainID                    uint64              `json:"chainId"`
+	ChainIDCheckInterval       Duration            `json:"chainIdCheckInterval"`
+	CircuitBreaker             bool                `json:"circuitBreaker"`
+	CircuitBreakerErrorRate    float64             `json:"circuitBreakerErrorRate"`
+	CircuitBreakerWindow       int                 `json:"circuitBreakerWindow"`
+	CircuitBreakerFailures     int                 `json:"circuitBreakerFailures"`
+	CircuitBreakerCooldown     Duration            `json:"circuitBreakerCooldown"`
+	RetryAttempts              int                 `json:"retryAttempts"`
+	RetryBackoff               Duration            `json:"retryBackoff"`
+	RetryBudget                Duration            `json:"retryBudget"`
+	RetryErrors                []string            `json:"retryErrors"`
+	RetryUnsafeMethods         []string            `json:"retryUnsafeMethods"`
+	ProxyMethod                string              `json:"proxyMethod"`
+	Port                       string              `json:"port"`
+	LogLevel                   string              `json:"logLevel"`
+	AuthorizationSecret        string              `json:"authSecret"`
+	BlockedIps                 []string            `json:"blockedIps"`
+	AlwaysAllowedIps           []string            `json:"alwaysAllowedIps"`
+	LeakyBucketLimitPerSecond  int                 `json:"limitPerSecond"`
+	SoftCapIPRequestsPerMinute int                 `json:"softCapIpRequestsPerMinute"`
+	HardCapIPRequestsPerMinute int                 `json:"hardCapIpRequestsPerMinute"`
+	IPRequestsPerSecond        int                 `json:"ipRequestsPerSecond"`
+	IPRequestsPerDay           int                 `json:"ipRequestsPerDay"`
+	IPBurst                    int                 `json:"ipBurst"`
+	IPMinuteBurst              int                 `json:"ipMinuteBurst"`
+	IPDayBurst                 int                 `json:"ipDayBurst"`
+	RateLimitAlgorithm         string              `json:"rateLimitAlgorithm"`
+	IPBanDuration              Duration            `json:"ipBanDuration"`
+	RedisURL                   string              `json:"redisUrl"`
+	StoreCapacity              int                 `json:"storeCapacity"`
+	StoreCleanupInterval       Duration            `json:"storeCleanupInterval"`
+	CacheCapacity              int                 `json:"cacheCapacity"`
+	SnapshotPath               string              `json:"snapshotPath"`
+	SnapshotInterval           Duration            `json:"snapshotInterval"`
+	SlackWebhookURL            string              `json:"slackWebhookUrl"`
+	SlackChannel               string              `json:"slackChannel"`
+	MethodCosts                map[string]int      `json:"methodCosts"`
+	DefaultMethodCost          int                 `json:"defaultMethodCost"`
+	MaxBatchSize               int                 `json:"maxBatchSize"`
+	BatchChunkSize             int                 `json:"batchChunkSize"`
+	BlockedMethods             []string            `json:"blockedMethods"`
+	LogsMaxBlockRange          int                 `json:"logsMaxBlockRange"`
+	LogsMaxAddresses           int                 `json:"logsMaxAddresses"`
+	LogsMaxTopics              int                 `json:"logsMaxTopics"`
+	LogsChunking               bool                `json:"logsChunking"`
+	LogsChunkConcurrency       int                 `json:"logsChunkConcurrency"`
+	LogsMaxChunks              int                 `json:"logsMaxChunks"`
+	Broadcast                  bool                `json:"broadcast"`
+	BroadcastUpstreams         []*UpstreamConfig   `json:"broadcastUpstreams"`
+	TxAllowedSenders           []string            `json:"txAllowedSenders"`
+	TxDeniedSenders            []string            `json:"txDeniedSenders"`
+	TxAllowedRecipients        []string            `json:"txAllowedRecipients"`
+	TxDeniedRecipients         []string            `json:"txDeniedRecipients"`
+	TxAllowedChainIDs          []uint64            `json:"txAllowedChainIds"`
+	TxMaxValue                 string              `json:"txMaxValue"`
+	TxMaxGasPrice              string              `json:"txMaxGasPrice"`
+	JournalPath                string              `json:"journalPath"`
+	JournalCheckInterval       Duration            `json:"journalCheckInterval"`
+	JournalDropTimeout         Duration            `json:"journalDropTimeout"`
+	JournalRetention           Duration            `json:"journalRetention"`
+	AdminSecret                string              `json:"adminSecret"`
+	RecordPath                 string              `json:"recordPath"`
+	RecordRedact               []string            `json:"recordRedact"`
+	ReplayPath                 string              `json:"replayPath"`
+	EmulateFilters             bool                `json:"emulateFilters"`
+	FilterTimeout              Duration            `json:"filterTimeout"`
+	MaxFiltersPerIP            int                 `json:"maxFiltersPerIp"`
+	HedgedMethods              []string            `json:"hedgedMethods"`
+	HedgePercentile            int                 `json:"hedgePercentile"`
+	HedgeDelay                 Duration            `json:"hedgeDelay"`
+	CachedMethods              map[string]Duration `json:"cachedMethods"`
+	ShadowUpstream             *UpstreamConfig     `json:"shadowUpstream"`
+	ShadowMethods              []string            `json:"shadowMethods"`
+	ShadowTimeout              Duration            `json:"shadowTimeout"`
+	QuorumMethods              map[string]int      `json:"quorumMethods"`
+	QuorumTimeout              Duration            `json:"quorumTimeout"`
+	Chains                     []*ChainConfig      `json:"chains"`
 }
 
 // Proxy ...
 type Proxy struct {
-	httpClient                 *http.Client
-	proxyURL                   *url.URL
-	proxyMethod                string
-	port                       string
-	maxIdleConnections         int
-	requestTimeout             int
-	method                     string
-	sessionID                  int
-	logLevel                   string
-	authorizationSecret        string
-	ratelimit                  ratelimit.Limiter
-	blockedIps                 map[string]bool
-	alwaysAllowedIps           map[string]bool
-	cache                      *cache.Cache
-	leakyBucketLimitPerSecond  int
-	softCapIPRequestsPerMinute int
-	hardCapIPRequestsPerMinute int
-	slackWebhookURL            string
-	slackChannel               string
+	httpClient                *http.Client
+	port                      string
+	maxIdleConnections        int
+	requestTimeout            int
+	sessionID                 int
+	logLevel                  string
+	ratelimit                 ratelimit.Limiter
+	blockedIps                map[string]bool
+	alwaysAllowedIps          map[string]bool
+	cache                     *cache.Cache
+	store                     cache.Store
+	metrics                   *metrics.Registry
+	requestsCounter           *metrics.Vec
+	rateLimitedCounter        *metrics.Vec
+	upstreamRequestsCounter   *metrics.Vec
+	upstreamLatencyGauge      *metrics.Vec
+	hedgedRequestsCounter     *metrics.Vec
+	hedgeWinsCounter          *metrics.Vec
+	shadowRequestsCounter     *metrics.Vec
+	quorumCounter             *metrics.Vec
+	circuitStateGauge         *metrics.Vec
+	retriesCounter            *metrics.Vec
+	logChunksCounter          *metrics.Vec
+	broadcastsCounter         *metrics.Vec
+	txRejectedCounter         *metrics.Vec
+	txStatusCounter           *metrics.Vec
+	headBlockGauge            *metrics.Vec
+	headPollInterval          time.Duration
+	chains                    []*chain
+	snapshotPath              string
+	snapshotInterval          time.Duration
+	chainIDCheckInterval      time.Duration
+	leakyBucketLimitPerSecond int
+	ipBanDuration             time.Duration
+	retry                     *retryPolicy
+	journal                   *journal.Journal
+	journalCheckInterval      time.Duration
+	journalDropTimeout        time.Duration
+	journalRetention          time.Duration
+	adminSecret               string
+	recorder                  *recording.Recorder
+	replayer                  *recording.Replayer
+	slackWebhookURL           string
+	slackChannel              string
 }
 
-// NewProxy ...
+// session is the state of a single client request as it moves through the proxy
+type session struct {
+	id        int
+	ipAddress string
+	chain     *chain
+	r         *http.Request
+}
+
+// NewProxy returns a proxy with the config, and panics if the config is
+// invalid
 func NewProxy(config *Config) *Proxy {
+	p, err := New(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// New returns a proxy with the config, or an error if the config is invalid
+func New(config *Config) (*Proxy, error) {
 	if config == nil {
-		panic("Proxy config is required")
+		return nil, errors.New("Proxy config is required")
 	}
 
 	port := "8000"
@@ -68,17 +190,63 @@ func NewProxy(config *Config) *Proxy {
 		port = config.Port
 	}
 
-	method := "GET"
-	if config.ProxyMethod != "" {
-		method = strings.ToUpper(config.ProxyMethod)
-	}
-
 	lps := 100
 	if config.LeakyBucketLimitPerSecond != 0 {
 		lps = config.LeakyBucketLimitPerSecond
 	}
 	rl := ratelimit.New(lps)
-	cache := cache.NewCache()
+	registry := metrics.NewRegistry()
+
+	storeCleanupInterval := 10 * time.Minute
+	if config.StoreCleanupInterval != 0 {
+		storeCleanupInterval = time.Duration(config.StoreCleanupInterval)
+	}
+
+	// rate limit counters and bans are shared between replicas when using redis,
+	// otherwise they're kept in memory, bounded if a capacity is set.
+	var store cache.Store
+	switch {
+	case config.RedisURL != "":
+		redisStore, err := cache.NewRedisStore(config.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		store = redisStore
+	case config.StoreCapacity > 0:
+		lruStore := cache.NewLRUStore(config.StoreCapacity, storeCleanupInterval)
+		registry.NewCounterFunc("goproxy_store_evictions_total", "Keys evicted from the rate limit store to make room for new keys", func() float64 {
+			return float64(lruStore.Evictions())
+		})
+		registry.NewCounterFunc("goproxy_store_expirations_total", "Expired keys removed from the rate limit store", func() float64 {
+			return float64(lruStore.Expirations())
+		})
+		registry.NewGaugeFunc("goproxy_store_capacity", "Maximum number of keys in the rate limit store", func() float64 {
+			return float64(config.StoreCapacity)
+		})
+		store = lruStore
+	default:
+		store = cache.NewMemoryStore(storeCleanupInterval)
+	}
+
+	if sized, ok := store.(interface{ Len() int }); ok {
+		registry.NewGaugeFunc("goproxy_store_keys", "Number of keys in the rate limit store", func() float64 {
+			return float64(sized.Len())
+		})
+	}
+
+	// cached responses and filters are keyed by client requests, so they're
+	// always bounded
+	cacheCapacity := config.CacheCapacity
+	if cacheCapacity == 0 {
+		cacheCapacity = 10000
+	}
+	cache := cache.NewCache(cacheCapacity, storeCleanupInterval)
+	registry.NewGaugeFunc("goproxy_cache_keys", "Number of cached responses and filters", func() float64 {
+		return float64(cache.Len())
+	})
+	registry.NewCounterFunc("goproxy_cache_evictions_total", "Cached responses and filters evicted to make room for new ones", func() float64 {
+		return float64(cache.Evictions())
+	})
 
 	blockedIps := make(map[string]bool, len(config.BlockedIps))
 	alwaysAllowedIps := make(map[string]bool, len(config.AlwaysAllowedIps))
@@ -91,40 +259,113 @@ func NewProxy(config *Config) *Proxy {
 		alwaysAllowedIps[ip] = true
 	}
 
-	softCapIPRequestsPerMinute := 100
-	if config.SoftCapIPRequestsPerMinute != 0 {
-		softCapIPRequestsPerMinute = config.SoftCapIPRequestsPerMinute
+	chains, err := newChains(config, store)
+	if err != nil {
+		return nil, err
 	}
 
-	hardCapIPRequestsPerMinute := 1000
-	if config.HardCapIPRequestsPerMinute != 0 {
-		hardCapIPRequestsPerMinute = config.HardCapIPRequestsPerMinute
+	retry, err := newRetryPolicy(config)
+	if err != nil {
+		return nil, err
 	}
 
-	proxyURL, err := url.Parse(config.ProxyURL)
-	if err != nil {
-		panic(err)
+	var txJournal *journal.Journal
+	if config.JournalPath != "" {
+		txJournal, err = journal.Open(config.JournalPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	journalCheckInterval := time.Duration(config.JournalCheckInterval)
+	if journalCheckInterval == 0 {
+		journalCheckInterval = 15 * time.Second
 	}
 
-	return &Proxy{
-		port:                       port,
-		proxyURL:                   proxyURL,
-		proxyMethod:                method,
-		maxIdleConnections:         100,
-		requestTimeout:             3600,
-		sessionID:                  0,
-		logLevel:                   config.LogLevel,
-		authorizationSecret:        config.AuthorizationSecret,
-		ratelimit:                  rl,
-		blockedIps:                 blockedIps,
-		alwaysAllowedIps:           alwaysAllowedIps,
-		cache:                      cache,
-		leakyBucketLimitPerSecond:  lps,
-		softCapIPRequestsPerMinute: softCapIPRequestsPerMinute,
-		hardCapIPRequestsPerMinute: hardCapIPRequestsPerMinute,
-		slackWebhookURL:            config.SlackWebhookURL,
-		slackChannel:               config.SlackChannel,
+	journalDropTimeout := time.Duration(config.JournalDropTimeout)
+	if journalDropTimeout == 0 {
+		journalDropTimeout = 1 * time.Hour
 	}
+
+	journalRetention := time.Duration(config.JournalRetention)
+	if journalRetention == 0 {
+		journalRetention = 30 * 24 * time.Hour
+	}
+
+	if config.RecordPath != "" && config.ReplayPath != "" {
+		return nil, errors.New("Recording and replaying can't be used together")
+	}
+
+	var recorder *recording.Recorder
+	if config.RecordPath != "" {
+		recorder, err = recording.NewRecorder(config.RecordPath, recordSecrets(config, chains))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var replayer *recording.Replayer
+	if config.ReplayPath != "" {
+		replayer, err = recording.LoadReplayer(config.ReplayPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	headPollInterval := time.Duration(config.HeadPollInterval)
+	if headPollInterval == 0 {
+		headPollInterval = 12 * time.Second
+	}
+
+	p := &Proxy{
+		port:                      port,
+		maxIdleConnections:        100,
+		requestTimeout:            3600,
+		sessionID:                 0,
+		logLevel:                  config.LogLevel,
+		ratelimit:                 rl,
+		blockedIps:                blockedIps,
+		alwaysAllowedIps:          alwaysAllowedIps,
+		cache:                     cache,
+		store:                     store,
+		metrics:                   registry,
+		requestsCounter:           registry.NewCounterVec("goproxy_requests_total", "Requests received", "chain"),
+		rateLimitedCounter:        registry.NewCounterVec("goproxy_rate_limited_total", "Requests rejected by IP rate limits", "chain"),
+		upstreamRequestsCounter:   registry.NewCounterVec("goproxy_upstream_requests_total", "Requests sent to upstreams", "chain", "upstream", "result"),
+		upstreamLatencyGauge:      registry.NewGaugeVec("goproxy_upstream_latency_seconds", "Moving average of upstream response times", "chain", "upstream"),
+		hedgedRequestsCounter:     registry.NewCounterVec("goproxy_hedged_requests_total", "Requests also sent to a second upstream", "chain"),
+		hedgeWinsCounter:          registry.NewCounterVec("goproxy_hedge_wins_total", "Hedged requests answered first by the second upstream", "chain"),
+		shadowRequestsCounter:     registry.NewCounterVec("goproxy_shadow_requests_total", "Requests mirrored to the shadow upstream, by method and comparison result", "chain", "method", "result"),
+		quorumCounter:             registry.NewCounterVec("goproxy_quorum_requests_total", "Requests that required upstreams to agree, by method and outcome", "chain", "method", "result"),
+		circuitStateGauge:         registry.NewGaugeVec("goproxy_upstream_circuit_state", "Upstream circuit state, 0 closed, 0.5 half-open, 1 open", "chain", "upstream"),
+		retriesCounter:            registry.NewCounterVec("goproxy_upstream_retries_total", "Upstream requests retried, by reason", "chain", "reason"),
+		headBlockGauge:            registry.NewGaugeVec("goproxy_upstream_head_block", "Latest block number reported by upstreams", "chain", "upstream"),
+		headPollInterval:          headPollInterval,
+		logChunksCounter:          registry.NewCounterVec("goproxy_log_chunks_total", "eth_getLogs chunk requests sent upstream for split queries", "chain"),
+		broadcastsCounter:         registry.NewCounterVec("goproxy_tx_broadcasts_total", "Transactions broadcast to every upstream, by outcome", "chain", "result"),
+		txRejectedCounter:         registry.NewCounterVec("goproxy_tx_rejected_total", "Transactions rejected by the sender and recipient rules", "chain"),
+		txStatusCounter:           registry.NewCounterVec("goproxy_tx_status_total", "Journaled transactions by the status they reached", "chain", "status"),
+		chains:                    chains,
+		leakyBucketLimitPerSecond: lps,
+		ipBanDuration:             time.Duration(config.IPBanDuration),
+		retry:                     retry,
+		journal:                   txJournal,
+		journalCheckInterval:      journalCheckInterval,
+		journalDropTimeout:        journalDropTimeout,
+		journalRetention:          journalRetention,
+		adminSecret:               config.AdminSecret,
+		recorder:                  recorder,
+		replayer:                  replayer,
+		slackWebhookURL:           config.SlackWebhookURL,
+		slackChannel:              config.SlackChannel,
+		snapshotPath:              config.SnapshotPath,
+		snapshotInterval:          time.Duration(config.SnapshotInterval),
+		chainIDCheckInterval:      time.Duration(config.ChainIDCheckInterval),
+	}
+
+	p.loadSnapshot()
+
+	return p, nil
 }
 
 // PingHandler ...
@@ -132,15 +373,35 @@ func (p *Proxy) PingHandler(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "pong")
 }
 
-// HealthCheckHandler ...
+// HealthCheckHandler checks that every chain can be proxied
 func (p *Proxy) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
+	for _, c := range p.chains {
+		if err := p.checkChainHealth(c); err != nil {
+			err := fmt.Sprintf("Health check error: CHAIN=%s %s", c.name, err.Error())
+			http.Error(w, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+	w.Write([]byte("OK"))
+
+	// the age of the head block shows if upstreams stopped following the chain
+	for _, c := range p.chains {
+		if latest := c.latestHead(); latest != nil {
+			age := time.Since(latest.timestamp).Truncate(time.Second)
+			fmt.Fprintf(w, "\nCHAIN=%s HEAD=%v HEAD_AGE=%s", c.name, latest.number, age)
+		}
+	}
+}
+
+// checkChainHealth sends a request through the proxy to the chain
+func (p *Proxy) checkChainHealth(c *chain) error {
 	payload := []byte(`{"jsonrpc":"2.0","method":"web3_clientVersion","params":[],"id":42}`)
-	url := fmt.Sprintf("http://127.0.0.1:%v", p.port)
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	url := fmt.Sprintf("http://127.0.0.1:%v%s", p.port, c.path)
+	req, err := http.NewRequest(c.proxyMethod, url, bytes.NewBuffer(payload))
 	if err != nil {
-		err := fmt.Sprintf("Health check error: %s", err.Error())
-		http.Error(w, err, http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -148,21 +409,16 @@ func (p *Proxy) HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		err := fmt.Sprintf("Health check error: %s", err.Error())
-		http.Error(w, err, http.StatusInternalServerError)
-		return
+		return err
 	}
 
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err := fmt.Sprintf("Health check error: got status code %v", resp.StatusCode)
-		http.Error(w, err, resp.StatusCode)
-		return
+		return fmt.Errorf("got status code %v", resp.StatusCode)
 	}
 
-	w.WriteHeader(200)
-	w.Write([]byte("OK"))
+	return nil
 }
 
 // ProxyHandler ...
@@ -188,93 +444,106 @@ func (p *Proxy) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rateLimitCacheKey := fmt.Sprintf("ratelimit:%s", ipAddress)
+	c := p.matchChain(r.URL.Path)
+	if c == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	s := &session{
+		id:        sessionID,
+		ipAddress: ipAddress,
+		chain:     c,
+		r:         r,
+	}
+
+	p.requestsCounter.Counter(c.name).Inc()
+
+	bodyBuf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		fmt.Printf("ERROR ID=%v: %s %s CHAIN=%s\n", sessionID, err, ipAddress, c.name)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	// bodies that aren't JSON-RPC are charged the default cost and rejected
+	// below, since the per-method policies can't be applied to them
+	rpcRequests, isBatch, parseErr := jsonrpc.ParseRequests(bodyBuf)
+	cost := c.requestCost(rpcRequests)
 
 	// don't rate limit IPs that are always allowed
+	var rateLimitResult *limiter.Result
 	if _, ok := p.alwaysAllowedIps[ipAddress]; !ok {
-		count := 0
-		cached, expiration, found := p.cache.Get(rateLimitCacheKey)
-		if found {
-			count = cached.(int)
+		banCacheKey := fmt.Sprintf("ban:%s", ipAddress)
+		if _, expiration, found := p.store.Get(banCacheKey); found {
+			retryAfter := expiration.Sub(time.Now())
+			err := fmt.Sprintf("Banned: Hard cap exceeded. Try again in %vs", seconds(retryAfter))
+			fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", sessionID, err, ipAddress, c.name)
+			p.rateLimitedCounter.Counter(c.name).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+			writeRateLimitError(w, rpcRequests, isBatch, retryAfter)
+			return
 		}
 
-		tryAgainInSeconds := expiration.Sub(time.Now()).Seconds()
+		result := c.limiter.Allow(c.rateLimitKey(ipAddress), cost)
+		rateLimitResult = result
+		setRateLimitHeaders(w.Header(), result)
 
 		// send slack notification on soft cap rate limit reached for IP
-		if count == p.softCapIPRequestsPerMinute {
-			notification := fmt.Sprintf("⚠️ SOFT cap reached (%v req/min) IP=%s ORIGIN=%s PROXY=%s ID=%v\n", count, ipAddress, origin, p.proxyURL.Hostname(), sessionID)
+		if usage, ok := result.Usage("min"); ok && result.Allowed && usage.Used >= c.softCapIPRequestsPerMinute && usage.Used-cost < c.softCapIPRequestsPerMinute {
+			notification := fmt.Sprintf("⚠️ SOFT cap reached (%v req/min) IP=%s ORIGIN=%s CHAIN=%s PROXY=%s ID=%v\n", usage.Used, ipAddress, origin, c.name, c.hostnames(), sessionID)
 			fmt.Printf(notification)
 			p.sendNotification(notification)
 		}
 
-		// send slack notification on hard cap rate limit reached for IP
-		if count == p.hardCapIPRequestsPerMinute {
-			seenCacheKey := fmt.Sprintf("seen:%s", ipAddress)
-			if _, _, found := p.cache.Get(seenCacheKey); !found {
-				notification := fmt.Sprintf("🚫 HARD cap reached (%v req/min) IP=%s ORIGIN=%s PROXY=%s ID=%v\n", count, ipAddress, origin, p.proxyURL.Hostname(), sessionID)
+		// prevent request if hard cap rate limit reached for IP
+		if !result.Allowed {
+			p.rateLimitedCounter.Counter(c.name).Inc()
+
+			// send slack notification on hard cap rate limit reached for IP
+			seenCacheKey := fmt.Sprintf("seen:%s", c.rateLimitKey(ipAddress))
+			if _, _, found := p.store.Get(seenCacheKey); !found {
+				usage, _ := result.Tightest()
+				notification := fmt.Sprintf("🚫 HARD cap reached (%v req/%s) IP=%s ORIGIN=%s CHAIN=%s PROXY=%s ID=%v\n", usage.Rule.Limit, usage.Rule.Name, ipAddress, origin, c.name, c.hostnames(), sessionID)
 				fmt.Printf(notification)
 				p.sendNotification(notification)
 
 				// makes sure that notification is only sent once during rate limit cycle
-				p.cache.Set(seenCacheKey, true, time.Duration(expiration.Unix()-time.Now().Unix())*time.Second)
+				seenExpiration := result.RetryAfter
+				if seenExpiration < 1*time.Minute {
+					seenExpiration = 1 * time.Minute
+				}
+				p.store.Set(seenCacheKey, 1, seenExpiration)
 			}
-		}
 
-		// prevent request if hard cap rate limit reached for IP
-		if count >= p.hardCapIPRequestsPerMinute {
-			err := fmt.Sprintf("Too many requests: Rate limit exceeded. Try again in %.0fs", tryAgainInSeconds)
-			fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
-			http.Error(w, "", http.StatusTooManyRequests)
+			retryAfter := result.RetryAfter
+			if p.ipBanDuration > 0 {
+				p.store.Set(banCacheKey, 1, p.ipBanDuration)
+				fmt.Printf("BANNED ID=%v: IP=%s CHAIN=%s for %s\n", sessionID, ipAddress, c.name, p.ipBanDuration)
+				if p.ipBanDuration > retryAfter {
+					retryAfter = p.ipBanDuration
+					w.Header().Set("Retry-After", strconv.Itoa(seconds(retryAfter)))
+				}
+			}
+
+			err := fmt.Sprintf("Too many requests: Rate limit exceeded. Try again in %vs", seconds(retryAfter))
+			fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s COST=%v\n", sessionID, err, ipAddress, c.name, cost)
+			writeRateLimitError(w, rpcRequests, isBatch, retryAfter)
 			return
 		}
-
-		count++
-		p.cache.Set(rateLimitCacheKey, count, 1*time.Minute)
 	}
 
 	// check base64 encoded bearer token if auth check enabled
-	if p.authorizationSecret != "" {
-		reqToken := r.Header.Get("Authorization")
-		splitToken := strings.Split(reqToken, "Bearer")
-		if (len(splitToken)) != 2 {
-			err := errors.New("Unauthorized: Auth token is required")
-			fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
-			http.Error(w, "", http.StatusUnauthorized)
-			return
-		}
-
-		reqToken = strings.TrimSpace(splitToken[1])
-		decoded, err := base64.StdEncoding.DecodeString(reqToken)
-		if err != nil {
-			fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
-			http.Error(w, "", http.StatusUnauthorized)
-			return
-		}
-
-		decodedToken := string(decoded)
-		if p.authorizationSecret != decodedToken {
-			err := errors.New("Unauthorized: Invalid auth token")
-			fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
+	if c.authorizationSecret != "" {
+		if err := checkAuthorization(r, c.authorizationSecret); err != nil {
+			fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", sessionID, err, ipAddress, c.name)
 			http.Error(w, "", http.StatusUnauthorized)
 			return
 		}
 	}
 
-	bodyBuf, _ := ioutil.ReadAll(r.Body)
-
-	// make copies
-	bodyRdr1 := ioutil.NopCloser(bytes.NewBuffer(bodyBuf))
-	bodyRdr2 := ioutil.NopCloser(bytes.NewBuffer(bodyBuf))
-
-	requestBody, err := ioutil.ReadAll(bodyRdr1)
-	if err != nil {
-		fmt.Printf("ERROR ID=%v: %s %s\n", sessionID, err, ipAddress)
-		http.Error(w, "", http.StatusInternalServerError)
-		return
-	}
-
 	if p.logLevel == "debug" {
-		fmt.Printf("REQUEST ID=%v: %s [%s] %s %s %s %s\n", sessionID, ipAddress, time.Now().String(), r.Method, r.URL.String(), r.UserAgent(), string(requestBody))
+		fmt.Printf("REQUEST ID=%v: %s [%s] %s %s %s CHAIN=%s %s\n", sessionID, ipAddress, time.Now().String(), r.Method, r.URL.String(), r.UserAgent(), c.name, string(bodyBuf))
 	}
 
 	if r.Method == "OPTIONS" {
@@ -289,106 +558,173 @@ func (p *Proxy) ProxyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method != p.proxyMethod {
+	if r.Method != c.proxyMethod {
 		http.Error(w, "Not supported", http.StatusNotFound)
 		return
 	}
 
-	req, err := http.NewRequest(p.proxyMethod, p.proxyURL.String(), bodyRdr2)
+	var resp *proxyResponse
+	if parseErr != nil || len(rpcRequests) == 0 {
+		resp, err = invalidRequestResponse(s, bodyBuf, parseErr)
+	} else {
+		resp, err = p.handleRPC(s, bodyBuf, rpcRequests, isBatch)
+	}
 	if err != nil {
-		fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
+		fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s\n", sessionID, err, ipAddress, c.name)
 		http.Error(w, "", http.StatusInternalServerError)
 		return
 	}
 
-	// Close request after sending request and reading response
-	req.Close = true
-	defer req.Body.Close()
-
-	// copy headers to request
-	for k, v := range r.Header {
-		req.Header.Set(k, v[0])
+	for k, v := range resp.header {
+		w.Header().Set(k, v[0])
 	}
 
-	// Connection header informs server that client wants to close connection after response.
-	req.Header.Set("Connection", "close")
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Del("Host")
+	// the upstream's own rate limit headers don't apply to the client
+	if rateLimitResult != nil {
+		setRateLimitHeaders(w.Header(), rateLimitResult)
+	}
 
-	// setting the content length disables chunked transfer encoding,
-	// which is required to make proxy work with Alchemy
-	req.ContentLength = int64(len(requestBody))
+	w.Header().Del("Access-Control-Allow-Credentials")
+	w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization,Accept,Origin,DNT,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Content-Range,Range")
+	w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS,PUT,DELETE,PATCH")
 
 	if p.logLevel == "debug" {
-		httpMsg, err := httputil.DumpRequestOut(req, true)
-		if err != nil {
-			fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
-			http.Error(w, "", http.StatusInternalServerError)
-			return
-		}
+		fmt.Printf("RESPONSE ID=%v: %s [%s] %v %s %s CHAIN=%s %s\n", sessionID, ipAddress, time.Now().String(), resp.statusCode, r.Method, r.URL, c.name, resp.body)
+	}
+
+	w.WriteHeader(200)
+	w.Write(resp.body)
+}
 
-		fmt.Println(string(httpMsg))
+// checkAuthorization checks the request's base64 encoded bearer token
+// against the secret
+func checkAuthorization(r *http.Request, secret string) error {
+	token, err := bearerToken(r)
+	if err != nil {
+		return err
 	}
 
-	resp, err := p.httpClient.Do(req)
+	if token != secret {
+		return errors.New("Unauthorized: Invalid auth token")
+	}
+
+	return nil
+}
+
+// bearerToken returns the decoded base64 bearer token of the request
+func bearerToken(r *http.Request) (string, error) {
+	splitToken := strings.Split(r.Header.Get("Authorization"), "Bearer")
+	if (len(splitToken)) != 2 {
+		return "", errors.New("Unauthorized: Auth token is required")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(splitToken[1]))
 	if err != nil {
-		fmt.Printf("ERROR ID=%v: %s %s\n", sessionID, err, ipAddress)
-		http.Error(w, "", http.StatusInternalServerError)
-		return
+		return "", err
 	}
 
-	// re-use connection
-	defer resp.Body.Close()
+	return string(decoded), nil
+}
 
-	// response body
-	body, err := ioutil.ReadAll(resp.Body)
+// Handler prepares the proxy to serve requests and returns its routes, for
+// serving the proxy from another server or wrapping it in middleware. It
+// doesn't start the background loops that Start does.
+func (p *Proxy) Handler() (http.Handler, error) {
+	httpClient, err := p.createHTTPClient()
 	if err != nil {
-		fmt.Printf("ERROR ID=%v: %s IP=%s\n", sessionID, err, ipAddress)
-		http.Error(w, "", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
-	for k, v := range resp.Header {
-		w.Header().Set(k, v[0])
+	p.httpClient = httpClient
+
+	// refuse to serve a chain from an upstream on a different network
+	if err := p.verifyChainIDs(); err != nil {
+		return nil, err
 	}
 
-	w.Header().Del("Access-Control-Allow-Credentials")
-	w.Header().Set("Access-Control-Allow-Origin", req.Header.Get("Origin"))
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization,Accept,Origin,DNT,X-CustomHeader,Keep-Alive,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Content-Range,Range")
-	w.Header().Set("Access-Control-Allow-Methods", "GET,POST,OPTIONS,PUT,DELETE,PATCH")
+	if p.tracksHeads() {
+		p.updateHeads()
+	}
 
-	if p.logLevel == "debug" {
-		fmt.Printf("RESPONSE ID=%v: %s [%s] %v %s %s %s\n", sessionID, ipAddress, time.Now().String(), resp.StatusCode, r.Method, r.URL, body)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", p.PingHandler)
+	mux.HandleFunc("/health", p.HealthCheckHandler)
+	mux.HandleFunc("/metrics", p.metrics.Handler)
+	mux.HandleFunc("/status", p.StatusHandler)
+	// the journal holds client IPs, so it's only served to admins
+	if p.adminSecret != "" {
+		mux.HandleFunc("/transactions", p.TransactionsHandler)
 	}
+	mux.HandleFunc("/", p.ProxyHandler)
 
-	w.WriteHeader(200)
-	w.Write(body)
+	return mux, nil
 }
 
 // Start ...
 func (p *Proxy) Start() error {
-	httpClient, err := p.createHTTPClient()
+	handler, err := p.Handler()
 	if err != nil {
 		return err
 	}
 
-	p.httpClient = httpClient
-
 	host := fmt.Sprintf("0.0.0.0:%v", p.port)
-	http.HandleFunc("/ping", p.PingHandler)
-	http.HandleFunc("/health", p.HealthCheckHandler)
-	http.HandleFunc("/", p.ProxyHandler)
 
-	fmt.Printf("Proxying %s %s\n", p.proxyMethod, p.proxyURL.String())
+	for _, c := range p.chains {
+		for _, u := range c.upstreams {
+			fmt.Printf("Proxying %s %s %s\n", c.path, c.proxyMethod, u.url.String())
+		}
+		fmt.Printf("Soft cap requests per minute for IP on %s: %v\n", c.name, c.softCapIPRequestsPerMinute)
+		fmt.Printf("Hard cap requests per minute for IP on %s: %v\n", c.name, c.hardCapIPRequestsPerMinute)
+		fmt.Printf("Rate limit algorithm on %s: %s\n", c.name, c.rateLimitAlgorithm)
+		fmt.Printf("Load balancing on %s: %s\n", c.name, c.balancer.strategy)
+		if c.broadcast {
+			for _, u := range c.broadcastUpstreams {
+				fmt.Printf("Broadcasting transactions on %s to %s\n", c.name, u.url.String())
+			}
+		}
+		if c.hasArchive {
+			fmt.Printf("Archive upstreams on %s serve blocks older than: %v\n", c.name, c.archiveBlocks)
+		}
+		if c.chainID != 0 {
+			fmt.Printf("Expected chain ID on %s: %v\n", c.name, c.chainID)
+		}
+	}
 
 	fmt.Printf("Listening on port %v\n", p.port)
 	fmt.Printf("Leaky bucket limit per second: %v\n", p.leakyBucketLimitPerSecond)
-	fmt.Printf("Soft cap requests per minute for IP: %v\n", p.softCapIPRequestsPerMinute)
-	fmt.Printf("Hard cap requests per minute for IP: %v\n", p.hardCapIPRequestsPerMinute)
+	if p.ipBanDuration > 0 {
+		fmt.Printf("Ban duration for IP on hard cap: %s\n", p.ipBanDuration)
+	}
 	if p.logLevel != "" {
 		fmt.Printf("Log Level: %s\n", p.logLevel)
 	}
-	return http.ListenAndServe(host, nil)
+
+	if p.snapshotPath != "" && p.snapshotInterval > 0 {
+		go p.snapshotLoop()
+	}
+
+	if p.chainIDCheckInterval > 0 {
+		go p.chainIDLoop()
+	}
+
+	if p.tracksHeads() {
+		go p.headLoop()
+	}
+
+	if p.recorder != nil {
+		fmt.Println("Recording upstream requests and responses")
+	}
+	if p.replayer != nil {
+		fmt.Printf("Replaying %v recorded responses instead of contacting upstreams\n", p.replayer.Len())
+	}
+
+	if p.journal != nil {
+		fmt.Printf("Journaling transactions, checking receipts every %s\n", p.journalCheckInterval)
+		go p.journalLoop()
+	}
+
+	return http.ListenAndServe(host, handler)
 }
 
 func (p *Proxy) createHTTPClient() (*http.Client, error) {
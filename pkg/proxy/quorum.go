@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// quorumAlertInterval is how long a disagreement on a method is alerted
+// only once
+const quorumAlertInterval = 10 * time.Minute
+
+// quorumPolicy requires upstreams to agree on the responses to methods
+type quorumPolicy struct {
+	// methods are the number of upstreams that must agree per method
+	methods map[string]int
+	timeout time.Duration
+}
+
+// newQuorumPolicy returns the chain's quorum policy, or nil if no method
+// requires a quorum
+func newQuorumPolicy(config *Config, chainConfig *ChainConfig, upstreams int) (*quorumPolicy, error) {
+	methods := make(map[string]int)
+	for method, quorum := range config.QuorumMethods {
+		methods[method] = quorum
+	}
+	for method, quorum := range chainConfig.QuorumMethods {
+		methods[method] = quorum
+	}
+	if len(methods) == 0 {
+		return nil, nil
+	}
+
+	for method, quorum := range methods {
+		if !readMethods[method] {
+			return nil, fmt.Errorf("method %s can't require a quorum", method)
+		}
+		if quorum < 2 || quorum > upstreams {
+			return nil, fmt.Errorf("invalid quorum %v for %s with %v upstreams", quorum, method, upstreams)
+		}
+	}
+
+	timeout := time.Duration(chainConfig.QuorumTimeout)
+	if timeout == 0 {
+		timeout = time.Duration(config.QuorumTimeout)
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &quorumPolicy{
+		methods: methods,
+		timeout: timeout,
+	}, nil
+}
+
+// required returns true if the request must be answered by a quorum
+func (qp *quorumPolicy) required(req *jsonrpc.Request) bool {
+	if qp == nil || req.IsNotification() {
+		return false
+	}
+
+	_, ok := qp.methods[req.Method]
+	return ok
+}
+
+// quorumResponse sends requests for methods that require a quorum to every
+// available upstream and returns the response that enough of them agree on,
+// or an error if they don't. Disagreements are alerted even when the quorum
+// is reached. It returns nil for other requests.
+func (p *Proxy) quorumResponse(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	c := s.chain
+	if !c.quorum.required(req) {
+		return nil
+	}
+	quorum := c.quorum.methods[req.Method]
+
+	reqs := []*jsonrpc.Request{req}
+	upstreams := c.routeHeads(reqs, c.routeArchive(reqs, c.balancer.order(c.upstreams)))
+	if len(upstreams) < quorum {
+		p.quorumCounter.Counter(c.name, req.Method, "unavailable").Inc()
+		msg := fmt.Sprintf("Quorum of %v upstreams required but %v available", quorum, len(upstreams))
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeQuorumNotReached, msg, nil)
+	}
+
+	// the request was charged once before it got here, but each upstream
+	// asked costs as much
+	if resp := p.chargeExtra(s, req, len(upstreams)-1, "quorum requests"); resp != nil {
+		return resp
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.quorum.timeout)
+	defer cancel()
+
+	votes := make(chan *vote, len(upstreams))
+	for _, u := range upstreams {
+		go func(u *upstream) {
+			resp, err := p.forwardTo(ctx, s, u, body)
+			if err != nil {
+				votes <- &vote{u.name, nil, err}
+				return
+			}
+
+			responses, _, err := jsonrpc.ParseResponses(resp.body)
+			if err != nil || len(responses) != 1 {
+				votes <- &vote{u.name, nil, fmt.Errorf("invalid response (status %v)", resp.statusCode)}
+				return
+			}
+
+			votes <- &vote{u.name, responses[0], nil}
+		}(u)
+	}
+
+	all := make([]*vote, len(upstreams))
+	for i := range upstreams {
+		all[i] = <-votes
+	}
+	t := tallyVotes(all, quorum)
+
+	if len(t.groups) > 1 {
+		p.alertDisagreement(s, req, quorum, t.groups, t.reached)
+	}
+
+	if !t.reached {
+		p.quorumCounter.Counter(c.name, req.Method, "failed").Inc()
+		msg := fmt.Sprintf("Quorum of %v upstreams not reached", quorum)
+		fmt.Printf("QUORUM ERROR ID=%v: %s METHOD=%s IP=%s CHAIN=%s VOTES=%s ERRORS=%s\n", s.id, msg, req.Method, s.ipAddress, c.name, formatVotes(t.groups), strings.Join(t.errs, "; "))
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeQuorumNotReached, msg, nil)
+	}
+
+	if len(t.groups) > 1 {
+		p.quorumCounter.Counter(c.name, req.Method, "disagreed").Inc()
+	} else {
+		p.quorumCounter.Counter(c.name, req.Method, "agreed").Inc()
+	}
+
+	return jsonrpc.NewResultResponse(req.ID, t.result)
+}
+
+// vote is an upstream's response to a request that requires a quorum
+type vote struct {
+	upstream string
+	resp     *jsonrpc.Response
+	err      error
+}
+
+// tally is the outcome of the votes on a request
+type tally struct {
+	// groups are the upstreams grouped by the normalized result they
+	// returned
+	groups map[string][]string
+	errs   []string
+	// reached is true if enough upstreams agree on result
+	reached bool
+	result  json.RawMessage
+}
+
+// tallyVotes groups the votes by result and finds the result at least
+// quorum upstreams agree on. Errors don't count towards a quorum.
+func tallyVotes(votes []*vote, quorum int) *tally {
+	t := &tally{groups: make(map[string][]string)}
+	results := make(map[string]json.RawMessage)
+	for _, v := range votes {
+		switch {
+		case v.err != nil:
+			t.errs = append(t.errs, fmt.Sprintf("%s: %s", v.upstream, v.err))
+		case v.resp.Error != nil:
+			t.errs = append(t.errs, fmt.Sprintf("%s: %s", v.upstream, v.resp.Error.Message))
+		default:
+			key := string(normalizeJSON(v.resp.Result))
+			t.groups[key] = append(t.groups[key], v.upstream)
+			results[key] = v.resp.Result
+		}
+	}
+
+	// with a quorum of half the upstreams or less two results can both
+	// reach it, which is no agreement at all
+	var agreed string
+	tied := false
+	for key, names := range t.groups {
+		if len(names) < quorum {
+			continue
+		}
+		switch {
+		case !t.reached || len(names) > len(t.groups[agreed]):
+			agreed, t.reached, tied = key, true, false
+		case len(names) == len(t.groups[agreed]):
+			tied = true
+		}
+	}
+	if tied {
+		t.reached = false
+		return t
+	}
+
+	t.result = results[agreed]
+	return t
+}
+
+// alertDisagreement notifies that upstreams returned different results for
+// the request, once per chain and method within the alert interval
+func (p *Proxy) alertDisagreement(s *session, req *jsonrpc.Request, quorum int, groups map[string][]string, reached bool) {
+	c := s.chain
+	seenCacheKey := fmt.Sprintf("quorum:%s:%s", c.name, req.Method)
+	if _, _, found := p.store.Get(seenCacheKey); found {
+		return
+	}
+	p.store.Set(seenCacheKey, 1, quorumAlertInterval)
+
+	outcome := "quorum not reached"
+	if reached {
+		outcome = "quorum reached"
+	}
+
+	notification := fmt.Sprintf("🔀 Upstreams disagree, %s (%v required): METHOD=%s PARAMS=%s VOTES=%s CHAIN=%s ID=%v\n", outcome, quorum, req.Method, truncate(req.Params), formatVotes(groups), c.name, s.id)
+	fmt.Print(notification)
+	p.sendNotification(notification)
+}
+
+// formatVotes lists the upstreams behind each result, largest group first
+func formatVotes(groups map[string][]string) string {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(groups[keys[i]]) != len(groups[keys[j]]) {
+			return len(groups[keys[i]]) > len(groups[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	votes := make([]string, len(keys))
+	for i, key := range keys {
+		votes[i] = fmt.Sprintf("%s=%s", strings.Join(groups[key], ","), truncate([]byte(key)))
+	}
+
+	return strings.Join(votes, " ")
+}
+
+// ParseMethodQuorums parses a comma separated list of method=quorum pairs,
+// e.g. "eth_getTransactionReceipt=2,eth_call=3"
+func ParseMethodQuorums(s string) (map[string]int, error) {
+	quorums := make(map[string]int)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid method quorum %q", pair)
+		}
+
+		quorum, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || quorum < 2 {
+			return nil, fmt.Errorf("invalid method quorum %q", pair)
+		}
+
+		quorums[strings.TrimSpace(parts[0])] = quorum
+	}
+
+	return quorums, nil
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestParseMethodQuorums(t *testing.T) {
+	quorums, err := ParseMethodQuorums("eth_getTransactionReceipt=2, eth_call=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quorums["eth_getTransactionReceipt"] != 2 || quorums["eth_call"] != 3 {
+		t.Fatalf("unexpected quorums %v", quorums)
+	}
+
+	for _, invalid := range []string{"eth_call", "eth_call=x", "eth_call=1"} {
+		if _, err := ParseMethodQuorums(invalid); err == nil {
+			t.Fatalf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestNewQuorumPolicy(t *testing.T) {
+	config := &Config{QuorumMethods: map[string]int{"eth_call": 2}}
+	policy, err := newQuorumPolicy(config, &ChainConfig{QuorumMethods: map[string]int{"eth_getBalance": 3}}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.methods["eth_call"] != 2 || policy.methods["eth_getBalance"] != 3 {
+		t.Fatalf("unexpected methods %v", policy.methods)
+	}
+
+	if _, err := newQuorumPolicy(config, &ChainConfig{}, 1); err == nil {
+		t.Fatal("expected an error for a quorum larger than the upstreams")
+	}
+
+	config = &Config{QuorumMethods: map[string]int{"eth_sendRawTransaction": 2}}
+	if _, err := newQuorumPolicy(config, &ChainConfig{}, 3); err == nil {
+		t.Fatal("expected an error for a transaction method")
+	}
+
+	if policy, err := newQuorumPolicy(&Config{}, &ChainConfig{}, 3); err != nil || policy != nil {
+		t.Fatal("expected no policy")
+	}
+}
+
+func TestTallyVotes(t *testing.T) {
+	result := func(upstream, value string) *vote {
+		return &vote{upstream, jsonrpc.NewResultResponse(json.RawMessage("1"), json.RawMessage(value)), nil}
+	}
+	rpcError := func(upstream string) *vote {
+		return &vote{upstream, jsonrpc.NewErrorResponse(json.RawMessage("1"), -32000, "header not found", nil), nil}
+	}
+	failed := func(upstream string) *vote {
+		return &vote{upstream, nil, errors.New("timeout")}
+	}
+
+	tests := []struct {
+		name    string
+		votes   []*vote
+		quorum  int
+		reached bool
+		result  string
+	}{
+		{"agreed", []*vote{result("a", `"0x1"`), result("b", `"0x1"`), result("c", `"0x1"`)}, 2, true, `"0x1"`},
+		{"agreed despite a disagreement", []*vote{result("a", `{"x":"0xAB"}`), result("b", `{ "x": "0xab" }`), result("c", `{"x":"0x2"}`)}, 2, true, `{"x":"0xab"}`},
+		{"larger group wins", []*vote{result("a", `"0x1"`), result("b", `"0x2"`), result("c", `"0x2"`), result("d", `"0x2"`), result("e", `"0x1"`)}, 2, true, `"0x2"`},
+		{"tied", []*vote{result("a", `"0x1"`), result("b", `"0x1"`), result("c", `"0x2"`), result("d", `"0x2"`)}, 2, false, ""},
+		{"errors don't count", []*vote{result("a", `"0x1"`), rpcError("b"), rpcError("c")}, 2, false, ""},
+		{"failures don't count", []*vote{result("a", `"0x1"`), failed("b"), result("c", `"0x1"`)}, 2, true, `"0x1"`},
+		{"short of the quorum", []*vote{result("a", `"0x1"`), result("b", `"0x1"`), failed("c")}, 3, false, ""},
+	}
+
+	for _, test := range tests {
+		tally := tallyVotes(test.votes, test.quorum)
+		// agreeing results can differ in formatting
+		if tally.reached != test.reached || (test.reached && string(normalizeJSON(tally.result)) != string(normalizeJSON(json.RawMessage(test.result)))) {
+			t.Fatalf("%s: expected reached %v with %s, got %v with %s", test.name, test.reached, test.result, tally.reached, tally.result)
+		}
+	}
+
+	tally := tallyVotes([]*vote{result("a", `"0x1"`), rpcError("b"), failed("c")}, 2)
+	if len(tally.errs) != 2 || len(tally.groups) != 1 {
+		t.Fatalf("expected 2 errors and 1 group, got %v and %v", tally.errs, tally.groups)
+	}
+}
@@ -0,0 +1,162 @@
+package proxy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestQuorum(t *testing.T) {
+	servers := []*proxytest.Server{proxytest.NewServer(), proxytest.NewServer(), proxytest.NewServer()}
+	for _, s := range servers {
+		defer s.Close()
+		s.SetResult("eth_call", "0x01")
+	}
+
+	config := proxytest.Config(servers...)
+	config.QuorumMethods = map[string]int{"eth_call": 2}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	call := map[string]string{"to": "0x3535353535353535353535353535353535353535"}
+	servers[2].SetResult("eth_call", "0x02")
+	expectResult(t, p.URL, `"0x01"`, "eth_call", call, "latest")
+	for _, s := range servers {
+		if s.Count("eth_call") != 1 {
+			t.Fatal("expected every upstream to be asked")
+		}
+	}
+
+	servers[1].SetError("eth_call", -32000, "header not found")
+	resp, err := p.Call("eth_call", call, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != jsonrpc.CodeQuorumNotReached {
+		t.Fatalf("expected a quorum error, got %s %+v", resp.Result, resp.Error)
+	}
+}
+
+func TestQuorumBatch(t *testing.T) {
+	servers := []*proxytest.Server{proxytest.NewServer(), proxytest.NewServer()}
+	for _, s := range servers {
+		defer s.Close()
+		s.SetResult("eth_call", "0x01")
+		s.SetLatency("eth_call", 300*time.Millisecond)
+	}
+
+	config := proxytest.Config(servers...)
+	config.QuorumMethods = map[string]int{"eth_call": 2}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	var reqs []*jsonrpc.Request
+	for i := 1; i <= 3; i++ {
+		reqs = append(reqs, &jsonrpc.Request{JSONRPC: jsonrpc.Version, ID: json.RawMessage(strconv.Itoa(i)), Method: "eth_call", Params: json.RawMessage(`[{"to":"0x3535353535353535353535353535353535353535"},"latest"]`)})
+	}
+	body, _ := json.Marshal(reqs)
+
+	// one after another the quorum requests would take 900ms
+	start := time.Now()
+	resp, err := http.Post(p.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Fatalf("expected the quorum requests to be sent together, took %v", elapsed)
+	}
+
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	resps, _, err := jsonrpc.ParseResponses(respBody)
+	if err != nil || len(resps) != 3 {
+		t.Fatalf("expected 3 responses, got %s %v", respBody, err)
+	}
+	for _, r := range resps {
+		if r.Error != nil || string(r.Result) != `"0x01"` {
+			t.Fatalf("expected the agreed result, got %s %+v", r.Result, r.Error)
+		}
+	}
+}
+
+func TestChargesQuorumUpstreams(t *testing.T) {
+	servers := []*proxytest.Server{proxytest.NewServer(), proxytest.NewServer()}
+	for _, s := range servers {
+		defer s.Close()
+		s.SetResult("eth_call", "0x01")
+	}
+
+	config := proxytest.Config(servers...)
+	config.QuorumMethods = map[string]int{"eth_call": 2}
+	config.MethodCosts = map[string]int{"eth_call": 10}
+	config.HardCapIPRequestsPerMinute = 30
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	// asking both upstreams costs 20 of the 30 per minute, so the next
+	// call's second upstream is over the limit
+	call := map[string]string{"to": "0x3535353535353535353535353535353535353535"}
+	expectResult(t, p.URL, `"0x01"`, "eth_call", call, "latest")
+	if resp, err := p.Call("eth_call", call, "latest"); err != nil || resp.Error == nil || resp.Error.Code != jsonrpc.CodeLimitExceeded {
+		t.Fatalf("expected a rate limit error, got %+v %v", resp, err)
+	}
+	for _, s := range servers {
+		if s.Count("eth_call") != 1 {
+			t.Fatalf("expected one request per upstream, got %v", s.Count("eth_call"))
+		}
+	}
+}
+
+func TestQuorumMalformedReply(t *testing.T) {
+	servers := []*proxytest.Server{proxytest.NewServer(), proxytest.NewServer()}
+	for _, s := range servers {
+		defer s.Close()
+		s.SetResult("eth_call", "0x01")
+	}
+
+	// an empty batch answering a single request counts as an error
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer empty.Close()
+
+	config := proxytest.Config(servers...)
+	config.Upstreams = append(config.Upstreams, &proxy.UpstreamConfig{Name: "empty", URL: empty.URL})
+	config.QuorumMethods = map[string]int{"eth_call": 3}
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	call := map[string]string{"to": "0x3535353535353535353535353535353535353535"}
+	resp, err := p.Call("eth_call", call, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil || resp.Error.Code != jsonrpc.CodeQuorumNotReached {
+		t.Fatalf("expected a quorum error, got %s %+v", resp.Result, resp.Error)
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// minSecretLength is the shortest upstream URL path segment treated as an
+// API key, e.g. the key in https://mainnet.infura.io/v3/<key>
+const minSecretLength = 16
+
+// record writes the upstream's answers to the recording. Bodies that
+// aren't JSON-RPC are skipped.
+func (p *Proxy) record(c *chain, u *upstream, reqBody, respBody []byte) {
+	if err := p.recorder.Record(c.name, u.name, reqBody, respBody); err != nil && p.logLevel == "debug" {
+		fmt.Printf("RECORD ERROR %s CHAIN=%s UPSTREAM=%s\n", err, c.name, u.name)
+	}
+}
+
+// replay answers the request body with the recorded responses
+func (p *Proxy) replay(c *chain, body []byte) (*proxyResponse, error) {
+	respBody, err := p.replayer.Respond(c.name, body)
+	if err != nil {
+		return nil, fmt.Errorf("replay: %s", err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+
+	return &proxyResponse{
+		statusCode: http.StatusOK,
+		header:     header,
+		body:       respBody,
+	}, nil
+}
+
+// recordSecrets returns the values redacted from recordings: the configured
+// ones, the auth secrets, and the credentials and API keys in upstream URLs,
+// which upstreams sometimes echo back in errors.
+func recordSecrets(config *Config, chains []*chain) []string {
+	secrets := append([]string{config.AuthorizationSecret, config.AdminSecret}, config.RecordRedact...)
+
+	for _, c := range chains {
+		secrets = append(secrets, c.authorizationSecret)
+
+		upstreams := append(append([]*upstream{}, c.upstreams...), c.broadcastUpstreams...)
+		if c.shadow != nil {
+			upstreams = append(upstreams, c.shadow.upstream)
+		}
+		for _, u := range upstreams {
+			if password, ok := u.url.User.Password(); ok {
+				secrets = append(secrets, password)
+			}
+
+			for _, values := range u.url.Query() {
+				secrets = append(secrets, values...)
+			}
+
+			for _, segment := range strings.Split(u.url.Path, "/") {
+				if len(segment) >= minSecretLength {
+					secrets = append(secrets, segment)
+				}
+			}
+		}
+	}
+
+	return secrets
+}
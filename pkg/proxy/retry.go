@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// errCircuitOpen is returned when an upstream's circuit rejects the request
+var errCircuitOpen = errors.New("circuit open")
+
+// defaultRetryErrors are JSON-RPC error messages meaning the node lags
+// behind or pruned the state, so another attempt may succeed
+var defaultRetryErrors = []string{
+	"header not found",
+	"unknown block",
+	"missing trie node",
+}
+
+// defaultRetryAttempts is the total number of attempts unless configured,
+// so a single upstream is retried too
+const defaultRetryAttempts = 3
+
+// retryPolicy decides which failed upstream requests are attempted again
+type retryPolicy struct {
+	// attempts is the total number of attempts
+	attempts int
+	backoff  time.Duration
+	// budget is the time after which no new attempt is started
+	budget time.Duration
+	errors []string
+	// unsafeMethods are retried even though they aren't read methods
+	unsafeMethods map[string]bool
+}
+
+// newRetryPolicy returns the retry policy with defaults
+func newRetryPolicy(config *Config) (*retryPolicy, error) {
+	if config.RetryAttempts < 0 {
+		return nil, fmt.Errorf("invalid retry attempts %v", config.RetryAttempts)
+	}
+
+	attempts := config.RetryAttempts
+	if attempts == 0 {
+		attempts = defaultRetryAttempts
+	}
+
+	backoff := time.Duration(config.RetryBackoff)
+	if backoff == 0 {
+		backoff = 50 * time.Millisecond
+	}
+
+	budget := time.Duration(config.RetryBudget)
+	if budget == 0 {
+		budget = 10 * time.Second
+	}
+
+	retryErrors := config.RetryErrors
+	if retryErrors == nil {
+		retryErrors = defaultRetryErrors
+	}
+
+	unsafeMethods := make(map[string]bool)
+	for _, method := range config.RetryUnsafeMethods {
+		unsafeMethods[method] = true
+	}
+
+	return &retryPolicy{
+		attempts:      attempts,
+		backoff:       backoff,
+		budget:        budget,
+		errors:        retryErrors,
+		unsafeMethods: unsafeMethods,
+	}, nil
+}
+
+// safe returns true if the requests may be sent again after a failure that
+// they might have reached the upstream. Only read methods are, unless
+// configured otherwise, since anything else may change state on every call.
+func (r *retryPolicy) safe(reqs []*jsonrpc.Request) bool {
+	// a body that isn't JSON-RPC can't be vouched for
+	if len(reqs) == 0 {
+		return false
+	}
+
+	for _, req := range reqs {
+		if !readMethods[req.Method] && !r.unsafeMethods[req.Method] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// delay returns the backoff before the given retry, with full jitter
+func (r *retryPolicy) delay(retry int) time.Duration {
+	if retry > 10 {
+		retry = 10
+	}
+
+	max := r.backoff << uint(retry-1)
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// wait sleeps for the delay, returning false if the client went away first
+func (r *retryPolicy) wait(s *session, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.r.Context().Done():
+		return false
+	}
+}
+
+// responseReason returns why an upstream response should be retried, or an
+// empty string if it should be returned to the client
+func (r *retryPolicy) responseReason(resp *proxyResponse) string {
+	switch resp.statusCode {
+	case 429, 502, 503, 504:
+		return fmt.Sprintf("status %v", resp.statusCode)
+	}
+
+	responses, _, err := jsonrpc.ParseResponses(resp.body)
+	if err != nil {
+		return ""
+	}
+
+	for _, response := range responses {
+		if response.Error == nil {
+			continue
+		}
+
+		message := strings.ToLower(response.Error.Message)
+		for _, retryError := range r.errors {
+			if strings.Contains(message, strings.ToLower(retryError)) {
+				return retryError
+			}
+		}
+	}
+
+	return ""
+}
+
+// neverSent returns true if the error proves no bytes of the request
+// reached the upstream, such as a refused connection
+func neverSent(err error) bool {
+	if errors.Is(err, errCircuitOpen) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
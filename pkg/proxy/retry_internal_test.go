@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	r, err := newRetryPolicy(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !r.safe([]*jsonrpc.Request{{Method: "eth_call"}, {Method: "eth_getBalance"}}) {
+		t.Fatal("expected reads to be safe")
+	}
+	if r.safe([]*jsonrpc.Request{{Method: "eth_call"}, {Method: "eth_sendRawTransaction"}}) {
+		t.Fatal("expected transaction to be unsafe")
+	}
+	for _, method := range []string{"personal_sendTransaction", "eth_sign", "admin_addPeer", "eth_getFilterChanges"} {
+		if r.safe([]*jsonrpc.Request{{Method: method}}) {
+			t.Fatalf("expected %s to be unsafe", method)
+		}
+	}
+
+	r, err = newRetryPolicy(&Config{RetryUnsafeMethods: []string{"eth_getFilterChanges"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.safe([]*jsonrpc.Request{{Method: "eth_getFilterChanges"}}) {
+		t.Fatal("expected a configured method to be retried")
+	}
+
+	if reason := r.responseReason(&proxyResponse{statusCode: 503}); reason == "" {
+		t.Fatal("expected 503 to be retried")
+	}
+	if reason := r.responseReason(&proxyResponse{statusCode: 200, body: []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"header not found"}}`)}); reason != "header not found" {
+		t.Fatalf("expected header not found, got %q", reason)
+	}
+	if reason := r.responseReason(&proxyResponse{statusCode: 200, body: []byte(`{"jsonrpc":"2.0","id":1,"error":{"code":3,"message":"execution reverted"}}`)}); reason != "" {
+		t.Fatalf("expected revert not to be retried, got %q", reason)
+	}
+}
+
+func TestNeverSent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	_, err = http.Post("http://"+addr, "application/json", nil)
+	if err == nil || !neverSent(err) {
+		t.Fatalf("expected refused connection to be never sent, got %v", err)
+	}
+
+	if !neverSent(errCircuitOpen) {
+		t.Fatal("expected open circuit to be never sent")
+	}
+}
@@ -0,0 +1,86 @@
+package proxy_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxytest"
+)
+
+func TestRetriesFailedUpstream(t *testing.T) {
+	first := proxytest.NewServer()
+	defer first.Close()
+	second := proxytest.NewServer()
+	defer second.Close()
+
+	first.FailNext(1, http.StatusServiceUnavailable)
+	second.SetBlockNumber(5)
+
+	p, err := proxytest.NewProxy(proxytest.Config(first, second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	expectResult(t, p.URL, `"0x5"`, "eth_blockNumber")
+	if first.Count("eth_blockNumber") != 1 || second.Count("eth_blockNumber") != 1 {
+		t.Fatalf("expected one request to each upstream, got %v and %v", first.Count("eth_blockNumber"), second.Count("eth_blockNumber"))
+	}
+}
+
+func TestRetriesSingleUpstream(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	s.SetBlockNumber(5)
+
+	p, err := proxytest.NewProxy(proxytest.Config(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	s.FailNext(2, http.StatusServiceUnavailable)
+	expectResult(t, p.URL, `"0x5"`, "eth_blockNumber")
+}
+
+func TestStopsRetryingWhenClientLeaves(t *testing.T) {
+	s := proxytest.NewServer()
+	defer s.Close()
+
+	config := proxytest.Config(s)
+	config.RetryBackoff = proxy.Duration(time.Hour)
+	config.RetryBudget = proxy.Duration(2 * time.Hour)
+
+	p, err := proxytest.NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	req := httptest.NewRequest(http.MethodPost, "/", body).WithContext(ctx)
+
+	// the backoff is jittered, so fail until the client leaves
+	s.FailNext(100, http.StatusServiceUnavailable)
+	done := make(chan struct{})
+	go func() {
+		p.ProxyHandler(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the retry wait to end with the request")
+	}
+}
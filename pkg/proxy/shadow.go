@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// maxShadowRequests is the number of mirrored requests in flight per chain.
+// Requests mirrored while the shadow upstream is this far behind are dropped.
+const maxShadowRequests = 64
+
+// shadowLogInterval is how often a mismatch is logged per chain and method
+const shadowLogInterval = time.Minute
+
+// shadowLogLength is how much of each response a mismatch log shows
+const shadowLogLength = 512
+
+// shadowPolicy mirrors read traffic to a candidate upstream and compares
+// its responses with the ones the client got
+type shadowPolicy struct {
+	upstream *upstream
+	// methods are the methods mirrored, every read method if empty
+	methods map[string]bool
+	timeout time.Duration
+	slots   chan struct{}
+
+	mu     sync.Mutex
+	logged map[string]time.Time
+}
+
+// newShadowPolicy returns the chain's shadow policy, or nil if it has no
+// shadow upstream
+func newShadowPolicy(config *Config, chainConfig *ChainConfig) (*shadowPolicy, error) {
+	upstreamConfig := chainConfig.ShadowUpstream
+	if upstreamConfig == nil {
+		upstreamConfig = config.ShadowUpstream
+	}
+	if upstreamConfig == nil || upstreamConfig.URL == "" {
+		return nil, nil
+	}
+
+	u, err := newUpstream(upstreamConfig)
+	if err != nil {
+		return nil, fmt.Errorf("shadow %s", err)
+	}
+
+	methodNames := chainConfig.ShadowMethods
+	if methodNames == nil {
+		methodNames = config.ShadowMethods
+	}
+
+	methods := make(map[string]bool, len(methodNames))
+	for _, method := range methodNames {
+		if !readMethods[method] {
+			return nil, fmt.Errorf("method %s can't be mirrored", method)
+		}
+		methods[method] = true
+	}
+
+	timeout := time.Duration(chainConfig.ShadowTimeout)
+	if timeout == 0 {
+		timeout = time.Duration(config.ShadowTimeout)
+	}
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &shadowPolicy{
+		upstream: u,
+		methods:  methods,
+		timeout:  timeout,
+		slots:    make(chan struct{}, maxShadowRequests),
+		logged:   make(map[string]time.Time),
+	}, nil
+}
+
+// mirrored returns true if every request should be sent to the shadow
+func (sp *shadowPolicy) mirrored(reqs []*jsonrpc.Request) bool {
+	if len(reqs) == 0 {
+		return false
+	}
+
+	for _, req := range reqs {
+		if req.IsNotification() || !readMethods[req.Method] {
+			return false
+		}
+		if len(sp.methods) > 0 && !sp.methods[req.Method] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// shouldLog returns true if a mismatch of the method hasn't been logged
+// within the log interval
+func (sp *shadowPolicy) shouldLog(method string) bool {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if time.Since(sp.logged[method]) < shadowLogInterval {
+		return false
+	}
+	sp.logged[method] = time.Now()
+
+	return true
+}
+
+// mirror sends a copy of the requests the upstream answered with resp to the
+// chain's shadow upstream in the background and compares the responses. The
+// client never waits on it, and copies are dropped if the shadow is too slow
+// to keep up.
+func (p *Proxy) mirror(s *session, reqs []*jsonrpc.Request, body []byte, resp *proxyResponse) {
+	sp := s.chain.shadow
+	if sp == nil || p.replayer != nil || resp.statusCode != http.StatusOK || !sp.mirrored(reqs) {
+		return
+	}
+
+	primary, _, err := jsonrpc.ParseResponses(resp.body)
+	if err != nil {
+		return
+	}
+
+	select {
+	case sp.slots <- struct{}{}:
+	default:
+		for _, req := range reqs {
+			p.shadowRequestsCounter.Counter(s.chain.name, req.Method, "dropped").Inc()
+		}
+		return
+	}
+
+	var header http.Header
+	if s.r != nil {
+		header = s.r.Header.Clone()
+	}
+
+	go func() {
+		defer func() { <-sp.slots }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), sp.timeout)
+		defer cancel()
+
+		// the shadow's responses aren't recorded, as replaying them would
+		// mix them up with the primary's
+		shadowResp, err := p.roundTrip(ctx, s.chain, sp.upstream, header, body)
+		if err == nil && shadowResp.statusCode != http.StatusOK {
+			err = fmt.Errorf("status %v", shadowResp.statusCode)
+		}
+
+		var candidate []*jsonrpc.Response
+		if err == nil {
+			candidate, _, err = jsonrpc.ParseResponses(shadowResp.body)
+		}
+		if err != nil {
+			for _, req := range reqs {
+				p.shadowRequestsCounter.Counter(s.chain.name, req.Method, "error").Inc()
+			}
+			if p.logLevel == "debug" {
+				fmt.Printf("SHADOW ERROR ID=%v: %s CHAIN=%s SHADOW=%s\n", s.id, err, s.chain.name, sp.upstream.name)
+			}
+			return
+		}
+
+		p.compareShadow(s, reqs, primary, candidate, resp.upstream)
+	}()
+}
+
+// compareShadow counts the shadow's responses that match the primary's and
+// logs a sample of the ones that don't
+func (p *Proxy) compareShadow(s *session, reqs []*jsonrpc.Request, primary, candidate []*jsonrpc.Response, u *upstream) {
+	sp := s.chain.shadow
+
+	primaryByID := make(map[string]*jsonrpc.Response, len(primary))
+	for _, resp := range primary {
+		primaryByID[jsonrpc.IDKey(resp.ID)] = resp
+	}
+
+	candidateByID := make(map[string]*jsonrpc.Response, len(candidate))
+	for _, resp := range candidate {
+		candidateByID[jsonrpc.IDKey(resp.ID)] = resp
+	}
+
+	for _, req := range reqs {
+		key := jsonrpc.IDKey(req.ID)
+		primaryResp, ok := primaryByID[key]
+		if !ok {
+			continue
+		}
+
+		candidateResp := candidateByID[key]
+		if candidateResp != nil && sameResponse(primaryResp, candidateResp) {
+			p.shadowRequestsCounter.Counter(s.chain.name, req.Method, "match").Inc()
+			continue
+		}
+
+		p.shadowRequestsCounter.Counter(s.chain.name, req.Method, "mismatch").Inc()
+		if !sp.shouldLog(req.Method) {
+			continue
+		}
+
+		upstreamName := ""
+		if u != nil {
+			upstreamName = u.name
+		}
+		fmt.Printf("SHADOW MISMATCH ID=%v: METHOD=%s PARAMS=%s CHAIN=%s UPSTREAM=%s SHADOW=%s PRIMARY=%s CANDIDATE=%s\n", s.id, req.Method, truncate(req.Params), s.chain.name, upstreamName, sp.upstream.name, truncate(responseJSON(primaryResp)), truncate(responseJSON(candidateResp)))
+	}
+}
+
+// sameResponse returns true if both responses have the same result, or
+// both are errors. Error messages vary between node clients, so only the
+// presence of an error is compared.
+func sameResponse(a, b *jsonrpc.Response) bool {
+	if a.Error != nil || b.Error != nil {
+		return a.Error != nil && b.Error != nil
+	}
+
+	return bytes.Equal(normalizeJSON(a.Result), normalizeJSON(b.Result))
+}
+
+// normalizeJSON returns the JSON with object keys sorted, whitespace removed
+// and hex strings lowercased, so equal values from different upstreams
+// compare equal
+func normalizeJSON(raw json.RawMessage) []byte {
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return raw
+	}
+
+	normalized, err := json.Marshal(lowercaseHex(v))
+	if err != nil {
+		return raw
+	}
+
+	return normalized
+}
+
+// lowercaseHex lowercases the 0x prefixed strings in the value, since
+// upstreams differ on checksummed addresses
+func lowercaseHex(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if strings.HasPrefix(v, "0x") {
+			return strings.ToLower(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = lowercaseHex(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = lowercaseHex(v[k])
+		}
+	}
+
+	return v
+}
+
+func responseJSON(resp *jsonrpc.Response) []byte {
+	if resp == nil {
+		return []byte("none")
+	}
+
+	if resp.Error != nil {
+		buf, _ := json.Marshal(resp.Error)
+		return buf
+	}
+
+	return resp.Result
+}
+
+func truncate(buf []byte) string {
+	if len(buf) > shadowLogLength {
+		return string(buf[:shadowLogLength]) + "..."
+	}
+
+	return string(buf)
+}
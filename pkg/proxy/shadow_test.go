@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/metrics"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/recording"
+)
+
+func TestSameResponse(t *testing.T) {
+	tests := []struct {
+		a, b *jsonrpc.Response
+		same bool
+	}{
+		{
+			jsonrpc.NewResultResponse(json.RawMessage("1"), json.RawMessage(`{"from":"0xAbC","blockNumber":"0x1"}`)),
+			jsonrpc.NewResultResponse(json.RawMessage(`"x"`), json.RawMessage(`{ "blockNumber": "0x1", "from": "0xabc" }`)),
+			true,
+		},
+		{
+			jsonrpc.NewResultResponse(json.RawMessage("1"), json.RawMessage(`"0x1"`)),
+			jsonrpc.NewResultResponse(json.RawMessage("1"), json.RawMessage(`"0x2"`)),
+			false,
+		},
+		{
+			jsonrpc.NewErrorResponse(json.RawMessage("1"), 3, "execution reverted", nil),
+			jsonrpc.NewErrorResponse(json.RawMessage("1"), -32000, "reverted", nil),
+			true,
+		},
+		{
+			jsonrpc.NewErrorResponse(json.RawMessage("1"), 3, "execution reverted", nil),
+			jsonrpc.NewResultResponse(json.RawMessage("1"), json.RawMessage(`"0x"`)),
+			false,
+		},
+	}
+
+	for i, test := range tests {
+		if sameResponse(test.a, test.b) != test.same {
+			t.Fatalf("test %v: expected same %v", i, test.same)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"jsonrpc":"2.0","id":2,"result":"0x2"},{"jsonrpc":"2.0","id":1,"result":"0x1"}]`))
+	}))
+	defer server.Close()
+
+	sp, err := newShadowPolicy(&Config{ShadowUpstream: &UpstreamConfig{Name: "candidate", URL: server.URL}}, &ChainConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordPath := filepath.Join(dir, "recording.jsonl")
+	recorder, err := recording.NewRecorder(recordPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := metrics.NewRegistry()
+	p := &Proxy{
+		httpClient:            &http.Client{},
+		recorder:              recorder,
+		shadowRequestsCounter: registry.NewCounterVec("goproxy_shadow_requests_total", "", "chain", "method", "result"),
+	}
+
+	r, _ := http.NewRequest("POST", "/", nil)
+	s := &session{id: 1, chain: &chain{name: "mainnet", proxyMethod: "POST", shadow: sp}, r: r}
+	reqs := []*jsonrpc.Request{
+		{ID: json.RawMessage("1"), Method: "eth_blockNumber"},
+		{ID: json.RawMessage("2"), Method: "eth_getBalance"},
+	}
+	body, _ := json.Marshal(reqs)
+	resp := &proxyResponse{
+		statusCode: http.StatusOK,
+		body:       []byte(`[{"jsonrpc":"2.0","id":1,"result":"0x1"},{"jsonrpc":"2.0","id":2,"result":"0x3"}]`),
+	}
+
+	p.mirror(s, reqs, body, resp)
+
+	match := p.shadowRequestsCounter.Counter("mainnet", "eth_blockNumber", "match")
+	mismatch := p.shadowRequestsCounter.Counter("mainnet", "eth_getBalance", "mismatch")
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if match.Value() == 1 && mismatch.Value() == 1 {
+			break
+		}
+	}
+	if match.Value() != 1 || mismatch.Value() != 1 {
+		t.Fatalf("expected a match and a mismatch, got %v and %v", match.Value(), mismatch.Value())
+	}
+
+	// replaying the shadow's responses would mix them up with the primary's
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if recorded, err := ioutil.ReadFile(recordPath); err != nil || len(recorded) != 0 {
+		t.Fatalf("expected nothing recorded, got %q %v", recorded, err)
+	}
+}
+
+func TestShadowPolicyRefusesWrites(t *testing.T) {
+	for _, method := range []string{"eth_sendRawTransaction", "personal_unlockAccount", "admin_addPeer"} {
+		config := &Config{
+			ShadowUpstream: &UpstreamConfig{URL: "http://localhost:8545"},
+			ShadowMethods:  []string{method},
+		}
+		if _, err := newShadowPolicy(config, &ChainConfig{}); err == nil {
+			t.Fatalf("expected an error for %s", method)
+		}
+	}
+}
+
+func TestShadowPolicyMirrorsReads(t *testing.T) {
+	sp, err := newShadowPolicy(&Config{ShadowUpstream: &UpstreamConfig{URL: "http://localhost:8545"}}, &ChainConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sp.mirrored([]*jsonrpc.Request{{ID: json.RawMessage("1"), Method: "eth_call"}, {ID: json.RawMessage("2"), Method: "eth_getBalance"}}) {
+		t.Fatal("expected reads to be mirrored")
+	}
+	for _, method := range []string{"personal_unlockAccount", "personal_sign", "eth_submitWork", "miner_start", "admin_peers", "eth_getFilterChanges"} {
+		if sp.mirrored([]*jsonrpc.Request{{ID: json.RawMessage("1"), Method: method}}) {
+			t.Fatalf("expected %s not to be mirrored", method)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/cache"
+)
+
+// loadSnapshot restores rate limit counters, bans and notification markers
+// saved by a previous process.
+func (p *Proxy) loadSnapshot() {
+	snapshotter, ok := p.store.(cache.Snapshotter)
+	if p.snapshotPath == "" || !ok {
+		return
+	}
+
+	n, err := cache.LoadSnapshot(p.snapshotPath, snapshotter)
+	if err != nil {
+		// a corrupt snapshot shouldn't stop the proxy from starting
+		fmt.Printf("SNAPSHOT ERROR %v\n", err)
+		return
+	}
+
+	fmt.Printf("Restored %v keys from snapshot %s\n", n, p.snapshotPath)
+}
+
+// saveSnapshot ...
+func (p *Proxy) saveSnapshot() error {
+	snapshotter, ok := p.store.(cache.Snapshotter)
+	if p.snapshotPath == "" || !ok {
+		return nil
+	}
+
+	n, err := cache.SaveSnapshot(p.snapshotPath, snapshotter)
+	if err != nil {
+		return err
+	}
+
+	if p.logLevel == "debug" {
+		fmt.Printf("Saved %v keys to snapshot %s\n", n, p.snapshotPath)
+	}
+
+	return nil
+}
+
+// snapshotLoop saves snapshots periodically so a crash loses at most one interval
+func (p *Proxy) snapshotLoop() {
+	ticker := time.NewTicker(p.snapshotInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := p.saveSnapshot(); err != nil {
+			fmt.Printf("SNAPSHOT ERROR %v\n", err)
+		}
+	}
+}
+
+// Stop saves state that should survive a restart. It should be called
+// before the process exits.
+func (p *Proxy) Stop() error {
+	if p.journal != nil {
+		if err := p.journal.Close(); err != nil {
+			return err
+		}
+	}
+
+	if p.recorder != nil {
+		if err := p.recorder.Close(); err != nil {
+			return err
+		}
+	}
+
+	return p.saveSnapshot()
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/tx"
+)
+
+// txPolicy restricts the transactions accepted by eth_sendRawTransaction.
+// Addresses are lowercase hex with the 0x prefix.
+type txPolicy struct {
+	allowedSenders    map[string]bool
+	deniedSenders     map[string]bool
+	allowedRecipients map[string]bool
+	deniedRecipients  map[string]bool
+	allowedChainIDs   map[uint64]bool
+	maxValue          *big.Int
+	// maxGasPrice limits the gas price, or the max fee per gas of dynamic
+	// fee transactions
+	maxGasPrice *big.Int
+}
+
+// newTxPolicy builds the transaction rules of a chain, where each rule set
+// on the chain replaces the top level one. It returns nil if no rules are
+// set.
+func newTxPolicy(config *Config, chainConfig *ChainConfig) (*txPolicy, error) {
+	policy := &txPolicy{}
+
+	lists := []struct {
+		set      *map[string]bool
+		chain    []string
+		fallback []string
+	}{
+		{&policy.allowedSenders, chainConfig.TxAllowedSenders, config.TxAllowedSenders},
+		{&policy.deniedSenders, chainConfig.TxDeniedSenders, config.TxDeniedSenders},
+		{&policy.allowedRecipients, chainConfig.TxAllowedRecipients, config.TxAllowedRecipients},
+		{&policy.deniedRecipients, chainConfig.TxDeniedRecipients, config.TxDeniedRecipients},
+	}
+
+	enabled := false
+	for _, list := range lists {
+		addresses := list.chain
+		if addresses == nil {
+			addresses = list.fallback
+		}
+		if len(addresses) == 0 {
+			continue
+		}
+
+		set, err := parseAddresses(addresses)
+		if err != nil {
+			return nil, err
+		}
+		*list.set = set
+		enabled = true
+	}
+
+	chainIDs := chainConfig.TxAllowedChainIDs
+	if chainIDs == nil {
+		chainIDs = config.TxAllowedChainIDs
+	}
+	if len(chainIDs) > 0 {
+		policy.allowedChainIDs = make(map[uint64]bool, len(chainIDs))
+		for _, chainID := range chainIDs {
+			policy.allowedChainIDs[chainID] = true
+		}
+		enabled = true
+	}
+
+	var err error
+	if policy.maxValue, err = parseWei(firstString(chainConfig.TxMaxValue, config.TxMaxValue)); err != nil {
+		return nil, fmt.Errorf("invalid max value: %s", err)
+	}
+	if policy.maxGasPrice, err = parseWei(firstString(chainConfig.TxMaxGasPrice, config.TxMaxGasPrice)); err != nil {
+		return nil, fmt.Errorf("invalid max gas price: %s", err)
+	}
+	if policy.maxValue != nil || policy.maxGasPrice != nil {
+		enabled = true
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+
+	return policy, nil
+}
+
+// check returns why the transaction breaks the rules, or an empty string
+// if it doesn't
+func (t *txPolicy) check(transaction *tx.Transaction) string {
+	from := "0x" + hex.EncodeToString(transaction.From)
+	if t.deniedSenders[from] || (t.allowedSenders != nil && !t.allowedSenders[from]) {
+		return fmt.Sprintf("Sender %s is not allowed", from)
+	}
+
+	if transaction.To == nil {
+		if t.allowedRecipients != nil {
+			return "Contract creation is not allowed"
+		}
+	} else {
+		to := "0x" + hex.EncodeToString(transaction.To)
+		if t.deniedRecipients[to] || (t.allowedRecipients != nil && !t.allowedRecipients[to]) {
+			return fmt.Sprintf("Recipient %s is not allowed", to)
+		}
+	}
+
+	if t.allowedChainIDs != nil {
+		if transaction.ChainID == nil {
+			return "Transactions without a chain ID are not allowed"
+		}
+		if !transaction.ChainID.IsUint64() || !t.allowedChainIDs[transaction.ChainID.Uint64()] {
+			return fmt.Sprintf("Chain ID %s is not allowed", transaction.ChainID)
+		}
+	}
+
+	if t.maxValue != nil && transaction.Value.Cmp(t.maxValue) > 0 {
+		return fmt.Sprintf("Value %s exceeds maximum of %s", transaction.Value, t.maxValue)
+	}
+
+	if t.maxGasPrice != nil && transaction.GasPrice.Cmp(t.maxGasPrice) > 0 {
+		return fmt.Sprintf("Gas price %s exceeds maximum of %s", transaction.GasPrice, t.maxGasPrice)
+	}
+
+	return ""
+}
+
+// txPolicyResponse decodes the transaction of eth_sendRawTransaction and
+// rejects it if it breaks the chain's rules, before it is sent anywhere. It
+// returns nil for other requests and allowed transactions.
+func (p *Proxy) txPolicyResponse(s *session, req *jsonrpc.Request) *jsonrpc.Response {
+	c := s.chain
+	if c.txPolicy == nil || req.Method != "eth_sendRawTransaction" {
+		return nil
+	}
+
+	raw, err := rawTx(req)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, err.Error(), nil)
+	}
+
+	transaction, err := tx.Decode(raw)
+	if err != nil {
+		fmt.Printf("TX REJECTED ID=%v: %s IP=%s CHAIN=%s\n", s.id, err, s.ipAddress, c.name)
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInvalidParams, err.Error(), nil)
+	}
+
+	if reason := c.txPolicy.check(transaction); reason != "" {
+		fmt.Printf("TX REJECTED ID=%v: %s HASH=0x%x IP=%s CHAIN=%s\n", s.id, reason, transaction.Hash, s.ipAddress, c.name)
+		p.txRejectedCounter.Counter(c.name).Inc()
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeTransactionRejected, reason, nil)
+	}
+
+	return nil
+}
+
+func parseAddresses(addresses []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		address = strings.ToLower(strings.TrimSpace(address))
+		b, err := hex.DecodeString(strings.TrimPrefix(address, "0x"))
+		if err != nil || len(b) != 20 {
+			return nil, fmt.Errorf("invalid address %q", address)
+		}
+		set["0x"+hex.EncodeToString(b)] = true
+	}
+
+	return set, nil
+}
+
+// parseWei parses a decimal amount of wei, returning nil if it's empty
+func parseWei(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, fmt.Errorf("%q is not an amount of wei", s)
+	}
+
+	return n, nil
+}
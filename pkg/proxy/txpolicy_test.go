@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/tx"
+)
+
+// eip155Tx is sent by 0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f to
+// 0x3535353535353535353535353535353535353535 with 1 ether on chain 1
+const eip155Tx = "f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+
+func TestTxPolicy(t *testing.T) {
+	raw, _ := hex.DecodeString(eip155Tx)
+	transaction, err := tx.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if policy, err := newTxPolicy(&Config{}, &ChainConfig{}); err != nil || policy != nil {
+		t.Fatalf("expected no policy without rules, got %v %v", policy, err)
+	}
+
+	tests := []struct {
+		config  *Config
+		allowed bool
+	}{
+		{&Config{TxAllowedSenders: []string{"0x9D8A62F656A8D1615C1294FD71E9CFB3E4855A4F"}}, true},
+		{&Config{TxAllowedSenders: []string{"0x3535353535353535353535353535353535353535"}}, false},
+		{&Config{TxDeniedSenders: []string{"0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"}}, false},
+		{&Config{TxAllowedRecipients: []string{"0x3535353535353535353535353535353535353535"}}, true},
+		{&Config{TxDeniedRecipients: []string{"0x3535353535353535353535353535353535353535"}}, false},
+		{&Config{TxAllowedChainIDs: []uint64{1}}, true},
+		{&Config{TxAllowedChainIDs: []uint64{5}}, false},
+		{&Config{TxMaxValue: "1000000000000000000"}, true},
+		{&Config{TxMaxValue: "999999999999999999"}, false},
+		{&Config{TxMaxGasPrice: "10000000000"}, false},
+	}
+	for i, test := range tests {
+		policy, err := newTxPolicy(test.config, &ChainConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reason := policy.check(transaction); (reason == "") != test.allowed {
+			t.Fatalf("test %v: expected allowed %v, got %q", i, test.allowed, reason)
+		}
+	}
+
+	// chain rules replace the top level ones
+	policy, err := newTxPolicy(&Config{TxDeniedSenders: []string{"0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"}}, &ChainConfig{TxDeniedSenders: []string{}})
+	if err != nil || policy != nil {
+		t.Fatalf("expected the chain to clear the denied senders, got %v %v", policy, err)
+	}
+
+	if _, err := newTxPolicy(&Config{TxAllowedSenders: []string{"0x1234"}}, &ChainConfig{}); err == nil {
+		t.Fatal("expected an invalid address error")
+	}
+	if _, err := newTxPolicy(&Config{TxMaxValue: "1e18"}, &ChainConfig{}); err == nil {
+		t.Fatal("expected an invalid amount error")
+	}
+}
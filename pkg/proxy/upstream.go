@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// UpstreamConfig ...
+type UpstreamConfig struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Weight is the share of traffic with weighted load balancing, default 1
+	Weight int `json:"weight"`
+	// Tags describe the upstream, e.g. "archive" for archive nodes
+	Tags []string `json:"tags"`
+}
+
+// upstream is an RPC provider requests are proxied to
+type upstream struct {
+	// head is the latest block number reported, first for 64-bit alignment
+	head   uint64
+	name   string
+	url    *url.URL
+	weight int
+	tags   map[string]bool
+	// ejected is set while the upstream is serving the wrong chain
+	ejected int32
+	// breaker is nil unless circuit breaking is enabled
+	breaker *breaker
+
+	mu          sync.Mutex
+	latencyEWMA time.Duration
+	samples     [latencySamples]time.Duration
+	sampleCount int
+	requests    uint64
+	failures    uint64
+	// latest is the upstream's head block, if the chain serves the head
+	latest *headBlock
+}
+
+// newUpstream ...
+func newUpstream(config *UpstreamConfig) (*upstream, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid upstream URL %q", config.URL)
+	}
+
+	name := config.Name
+	if name == "" {
+		name = u.Hostname()
+	}
+
+	weight := 1
+	if config.Weight < 0 {
+		return nil, fmt.Errorf("invalid weight %v for upstream %s", config.Weight, name)
+	}
+	if config.Weight != 0 {
+		weight = config.Weight
+	}
+
+	tags := make(map[string]bool, len(config.Tags))
+	for _, tag := range config.Tags {
+		tags[tag] = true
+	}
+
+	return &upstream{
+		name:   name,
+		url:    u,
+		weight: weight,
+		tags:   tags,
+	}, nil
+}
+
+// proxyResponse is a response either received from the upstream or built locally
+type proxyResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	// upstream answered the request, nil for local responses
+	upstream *upstream
+}
+
+// forward sends the request body upstream and returns the response. Failed
+// attempts are retried on the next upstream picked by the chain's load
+// balancer, with backoff, as long as the retry policy allows it and the
+// client is still waiting. Requests for
+// hedged methods are also sent to the second upstream if the first is slow
+// to answer.
+func (p *Proxy) forward(s *session, reqs []*jsonrpc.Request, body []byte) (*proxyResponse, error) {
+	upstreams := s.chain.balancer.order(s.chain.upstreams)
+	upstreams = s.chain.routeHeads(reqs, s.chain.routeArchive(reqs, upstreams))
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstreams available")
+	}
+
+	safe := p.retry.safe(reqs)
+	deadline := time.Now().Add(p.retry.budget)
+
+	var errs []string
+	var lastResp *proxyResponse
+	next := 0
+	for attempt := 0; attempt < p.retry.attempts; attempt++ {
+		if attempt > 0 {
+			delay := p.retry.delay(attempt)
+			if time.Now().Add(delay).After(deadline) {
+				break
+			}
+			if !p.retry.wait(s, delay) {
+				errs = append(errs, "client went away")
+				break
+			}
+		}
+
+		var resp *proxyResponse
+		var err error
+		if attempt == 0 && len(upstreams) > 1 && s.chain.hedged(reqs) {
+			resp, err = p.hedge(s, upstreams[0], upstreams[1], body)
+			next = 2
+		} else {
+			u := upstreams[next%len(upstreams)]
+			next++
+
+			resp, err = p.forwardTo(context.Background(), s, u, body)
+			if err != nil {
+				fmt.Printf("ERROR ID=%v: %s IP=%s CHAIN=%s UPSTREAM=%s\n", s.id, err, s.ipAddress, s.chain.name, u.name)
+				err = fmt.Errorf("%s: %w", u.name, err)
+			}
+		}
+
+		if err != nil {
+			errs = append(errs, err.Error())
+			lastResp = nil
+			if !safe && !neverSent(err) {
+				break
+			}
+			p.retriesCounter.Counter(s.chain.name, "error").Inc()
+			continue
+		}
+
+		reason := p.retry.responseReason(resp)
+		if reason == "" || !safe {
+			return resp, nil
+		}
+
+		fmt.Printf("RETRY ID=%v: %s IP=%s CHAIN=%s\n", s.id, reason, s.ipAddress, s.chain.name)
+		p.retriesCounter.Counter(s.chain.name, reason).Inc()
+		lastResp = resp
+	}
+
+	// the upstream answered, just not usefully, so pass its answer on
+	if lastResp != nil {
+		return lastResp, nil
+	}
+
+	return nil, errors.New("all upstreams failed: " + strings.Join(errs, "; "))
+}
+
+// forwardTo sends the request body with the client's headers to the upstream
+func (p *Proxy) forwardTo(ctx context.Context, s *session, u *upstream, body []byte) (*proxyResponse, error) {
+	if !p.acquireUpstream(s.chain, u) {
+		return nil, errCircuitOpen
+	}
+
+	start := time.Now()
+	resp, err := p.sendContext(ctx, s.chain, u, s.r.Header, body)
+	latency := time.Since(start)
+	if resp != nil {
+		resp.upstream = u
+	}
+
+	// a request cancelled because another upstream answered first says
+	// nothing about this upstream
+	if ctx.Err() != nil {
+		if u.breaker != nil {
+			u.breaker.release()
+		}
+		return resp, err
+	}
+
+	// server errors are passed through to the client but don't count as fast responses
+	observed := err
+	if err == nil && resp.statusCode >= 500 {
+		observed = fmt.Errorf("upstream status %v", resp.statusCode)
+	}
+
+	u.observe(latency, observed)
+	p.recordUpstream(s.chain, u, observed)
+	if observed != nil {
+		p.upstreamRequestsCounter.Counter(s.chain.name, u.name, "error").Inc()
+	} else {
+		p.upstreamRequestsCounter.Counter(s.chain.name, u.name, "ok").Inc()
+		p.upstreamLatencyGauge.Gauge(s.chain.name, u.name).Set(u.latency().Seconds())
+	}
+
+	return resp, err
+}
+
+// send sends the body to the chain's upstream and returns its response
+func (p *Proxy) send(c *chain, u *upstream, header http.Header, body []byte) (*proxyResponse, error) {
+	return p.sendContext(context.Background(), c, u, header, body)
+}
+
+// sendContext is send with a context that aborts the request when done.
+// When replaying, recorded responses are returned instead.
+func (p *Proxy) sendContext(ctx context.Context, c *chain, u *upstream, header http.Header, body []byte) (*proxyResponse, error) {
+	if p.replayer != nil {
+		return p.replay(c, body)
+	}
+
+	resp, err := p.roundTrip(ctx, c, u, header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.recorder != nil {
+		p.record(c, u, body, resp.body)
+	}
+
+	return resp, nil
+}
+
+// roundTrip sends the body to the upstream and returns its response,
+// without recording or replaying it
+func (p *Proxy) roundTrip(ctx context.Context, c *chain, u *upstream, header http.Header, body []byte) (*proxyResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, c.proxyMethod, u.url.String(), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// Close request after sending request and reading response
+	req.Close = true
+
+	// copy headers to request
+	for k, v := range header {
+		req.Header.Set(k, v[0])
+	}
+
+	// Connection header informs server that client wants to close connection after response.
+	req.Header.Set("Connection", "close")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Del("Host")
+
+	// setting the content length disables chunked transfer encoding,
+	// which is required to make proxy work with Alchemy
+	req.ContentLength = int64(len(body))
+
+	if p.logLevel == "debug" {
+		httpMsg, err := httputil.DumpRequestOut(req, true)
+		if err != nil {
+			return nil, err
+		}
+
+		fmt.Println(string(httpMsg))
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// re-use connection
+	defer resp.Body.Close()
+
+	// response body
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyResponse{
+		statusCode: resp.StatusCode,
+		header:     resp.Header,
+		body:       respBody,
+	}, nil
+}
+
+// call sends a JSON-RPC request made by the proxy itself to the upstream
+func (p *Proxy) call(c *chain, u *upstream, method string, params ...interface{}) (json.RawMessage, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&jsonrpc.Request{
+		JSONRPC: jsonrpc.Version,
+		ID:      json.RawMessage("1"),
+		Method:  method,
+		Params:  rawParams,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.send(c, u, nil, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resps, _, err := jsonrpc.ParseResponses(resp.body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response (status %v): %s", resp.statusCode, err)
+	}
+
+	if len(resps) != 1 || string(resps[0].ID) != "1" {
+		return nil, fmt.Errorf("invalid response (status %v): expected one response with id 1", resp.statusCode)
+	}
+
+	if resps[0].Error != nil {
+		return nil, resps[0].Error
+	}
+
+	return resps[0].Result, nil
+}
+
+func (u *upstream) isEjected() bool {
+	return atomic.LoadInt32(&u.ejected) == 1
+}
+
+// setEjected returns true if the ejected state changed
+func (u *upstream) setEjected(ejected bool) bool {
+	if ejected {
+		return atomic.SwapInt32(&u.ejected, 1) == 0
+	}
+
+	return atomic.SwapInt32(&u.ejected, 0) == 1
+}
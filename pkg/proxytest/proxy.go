@@ -0,0 +1,119 @@
+package proxytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/proxy"
+)
+
+// Proxy is a proxy served by a test server
+type Proxy struct {
+	*proxy.Proxy
+	URL string
+
+	server *httptest.Server
+}
+
+// Config returns a proxy config with the servers as upstreams, named
+// upstream1, upstream2 and so on in order, and without the IP rate limits
+// getting in the way of tests
+func Config(servers ...*Server) *proxy.Config {
+	upstreams := make([]*proxy.UpstreamConfig, len(servers))
+	for i, s := range servers {
+		upstreams[i] = &proxy.UpstreamConfig{
+			Name: fmt.Sprintf("upstream%v", i+1),
+			URL:  s.URL,
+		}
+	}
+
+	return &proxy.Config{
+		Upstreams:                  upstreams,
+		ProxyMethod:                "POST",
+		LeakyBucketLimitPerSecond:  100000,
+		SoftCapIPRequestsPerMinute: 100000,
+		HardCapIPRequestsPerMinute: 100000,
+	}
+}
+
+// NewProxy starts a proxy with the config on a test server. The proxy
+// should be closed when done.
+func NewProxy(config *proxy.Config) (*Proxy, error) {
+	rpcProxy, err := proxy.New(config)
+	if err != nil {
+		return nil, err
+	}
+
+	handler, err := rpcProxy.Handler()
+	if err != nil {
+		return nil, err
+	}
+
+	server := httptest.NewServer(handler)
+	return &Proxy{
+		Proxy:  rpcProxy,
+		URL:    server.URL,
+		server: server,
+	}, nil
+}
+
+// Close shuts down the test server and stops the proxy
+func (p *Proxy) Close() error {
+	p.server.Close()
+	return p.Proxy.Stop()
+}
+
+// Call sends a JSON-RPC request to the proxy and returns the response
+func (p *Proxy) Call(method string, params ...interface{}) (*jsonrpc.Response, error) {
+	return Call(p.URL, method, params...)
+}
+
+// Call sends a JSON-RPC request to the URL and returns the response. The
+// response is an error if the request failed or the status isn't 200.
+func Call(url string, method string, params ...interface{}) (*jsonrpc.Response, error) {
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	rawParams, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(&jsonrpc.Request{
+		JSONRPC: jsonrpc.Version,
+		ID:      json.RawMessage("1"),
+		Method:  method,
+		Params:  rawParams,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %v: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	}
+
+	resps, _, err := jsonrpc.ParseResponses(respBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return resps[0], nil
+}
@@ -0,0 +1,84 @@
+package proxytest
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"testing"
+)
+
+// eip155Tx is sent by 0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f with nonce 9
+const eip155Tx = "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	sender := "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+	s.SetBalance(sender, big.NewInt(2e18))
+	s.SetBlockNumber(9)
+
+	expectResult(t, s.URL, `"0x9"`, "eth_blockNumber")
+	expectResult(t, s.URL, `"0x1bc16d674ec80000"`, "eth_getBalance", sender, "latest")
+
+	hash := "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788"
+	expectResult(t, s.URL, `"`+hash+`"`, "eth_sendRawTransaction", eip155Tx)
+	expectResult(t, s.URL, "null", "eth_getTransactionReceipt", hash)
+
+	if resp, err := Call(s.URL, "eth_sendRawTransaction", eip155Tx); err != nil || resp.Error == nil || resp.Error.Message != "already known" {
+		t.Fatalf("expected already known, got %+v %v", resp, err)
+	}
+
+	if s.MineBlock() != 10 {
+		t.Fatal("expected block 10")
+	}
+
+	resp, err := Call(s.URL, "eth_getTransactionReceipt", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var receipt struct {
+		BlockNumber string `json:"blockNumber"`
+	}
+	if err := json.Unmarshal(resp.Result, &receipt); err != nil || receipt.BlockNumber != "0xa" {
+		t.Fatalf("expected a receipt in block 10, got %s", resp.Result)
+	}
+	expectResult(t, s.URL, `"0xa"`, "eth_getTransactionCount", sender, "latest")
+	expectResult(t, s.URL, `"0xde0b6b3a7640000"`, "eth_getBalance", sender, "latest")
+
+	s.SetError("eth_call", 3, "execution reverted")
+	if resp, err := Call(s.URL, "eth_call", map[string]string{}, "latest"); err != nil || resp.Error == nil || resp.Error.Code != 3 {
+		t.Fatalf("expected the canned error, got %+v %v", resp, err)
+	}
+
+	s.FailNext(1, http.StatusTooManyRequests)
+	if _, err := Call(s.URL, "eth_chainId"); err == nil {
+		t.Fatal("expected a 429")
+	}
+	expectResult(t, s.URL, `"0x1"`, "eth_chainId")
+
+	if s.Count("eth_chainId") != 2 {
+		t.Fatalf("expected 2 eth_chainId requests, got %v", s.Count("eth_chainId"))
+	}
+}
+
+func TestNewProxyConfigError(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	config := Config(s)
+	config.LoadBalancing = "random"
+	if _, err := NewProxy(config); err == nil {
+		t.Fatal("expected an unknown load balancing error")
+	}
+}
+
+func expectResult(t *testing.T, url string, expected string, method string, params ...interface{}) {
+	resp, err := Call(url, method, params...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil || string(resp.Result) != expected {
+		t.Fatalf("%s: expected %s, got %s %+v", method, expected, resp.Result, resp.Error)
+	}
+}
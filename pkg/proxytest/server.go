@@ -0,0 +1,598 @@
+// Package proxytest provides a fake Ethereum JSON-RPC upstream and helpers
+// to run a proxy against it, so configs and middleware can be tested
+// without a live provider.
+package proxytest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/tx"
+)
+
+// blockTime is the time between the fake chain's blocks
+const blockTime = 12 * time.Second
+
+// Handler answers a JSON-RPC method. Returning a *jsonrpc.Error sends that
+// error, any other error is sent with code -32000.
+type Handler func(params json.RawMessage) (interface{}, error)
+
+// transaction is a transaction sent to the fake chain
+type transaction struct {
+	hash        string
+	from        string
+	to          string
+	nonce       uint64
+	value       *big.Int
+	blockNumber uint64
+	mined       bool
+}
+
+// failure is a scripted HTTP error response
+type failure struct {
+	statusCode int
+	remaining  int
+}
+
+// Server is a fake Ethereum JSON-RPC upstream. It keeps a simple chain
+// state: blocks, balances, nonces and the transactions sent to it, which
+// are mined with MineBlock. Any method can be overridden with canned
+// results, errors or handlers, and responses can be delayed or replaced
+// with HTTP errors such as 429s.
+type Server struct {
+	URL string
+
+	server       *httptest.Server
+	mu           sync.Mutex
+	handlers     map[string]Handler
+	latencies    map[string]time.Duration
+	failures     []*failure
+	maxBatchSize int
+	requests     []*jsonrpc.Request
+	chainID      uint64
+	blockNumber  uint64
+	genesis      time.Time
+	balances     map[string]*big.Int
+	nonces       map[string]uint64
+	transactions map[string]*transaction
+	pending      []*transaction
+}
+
+// NewServer starts a fake upstream for chain ID 1 at block 0. It should be
+// closed when done.
+func NewServer() *Server {
+	s := &Server{
+		handlers:     make(map[string]Handler),
+		latencies:    make(map[string]time.Duration),
+		chainID:      1,
+		genesis:      time.Now().Truncate(time.Second),
+		balances:     make(map[string]*big.Int),
+		nonces:       make(map[string]uint64),
+		transactions: make(map[string]*transaction),
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.ServeHTTP))
+	s.URL = s.server.URL
+
+	return s
+}
+
+// Close shuts down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Handle answers the method with the handler
+func (s *Server) Handle(method string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[method] = handler
+}
+
+// SetResult answers the method with the result
+func (s *Server) SetResult(method string, result interface{}) {
+	s.Handle(method, func(json.RawMessage) (interface{}, error) {
+		return result, nil
+	})
+}
+
+// SetError answers the method with the error
+func (s *Server) SetError(method string, code int, message string) {
+	s.Handle(method, func(json.RawMessage) (interface{}, error) {
+		return nil, &jsonrpc.Error{Code: code, Message: message}
+	})
+}
+
+// SetLatency delays responses to the method, or to every method if the
+// method is empty. Batches are delayed by their slowest method.
+func (s *Server) SetLatency(method string, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latencies[method] = latency
+}
+
+// FailNext answers the next n requests with the HTTP status code, e.g.
+// http.StatusTooManyRequests or http.StatusServiceUnavailable
+func (s *Server) FailNext(n int, statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{statusCode: statusCode, remaining: n})
+}
+
+// SetMaxBatchSize answers batches of more than n requests with a single
+// error, like providers that limit batch sizes
+func (s *Server) SetMaxBatchSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxBatchSize = n
+}
+
+// SetChainID sets the chain ID returned by eth_chainId and net_version
+func (s *Server) SetChainID(chainID uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chainID = chainID
+}
+
+// SetBlockNumber moves the head to the block
+func (s *Server) SetBlockNumber(blockNumber uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blockNumber = blockNumber
+}
+
+// BlockNumber returns the head block number
+func (s *Server) BlockNumber() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.blockNumber
+}
+
+// SetBalance sets the balance in wei of the address
+func (s *Server) SetBalance(address string, balance *big.Int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.balances[strings.ToLower(address)] = new(big.Int).Set(balance)
+}
+
+// MineBlock adds a block with the pending transactions and returns its
+// number
+func (s *Server) MineBlock() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blockNumber++
+	for _, t := range s.pending {
+		t.mined = true
+		t.blockNumber = s.blockNumber
+		s.nonces[t.from] = t.nonce + 1
+
+		if balance, ok := s.balances[t.from]; ok {
+			balance.Sub(balance, t.value)
+		}
+		if t.to != "" {
+			if _, ok := s.balances[t.to]; !ok {
+				s.balances[t.to] = new(big.Int)
+			}
+			s.balances[t.to].Add(s.balances[t.to], t.value)
+		}
+	}
+	s.pending = nil
+
+	return s.blockNumber
+}
+
+// Requests returns the JSON-RPC requests received so far
+func (s *Server) Requests() []*jsonrpc.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*jsonrpc.Request{}, s.requests...)
+}
+
+// Count returns the number of requests received for the method
+func (s *Server) Count(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, req := range s.requests {
+		if req.Method == method {
+			n++
+		}
+	}
+
+	return n
+}
+
+// ServeHTTP answers a JSON-RPC request or batch
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	reqs, isBatch, err := jsonrpc.ParseRequests(body)
+	if err != nil {
+		writeJSON(w, jsonrpc.NewErrorResponse(nil, jsonrpc.CodeParseError, "Parse error", nil))
+		return
+	}
+
+	s.mu.Lock()
+	if isBatch && s.maxBatchSize > 0 && len(reqs) > s.maxBatchSize {
+		s.mu.Unlock()
+		writeJSON(w, jsonrpc.NewErrorResponse(nil, jsonrpc.CodeLimitExceeded, fmt.Sprintf("batch size %v exceeds %v", len(reqs), s.maxBatchSize), nil))
+		return
+	}
+	s.requests = append(s.requests, reqs...)
+	latency := s.latencies[""]
+	for _, req := range reqs {
+		if methodLatency := s.latencies[req.Method]; methodLatency > latency {
+			latency = methodLatency
+		}
+	}
+	statusCode := s.nextFailure()
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if statusCode != 0 {
+		if statusCode == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		http.Error(w, http.StatusText(statusCode), statusCode)
+		return
+	}
+
+	resps := make([]*jsonrpc.Response, 0, len(reqs))
+	for _, req := range reqs {
+		resp := s.respond(req)
+		if !req.IsNotification() {
+			resps = append(resps, resp)
+		}
+	}
+
+	if !isBatch {
+		if len(resps) == 0 {
+			return
+		}
+		writeJSON(w, resps[0])
+		return
+	}
+
+	writeJSON(w, resps)
+}
+
+// nextFailure returns the status code of the next scripted failure, or 0
+func (s *Server) nextFailure() int {
+	for len(s.failures) > 0 {
+		f := s.failures[0]
+		if f.remaining <= 0 {
+			s.failures = s.failures[1:]
+			continue
+		}
+
+		f.remaining--
+		return f.statusCode
+	}
+
+	return 0
+}
+
+func (s *Server) respond(req *jsonrpc.Request) *jsonrpc.Response {
+	s.mu.Lock()
+	handler, ok := s.handlers[req.Method]
+	s.mu.Unlock()
+	if !ok {
+		handler, ok = s.builtin(req.Method)
+	}
+	if !ok {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeMethodNotFound, fmt.Sprintf("the method %s does not exist/is not available", req.Method), nil)
+	}
+
+	result, err := handler(req.Params)
+	if err != nil {
+		if rpcError, ok := err.(*jsonrpc.Error); ok {
+			return jsonrpc.NewErrorResponse(req.ID, rpcError.Code, rpcError.Message, rpcError.Data)
+		}
+		return jsonrpc.NewErrorResponse(req.ID, -32000, err.Error(), nil)
+	}
+
+	buf, err := json.Marshal(result)
+	if err != nil {
+		return jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeInternalError, err.Error(), nil)
+	}
+
+	return jsonrpc.NewResultResponse(req.ID, buf)
+}
+
+// builtin returns the handler of a method the fake chain implements
+func (s *Server) builtin(method string) (Handler, bool) {
+	handlers := map[string]Handler{
+		"eth_chainId":               s.chainIDHandler,
+		"net_version":               s.netVersion,
+		"eth_blockNumber":           s.blockNumberHandler,
+		"eth_getBlockByNumber":      s.getBlockByNumber,
+		"eth_getBalance":            s.getBalance,
+		"eth_getTransactionCount":   s.getTransactionCount,
+		"eth_gasPrice":              constant(quantity(1000000000)),
+		"eth_getLogs":               constant([]interface{}{}),
+		"eth_sendRawTransaction":    s.sendRawTransaction,
+		"eth_getTransactionByHash":  s.getTransactionByHash,
+		"eth_getTransactionReceipt": s.getTransactionReceipt,
+		"web3_clientVersion":        constant("proxytest/v1"),
+	}
+
+	handler, ok := handlers[method]
+	return handler, ok
+}
+
+func (s *Server) chainIDHandler(json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return quantity(s.chainID), nil
+}
+
+func (s *Server) netVersion(json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return strconv.FormatUint(s.chainID, 10), nil
+}
+
+func (s *Server) blockNumberHandler(json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return quantity(s.blockNumber), nil
+}
+
+func (s *Server) getBlockByNumber(params json.RawMessage) (interface{}, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, invalidParams("missing block number")
+	}
+
+	var tag string
+	if err := json.Unmarshal(args[0], &tag); err != nil {
+		return nil, invalidParams("invalid block number")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	number, err := s.resolveBlock(tag)
+	if err != nil {
+		return nil, err
+	}
+	if number > s.blockNumber {
+		return nil, nil
+	}
+
+	transactions := []string{}
+	for _, t := range s.transactions {
+		if t.mined && t.blockNumber == number {
+			transactions = append(transactions, t.hash)
+		}
+	}
+
+	parentHash := blockHash(0)
+	if number > 0 {
+		parentHash = blockHash(number - 1)
+	}
+
+	return map[string]interface{}{
+		"number":       quantity(number),
+		"hash":         blockHash(number),
+		"parentHash":   parentHash,
+		"timestamp":    quantity(uint64(s.genesis.Add(time.Duration(number) * blockTime).Unix())),
+		"transactions": transactions,
+	}, nil
+}
+
+func (s *Server) getBalance(params json.RawMessage) (interface{}, error) {
+	address, err := addressParam(params)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	balance, ok := s.balances[address]
+	if !ok {
+		return "0x0", nil
+	}
+
+	return "0x" + balance.Text(16), nil
+}
+
+func (s *Server) getTransactionCount(params json.RawMessage) (interface{}, error) {
+	address, err := addressParam(params)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return quantity(s.nonces[address]), nil
+}
+
+func (s *Server) sendRawTransaction(params json.RawMessage) (interface{}, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, invalidParams("missing raw transaction")
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(args[0], "0x"))
+	if err != nil {
+		return nil, invalidParams("invalid raw transaction")
+	}
+
+	decoded, err := tx.Decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %s", err)
+	}
+
+	t := &transaction{
+		hash:  "0x" + hex.EncodeToString(decoded.Hash),
+		from:  "0x" + hex.EncodeToString(decoded.From),
+		nonce: decoded.Nonce,
+		value: decoded.Value,
+	}
+	if decoded.To != nil {
+		t.to = "0x" + hex.EncodeToString(decoded.To)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.transactions[t.hash]; ok {
+		return nil, fmt.Errorf("already known")
+	}
+	if t.nonce < s.nonces[t.from] {
+		return nil, fmt.Errorf("nonce too low")
+	}
+
+	s.transactions[t.hash] = t
+	s.pending = append(s.pending, t)
+
+	return t.hash, nil
+}
+
+func (s *Server) getTransactionByHash(params json.RawMessage) (interface{}, error) {
+	t, err := s.transactionParam(params)
+	if t == nil || err != nil {
+		return nil, err
+	}
+
+	var blockNumber interface{}
+	if t.mined {
+		blockNumber = quantity(t.blockNumber)
+	}
+
+	return map[string]interface{}{
+		"hash":        t.hash,
+		"from":        t.from,
+		"nonce":       quantity(t.nonce),
+		"blockNumber": blockNumber,
+	}, nil
+}
+
+func (s *Server) getTransactionReceipt(params json.RawMessage) (interface{}, error) {
+	t, err := s.transactionParam(params)
+	if t == nil || err != nil || !t.mined {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"transactionHash": t.hash,
+		"from":            t.from,
+		"blockNumber":     quantity(t.blockNumber),
+		"blockHash":       blockHash(t.blockNumber),
+		"status":          "0x1",
+		"logs":            []interface{}{},
+	}, nil
+}
+
+// transactionParam returns the transaction with the hash in the params, or
+// nil if it's unknown
+func (s *Server) transactionParam(params json.RawMessage) (*transaction, error) {
+	var args []string
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return nil, invalidParams("missing transaction hash")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.transactions[strings.ToLower(args[0])]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *t
+	return &copied, nil
+}
+
+// resolveBlock returns the number of a block tag or hex number
+func (s *Server) resolveBlock(tag string) (uint64, error) {
+	switch tag {
+	case "latest", "pending", "safe", "finalized":
+		return s.blockNumber, nil
+	case "earliest":
+		return 0, nil
+	}
+
+	number, err := strconv.ParseUint(strings.TrimPrefix(tag, "0x"), 16, 64)
+	if err != nil || !strings.HasPrefix(tag, "0x") {
+		return 0, invalidParams("invalid block number")
+	}
+
+	return number, nil
+}
+
+func addressParam(params json.RawMessage) (string, error) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(params, &args); err != nil || len(args) == 0 {
+		return "", invalidParams("missing address")
+	}
+
+	var address string
+	if err := json.Unmarshal(args[0], &address); err != nil {
+		return "", invalidParams("invalid address")
+	}
+
+	return strings.ToLower(address), nil
+}
+
+func constant(result interface{}) Handler {
+	return func(json.RawMessage) (interface{}, error) {
+		return result, nil
+	}
+}
+
+func invalidParams(message string) error {
+	return &jsonrpc.Error{Code: jsonrpc.CodeInvalidParams, Message: message}
+}
+
+func quantity(n uint64) string {
+	return "0x" + strconv.FormatUint(n, 16)
+}
+
+// blockHash is a made up hash that's unique to the block number
+func blockHash(number uint64) string {
+	return fmt.Sprintf("0x%064x", number+1)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
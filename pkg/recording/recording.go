@@ -0,0 +1,279 @@
+package recording
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+// redacted replaces secrets in recordings
+const redacted = "[REDACTED]"
+
+// secretMethodPrefixes are methods whose params can hold passwords or keys
+var secretMethodPrefixes = []string{
+	"personal_",
+}
+
+// Record is a JSON-RPC request and the upstream's response
+type Record struct {
+	Time     time.Time       `json:"time"`
+	Chain    string          `json:"chain"`
+	Upstream string          `json:"upstream,omitempty"`
+	Method   string          `json:"method"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    *jsonrpc.Error  `json:"error,omitempty"`
+}
+
+// Recorder appends request and response pairs to a file of JSON lines
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	secrets [][]byte
+}
+
+// NewRecorder opens the file for appending. Occurrences of the secrets are
+// redacted from the recordings, as are the params of personal_ methods.
+func NewRecorder(path string, secrets []string) (*Recorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Recorder{file: file}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+
+		// secrets are matched as they appear inside JSON strings
+		escaped, err := json.Marshal(secret)
+		if err != nil {
+			return nil, err
+		}
+		r.secrets = append(r.secrets, escaped[1:len(escaped)-1])
+	}
+
+	return r, nil
+}
+
+// Record writes the requests of the body paired with the responses,
+// matched by id. Notifications and unanswered requests aren't recorded.
+func (r *Recorder) Record(chain, upstream string, reqBody, respBody []byte) error {
+	reqs, _, err := jsonrpc.ParseRequests(reqBody)
+	if err != nil {
+		return err
+	}
+
+	resps, _, err := jsonrpc.ParseResponses(respBody)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[string]*jsonrpc.Response, len(resps))
+	for _, resp := range resps {
+		byID[jsonrpc.IDKey(resp.ID)] = resp
+	}
+
+	var buf bytes.Buffer
+	now := time.Now()
+	for _, req := range reqs {
+		if req.IsNotification() {
+			continue
+		}
+
+		resp, ok := byID[jsonrpc.IDKey(req.ID)]
+		if !ok {
+			continue
+		}
+
+		record := &Record{
+			Time:     now,
+			Chain:    chain,
+			Upstream: upstream,
+			Method:   req.Method,
+			Params:   req.Params,
+			Result:   resp.Result,
+			Error:    resp.Error,
+		}
+		if isSecretMethod(req.Method) {
+			record.Params = json.RawMessage(`"` + redacted + `"`)
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		for _, secret := range r.secrets {
+			line = bytes.Replace(line, secret, []byte(redacted), -1)
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, err = r.file.Write(buf.Bytes())
+	return err
+}
+
+// Close closes the file
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// Replayer answers requests with recorded responses
+type Replayer struct {
+	mu      sync.Mutex
+	records map[string][]*Record
+	served  map[string]int
+}
+
+// LoadReplayer reads the recordings in the file
+func LoadReplayer(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &Replayer{
+		records: make(map[string][]*Record),
+		served:  make(map[string]int),
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%v: %s", path, line, err)
+		}
+
+		key := recordKey(record.Chain, record.Method, record.Params)
+		r.records[key] = append(r.records[key], &record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Len returns the number of recorded responses
+func (r *Replayer) Len() int {
+	n := 0
+	for _, records := range r.records {
+		n += len(records)
+	}
+
+	return n
+}
+
+// Respond answers the request body, a single request or a batch, with the
+// responses recorded for the same chain, method and params. Requests that
+// were recorded more than once get the responses in the order they were
+// recorded, repeating the last one. Requests that weren't recorded get an
+// error.
+func (r *Replayer) Respond(chain string, reqBody []byte) ([]byte, error) {
+	reqs, isBatch, err := jsonrpc.ParseRequests(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resps := make([]*jsonrpc.Response, 0, len(reqs))
+	for _, req := range reqs {
+		if req.IsNotification() {
+			continue
+		}
+
+		record := r.next(recordKey(chain, req.Method, req.Params))
+		switch {
+		case record == nil:
+			resps = append(resps, jsonrpc.NewErrorResponse(req.ID, jsonrpc.CodeResourceNotFound, fmt.Sprintf("No recorded response for %s", req.Method), nil))
+		case record.Error != nil:
+			resps = append(resps, jsonrpc.NewErrorResponse(req.ID, record.Error.Code, record.Error.Message, record.Error.Data))
+		default:
+			resps = append(resps, jsonrpc.NewResultResponse(req.ID, record.Result))
+		}
+	}
+
+	if !isBatch {
+		if len(resps) == 0 {
+			return []byte{}, nil
+		}
+		return json.Marshal(resps[0])
+	}
+
+	return json.Marshal(resps)
+}
+
+func (r *Replayer) next(key string) *Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := r.records[key]
+	if len(records) == 0 {
+		return nil
+	}
+
+	i := r.served[key]
+	if i >= len(records) {
+		return records[len(records)-1]
+	}
+	r.served[key] = i + 1
+
+	return records[i]
+}
+
+// recordKey identifies a request regardless of whitespace and the order of
+// object keys in its params
+func recordKey(chain, method string, params json.RawMessage) string {
+	canonical := "[]"
+	if len(params) > 0 {
+		canonical = string(params)
+
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(params))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err == nil {
+			if buf, err := json.Marshal(v); err == nil {
+				canonical = string(buf)
+			}
+		}
+	}
+
+	return fmt.Sprintf("%s:%s:%s", chain, method, canonical)
+}
+
+func isSecretMethod(method string) bool {
+	for _, prefix := range secretMethodPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package recording
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/miguelmota/go-rpc-provider-proxy/pkg/jsonrpc"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "recording.jsonl")
+	recorder, err := NewRecorder(path, []string{"mykey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		req  string
+		resp string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"}`, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`},
+		{`{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}`, `{"jsonrpc":"2.0","id":2,"result":"0x2"}`},
+		{
+			`[{"jsonrpc":"2.0","id":3,"method":"eth_call","params":[{"to":"0x1","data":"0x"},"latest"]},{"jsonrpc":"2.0","id":4,"method":"eth_chainId"}]`,
+			`[{"jsonrpc":"2.0","id":4,"result":"0x1"},{"jsonrpc":"2.0","id":3,"error":{"code":-32000,"message":"invalid project id mykey"}}]`,
+		},
+		{`{"jsonrpc":"2.0","id":5,"method":"personal_unlockAccount","params":["0x1","password"]}`, `{"jsonrpc":"2.0","id":5,"result":true}`},
+	}
+	for _, step := range steps {
+		if err := recorder.Record("mainnet", "infura", []byte(step.req), []byte(step.resp)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "mykey") || strings.Contains(string(buf), "password") {
+		t.Fatalf("expected secrets to be redacted, got %s", buf)
+	}
+
+	replayer, err := LoadReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replayer.Len() != 5 {
+		t.Fatalf("expected 5 records, got %v", replayer.Len())
+	}
+
+	// recorded responses are served in order, then the last one repeats
+	for _, expected := range []string{`"0x1"`, `"0x2"`, `"0x2"`} {
+		resp := respond(t, replayer, "mainnet", `{"jsonrpc":"2.0","id":"a","method":"eth_blockNumber"}`)
+		if string(resp[0].ID) != `"a"` || string(resp[0].Result) != expected {
+			t.Fatalf("expected %s, got %+v", expected, resp[0])
+		}
+	}
+
+	// params match regardless of key order and whitespace
+	resps := respond(t, replayer, "mainnet", `[{"jsonrpc":"2.0","id":7,"method":"eth_call","params":[{"data":"0x", "to":"0x1"},"latest"]},{"jsonrpc":"2.0","id":8,"method":"eth_getCode","params":["0x1","latest"]}]`)
+	if len(resps) != 2 || resps[0].Error == nil || resps[0].Error.Message != "invalid project id [REDACTED]" {
+		t.Fatalf("expected the recorded error, got %+v", resps[0])
+	}
+	if resps[1].Error == nil || resps[1].Error.Code != jsonrpc.CodeResourceNotFound {
+		t.Fatalf("expected an error for an unrecorded request, got %+v", resps[1])
+	}
+
+	if resp := respond(t, replayer, "sepolia", `{"jsonrpc":"2.0","id":1,"method":"eth_chainId"}`); resp[0].Error == nil {
+		t.Fatal("expected recordings to be scoped to the chain")
+	}
+}
+
+func respond(t *testing.T, replayer *Replayer, chain, body string) []*jsonrpc.Response {
+	buf, err := replayer.Respond(chain, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resps, _, err := jsonrpc.ParseResponses(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resps
+}
+
+func TestRecordKey(t *testing.T) {
+	a := recordKey("mainnet", "eth_call", json.RawMessage(`[{"to":"0x1","value":1}]`))
+	b := recordKey("mainnet", "eth_call", json.RawMessage(`[ {"value":1, "to":"0x1"} ]`))
+	if a != b {
+		t.Fatalf("expected equal keys, got %s and %s", a, b)
+	}
+}
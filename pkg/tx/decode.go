@@ -0,0 +1,263 @@
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Transaction is a decoded signed transaction
+type Transaction struct {
+	Type byte
+	// ChainID is nil for legacy transactions without replay protection
+	ChainID *big.Int
+	Nonce   uint64
+	// GasPrice is the max fee per gas of dynamic fee transactions
+	GasPrice             *big.Int
+	MaxPriorityFeePerGas *big.Int
+	Gas                  uint64
+	// To is nil for contract creation
+	To    []byte
+	Value *big.Int
+	Data  []byte
+	Hash  []byte
+	// From is the sender recovered from the signature
+	From []byte
+}
+
+// fieldCounts is the number of RLP fields of each typed transaction,
+// including the three signature fields
+var fieldCounts = map[byte]int{
+	AccessListTxType: 11,
+	DynamicFeeTxType: 12,
+	BlobTxType:       14,
+}
+
+// Decode decodes a raw signed legacy, EIP-2930, EIP-1559 or EIP-4844
+// transaction and recovers its sender.
+func Decode(raw []byte) (*Transaction, error) {
+	hash, err := Hash(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *Transaction
+	if raw[0] >= 0xC0 {
+		tx, err = decodeLegacy(raw)
+	} else {
+		tx, err = decodeTyped(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Hash = hash
+	return tx, nil
+}
+
+func decodeLegacy(raw []byte) (*Transaction, error) {
+	content, _, err := rlp.SplitList(raw)
+	if err != nil {
+		return nil, ErrInvalidTransaction
+	}
+
+	items, err := splitItems(content)
+	if err != nil || len(items) != 9 {
+		return nil, ErrInvalidTransaction
+	}
+
+	tx := &Transaction{Type: LegacyTxType}
+	d := &decoder{}
+	tx.Nonce = d.uint(items[0])
+	tx.GasPrice = d.big(items[1])
+	tx.Gas = d.uint(items[2])
+	tx.To = d.address(items[3])
+	tx.Value = d.big(items[4])
+	tx.Data = d.bytes(items[5])
+	v, r, s := d.big(items[6]), d.big(items[7]), d.big(items[8])
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	// before EIP-155, v is 27 or 28 and the chain id isn't signed
+	signed := items[:6]
+	var recoveryID *big.Int
+	if v.Cmp(big.NewInt(27)) == 0 || v.Cmp(big.NewInt(28)) == 0 {
+		recoveryID = new(big.Int).Sub(v, big.NewInt(27))
+	} else {
+		if v.Cmp(big.NewInt(35)) < 0 {
+			return nil, ErrInvalidTransaction
+		}
+
+		// v = chain id * 2 + 35 + recovery id
+		chainID, recovery := new(big.Int).DivMod(new(big.Int).Sub(v, big.NewInt(35)), big.NewInt(2), new(big.Int))
+		tx.ChainID = chainID
+		recoveryID = recovery
+		signed = append(append([][]byte{}, signed...), encode(chainID), encode(uint(0)), encode(uint(0)))
+	}
+
+	from, err := sender(keccak256(encodeList(signed)), r, s, byte(recoveryID.Uint64()))
+	if err != nil {
+		return nil, err
+	}
+	tx.From = from
+
+	return tx, nil
+}
+
+func decodeTyped(raw []byte) (*Transaction, error) {
+	txType := raw[0]
+	count, ok := fieldCounts[txType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported transaction type %v", txType)
+	}
+
+	content, _, err := rlp.SplitList(raw[1:])
+	if err != nil {
+		return nil, ErrInvalidTransaction
+	}
+
+	// blob transactions in network form wrap the transaction with the blobs
+	if txType == BlobTxType {
+		if kind, inner, _, err := rlp.Split(content); err == nil && kind == rlp.List {
+			content = inner
+		}
+	}
+
+	items, err := splitItems(content)
+	if err != nil || len(items) != count {
+		return nil, ErrInvalidTransaction
+	}
+
+	tx := &Transaction{Type: txType}
+	d := &decoder{}
+	tx.ChainID = d.big(items[0])
+	tx.Nonce = d.uint(items[1])
+	fields := items[2:]
+	if txType == AccessListTxType {
+		tx.GasPrice = d.big(fields[0])
+		fields = fields[1:]
+	} else {
+		tx.MaxPriorityFeePerGas = d.big(fields[0])
+		tx.GasPrice = d.big(fields[1])
+		fields = fields[2:]
+	}
+	tx.Gas = d.uint(fields[0])
+	tx.To = d.address(fields[1])
+	tx.Value = d.big(fields[2])
+	tx.Data = d.bytes(fields[3])
+	yParity, r, s := d.uint(items[count-3]), d.big(items[count-2]), d.big(items[count-1])
+	if d.err != nil {
+		return nil, d.err
+	}
+	if yParity > 1 {
+		return nil, ErrInvalidTransaction
+	}
+
+	signingHash := keccak256([]byte{txType}, encodeList(items[:count-3]))
+	from, err := sender(signingHash, r, s, byte(yParity))
+	if err != nil {
+		return nil, err
+	}
+	tx.From = from
+
+	return tx, nil
+}
+
+// sender returns the address of the key that signed the hash
+func sender(hash []byte, r, s *big.Int, recoveryID byte) ([]byte, error) {
+	publicKey, err := recoverPublicKey(hash, r, s, recoveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return keccak256(publicKey)[12:], nil
+}
+
+// splitItems splits the content of an RLP list into its encoded items
+func splitItems(content []byte) ([][]byte, error) {
+	var items [][]byte
+	for len(content) > 0 {
+		_, _, rest, err := rlp.Split(content)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, content[:len(content)-len(rest)])
+		content = rest
+	}
+
+	return items, nil
+}
+
+// encodeList returns the RLP list of the already encoded items
+func encodeList(items [][]byte) []byte {
+	values := make([]rlp.RawValue, len(items))
+	for i, item := range items {
+		values[i] = item
+	}
+
+	return encode(values)
+}
+
+// encode returns the RLP encoding of a value that always encodes
+func encode(v interface{}) []byte {
+	b, _ := rlp.EncodeToBytes(v)
+	return b
+}
+
+// decoder reads RLP string fields, keeping the first error
+type decoder struct {
+	err error
+}
+
+func (d *decoder) bytes(item []byte) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	kind, content, _, err := rlp.Split(item)
+	if err != nil {
+		d.err = err
+		return nil
+	}
+	if kind == rlp.List {
+		d.err = errors.New("rlp: expected string")
+		return nil
+	}
+
+	return content
+}
+
+func (d *decoder) big(item []byte) *big.Int {
+	return new(big.Int).SetBytes(d.bytes(item))
+}
+
+func (d *decoder) uint(item []byte) uint64 {
+	b := d.bytes(item)
+	if len(b) > 8 {
+		d.err = errors.New("rlp: integer overflows 64 bits")
+		return 0
+	}
+
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+
+	return n
+}
+
+func (d *decoder) address(item []byte) []byte {
+	b := d.bytes(item)
+	if d.err != nil || len(b) == 0 {
+		return nil
+	}
+	if len(b) != 20 {
+		d.err = errors.New("invalid address length")
+		return nil
+	}
+
+	return b
+}
@@ -0,0 +1,72 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3/ecdsa"
+)
+
+func TestDecodeLegacy(t *testing.T) {
+	raw, _ := hex.DecodeString(eip155Tx)
+	tx, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hex.EncodeToString(tx.From) != "9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f" {
+		t.Fatalf("unexpected sender %x", tx.From)
+	}
+	if hex.EncodeToString(tx.To) != "3535353535353535353535353535353535353535" {
+		t.Fatalf("unexpected recipient %x", tx.To)
+	}
+	if tx.ChainID.Int64() != 1 || tx.Nonce != 9 || tx.Gas != 21000 {
+		t.Fatalf("unexpected fields %+v", tx)
+	}
+	if tx.Value.String() != "1000000000000000000" || tx.GasPrice.String() != "20000000000" {
+		t.Fatalf("unexpected value %s or gas price %s", tx.Value, tx.GasPrice)
+	}
+}
+
+func TestDecodeDynamicFee(t *testing.T) {
+	key := secp256k1.PrivKeyFromBytes(big.NewInt(0x4646).Bytes())
+	to := bytes.Repeat([]byte{0x35}, 20)
+	fields := [][]byte{
+		encode(uint(5)),           // chain id
+		encode(uint(1)),           // nonce
+		encode(uint(1000000000)),  // max priority fee per gas
+		encode(uint(30000000000)), // max fee per gas
+		encode(uint(21000)),       // gas
+		encode(to),
+		encode(uint(7)), // value
+		encode([]byte(nil)),
+		encodeList(nil), // access list
+	}
+
+	// the compact signature is 27 plus the y parity, r and s
+	signature := ecdsa.SignCompact(key, keccak256([]byte{DynamicFeeTxType}, encodeList(fields)), false)
+	fields = append(fields, encode(uint(signature[0]-27)), encode(new(big.Int).SetBytes(signature[1:33])), encode(new(big.Int).SetBytes(signature[33:])))
+	raw := append([]byte{DynamicFeeTxType}, encodeList(fields)...)
+
+	tx, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(tx.From, address(key)) {
+		t.Fatalf("expected sender %x, got %x", address(key), tx.From)
+	}
+	if tx.ChainID.Int64() != 5 || tx.GasPrice.Int64() != 30000000000 || tx.MaxPriorityFeePerGas.Int64() != 1000000000 || tx.Value.Int64() != 7 {
+		t.Fatalf("unexpected fields %+v", tx)
+	}
+	if !bytes.Equal(tx.Hash, keccak256(raw)) {
+		t.Fatal("unexpected hash")
+	}
+}
+
+func address(key *secp256k1.PrivateKey) []byte {
+	return keccak256(key.PubKey().SerializeUncompressed()[1:])[12:]
+}
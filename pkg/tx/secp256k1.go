@@ -0,0 +1,35 @@
+package tx
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v3"
+	"github.com/decred/dcrd/dcrec/secp256k1/v3/ecdsa"
+)
+
+// curveN is the order of the secp256k1 group
+var curveN = secp256k1.S256().N
+
+// recoverPublicKey returns the uncompressed public key, without the 0x04
+// prefix, that signed the hash with the signature r, s and recovery id.
+func recoverPublicKey(hash []byte, r, s *big.Int, recoveryID byte) ([]byte, error) {
+	if r.Sign() <= 0 || r.Cmp(curveN) >= 0 || s.Sign() <= 0 || s.Cmp(curveN) >= 0 || recoveryID > 1 {
+		return nil, errors.New("invalid signature")
+	}
+
+	// the compact format is the recovery code, 27 plus the recovery id for
+	// uncompressed keys, followed by r and s
+	signature := make([]byte, 65)
+	signature[0] = 27 + recoveryID
+	rBytes, sBytes := r.Bytes(), s.Bytes()
+	copy(signature[33-len(rBytes):33], rBytes)
+	copy(signature[65-len(sBytes):], sBytes)
+
+	publicKey, _, err := ecdsa.RecoverCompact(signature, hash)
+	if err != nil {
+		return nil, errors.New("invalid signature")
+	}
+
+	return publicKey.SerializeUncompressed()[1:], nil
+}
@@ -0,0 +1,66 @@
+package tx
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+// Transaction types
+const (
+	LegacyTxType     = 0x00
+	AccessListTxType = 0x01
+	DynamicFeeTxType = 0x02
+	BlobTxType       = 0x03
+)
+
+// ErrInvalidTransaction is returned for raw transactions that can't be decoded
+var ErrInvalidTransaction = errors.New("invalid transaction")
+
+// Hash returns the hash of a raw signed transaction as given to
+// eth_sendRawTransaction. Blob transactions in their network form, wrapped
+// with the blobs, are hashed without the wrapper.
+func Hash(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return nil, ErrInvalidTransaction
+	}
+
+	// legacy transactions are an RLP list
+	if raw[0] >= 0xC0 {
+		if _, rest, err := rlp.SplitList(raw); err != nil || len(rest) > 0 {
+			return nil, ErrInvalidTransaction
+		}
+		return keccak256(raw), nil
+	}
+
+	txType := raw[0]
+	if txType > 0x7F {
+		return nil, ErrInvalidTransaction
+	}
+
+	content, rest, err := rlp.SplitList(raw[1:])
+	if err != nil || len(rest) > 0 {
+		return nil, ErrInvalidTransaction
+	}
+
+	if txType == BlobTxType {
+		// the network form is [tx_payload_body, blobs, commitments, proofs]
+		if kind, _, inner, err := rlp.Split(content); err == nil && kind == rlp.List {
+			body := content[:len(content)-len(inner)]
+			return keccak256([]byte{txType}, body), nil
+		}
+	}
+
+	return keccak256(raw), nil
+}
+
+// keccak256 returns the Keccak-256 hash of the concatenated data
+func keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, b := range data {
+		h.Write(b)
+	}
+
+	return h.Sum(nil)
+}
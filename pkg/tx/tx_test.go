@@ -0,0 +1,58 @@
+package tx
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// eip155Tx is the signed example transaction from EIP-155
+const eip155Tx = "f86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+
+func TestHash(t *testing.T) {
+	raw, _ := hex.DecodeString(eip155Tx)
+	hash, err := Hash(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788"
+	if hex.EncodeToString(hash) != expected {
+		t.Fatalf("expected %s, got %x", expected, hash)
+	}
+
+	if _, err := Hash(raw[:len(raw)-1]); err == nil {
+		t.Fatal("expected truncated transaction to fail")
+	}
+}
+
+func TestHashBlobNetworkForm(t *testing.T) {
+	body := []byte{0xC3, 0x01, 0x02, 0x03}
+	blobs := []byte{0xC2, 0x81, 0xFF}
+	wrapped := append([]byte{BlobTxType, 0xC0 + byte(len(body)+len(blobs))}, append(body, blobs...)...)
+
+	hash, err := Hash(wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(hash, keccak256([]byte{BlobTxType}, body)) {
+		t.Fatal("expected the wrapper to be left out of the hash")
+	}
+}
+
+func TestKeccak256(t *testing.T) {
+	tests := []struct {
+		input    []byte
+		expected string
+	}{
+		{[]byte(""), "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{[]byte("abc"), "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+	}
+
+	for _, test := range tests {
+		if hash := hex.EncodeToString(keccak256(test.input)); hash != test.expected {
+			t.Fatalf("%q: expected %s, got %s", test.input, test.expected, hash)
+		}
+	}
+}